@@ -4,6 +4,8 @@
 
 package modbus
 
+import "context"
+
 type Client interface {
 	// Bit access
 
@@ -60,3 +62,29 @@ type Client interface {
 
 	WriteFileRecord(fileNumber uint16, recordNumber uint16, value []uint16, count uint16) (err error)
 }
+
+// ContextClient is a Client with context-aware variants of its methods.
+// The request is abandoned and ctx.Err() is returned when ctx is done
+// before the response is received. The clients created by NewClient
+// implement it, NewContextClient adapts the other clients.
+type ContextClient interface {
+	Client
+
+	ReadCoilsContext(ctx context.Context, address, quantity uint16) (results []byte, err error)
+	ReadDiscreteInputsContext(ctx context.Context, address, quantity uint16) (results []byte, err error)
+	WriteSingleCoilContext(ctx context.Context, address, value uint16) (results []byte, err error)
+	WriteMultipleCoilsContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error)
+	ReadInputRegistersContext(ctx context.Context, address, quantity uint16) (results []byte, err error)
+	ReadHoldingRegistersContext(ctx context.Context, address, quantity uint16) (results []byte, err error)
+	WriteSingleRegisterContext(ctx context.Context, address, value uint16) (results []byte, err error)
+	WriteMultipleRegistersContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error)
+	ReadWriteMultipleRegistersContext(ctx context.Context, readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error)
+	MaskWriteRegisterContext(ctx context.Context, address, andMask, orMask uint16) (results []byte, err error)
+	ReadFIFOQueueContext(ctx context.Context, address uint16) (results []byte, err error)
+	ReadDeviceIdentificationBasicContext(ctx context.Context) (BasicDeviceID, error)
+	ReadDeviceIdentificationRegularContext(ctx context.Context) (RegularDeviceID, error)
+	ReadDeviceIdentificationExtendedContext(ctx context.Context) (ExtendedDeviceID, error)
+	ReadDeviceIdentificationSpecificContext(ctx context.Context, objectID uint8) (value []byte, err error)
+	ReadDeviceIdentificationMapContext(ctx context.Context, readDeviceIDCode uint8) (objs map[uint8][]byte, err error)
+	WriteFileRecordContext(ctx context.Context, fileNumber uint16, recordNumber uint16, value []uint16, count uint16) (err error)
+}
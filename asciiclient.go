@@ -6,8 +6,8 @@ package modbus
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
-	"fmt"
 	"time"
 )
 
@@ -35,6 +35,16 @@ func NewASCIIClientHandler(address string) *ASCIIClientHandler {
 	return handler
 }
 
+// NewASCIIClientHandlerWithPort allocates and initializes a ASCIIClientHandler whose
+// port is opened by factory instead of a serial device.
+func NewASCIIClientHandlerWithPort(factory PortFactory) *ASCIIClientHandler {
+	handler := &ASCIIClientHandler{}
+	handler.PortFactory = factory
+	handler.Timeout = serialTimeout
+	handler.IdleTimeout = serialIdleTimeout
+	return handler
+}
+
 // ASCIIClient creates ASCII client with default handler and given connect string.
 func ASCIIClient(address string) Client {
 	handler := NewASCIIClientHandler(address)
@@ -54,6 +64,9 @@ type asciiPackager struct {
 //  LRC             : 2 chars
 //  End             : 2 chars
 func (mb *asciiPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
+	if err = checkBroadcast(mb.SlaveId, pdu.FunctionCode); err != nil {
+		return
+	}
 	var buf bytes.Buffer
 
 	if _, err = buf.WriteString(asciiStart); err != nil {
@@ -84,24 +97,24 @@ func (mb *asciiPackager) Verify(aduRequest []byte, aduResponse []byte) (err erro
 	length := len(aduResponse)
 	// Minimum size (including address, function and LRC)
 	if length < asciiMinSize+6 {
-		err = fmt.Errorf("modbus: response length '%v' does not meet minimum '%v'", length, 9)
+		err = newFrameError(ErrLengthMismatch, aduRequest, aduResponse, "modbus: response length '%v' does not meet minimum '%v'", length, 9)
 		return
 	}
 	// Length excluding colon must be an even number
 	if length%2 != 1 {
-		err = fmt.Errorf("modbus: response length '%v' is not an even number", length-1)
+		err = newFrameError(ErrInvalidFrame, aduRequest, aduResponse, "modbus: response length '%v' is not an even number", length-1)
 		return
 	}
 	// First char must be a colon
 	str := string(aduResponse[0:len(asciiStart)])
 	if str != asciiStart {
-		err = fmt.Errorf("modbus: response frame '%v'... is not started with '%v'", str, asciiStart)
+		err = newFrameError(ErrInvalidFrame, aduRequest, aduResponse, "modbus: response frame '%v'... is not started with '%v'", str, asciiStart)
 		return
 	}
 	// 2 last chars must be \r\n
 	str = string(aduResponse[len(aduResponse)-len(asciiEnd):])
 	if str != asciiEnd {
-		err = fmt.Errorf("modbus: response frame ...'%v' is not ended with '%v'", str, asciiEnd)
+		err = newFrameError(ErrInvalidFrame, aduRequest, aduResponse, "modbus: response frame ...'%v' is not ended with '%v'", str, asciiEnd)
 		return
 	}
 	// Slave id
@@ -114,12 +127,36 @@ func (mb *asciiPackager) Verify(aduRequest []byte, aduResponse []byte) (err erro
 		return
 	}
 	if responseVal != requestVal {
-		err = fmt.Errorf("modbus: response slave id '%v' does not match request '%v'", responseVal, requestVal)
+		err = newFrameError(ErrSlaveIdMismatch, aduRequest, aduResponse, "modbus: response slave id '%v' does not match request '%v'", responseVal, requestVal)
 		return
 	}
 	return
 }
 
+func (mb *asciiPackager) withSlaveId(slaveId byte) Packager {
+	return &asciiPackager{SlaveId: slaveId}
+}
+
+func (mb *asciiPackager) encodingSlaveId() (byte, bool) {
+	return mb.SlaveId, true
+}
+
+func (mb *asciiPackager) slaveIdOf(adu []byte) (byte, bool) {
+	if len(adu) < asciiMinSize {
+		return 0, false
+	}
+	slaveId, err := readHex(adu[1:])
+	return slaveId, err == nil
+}
+
+func (mb *asciiPackager) functionCodeOf(adu []byte) (byte, bool) {
+	if len(adu) < asciiMinSize {
+		return 0, false
+	}
+	functionCode, err := readHex(adu[3:])
+	return functionCode, err == nil
+}
+
 // Decode extracts PDU from ASCII frame and verify LRC.
 func (mb *asciiPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
 	pdu = &ProtocolDataUnit{}
@@ -149,7 +186,7 @@ func (mb *asciiPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
 	lrc.reset()
 	lrc.pushByte(address).pushByte(pdu.FunctionCode).pushBytes(pdu.Data)
 	if lrcVal != lrc.value() {
-		err = fmt.Errorf("modbus: response lrc '%v' does not match expected '%v'", lrcVal, lrc.value())
+		err = newFrameError(ErrLRCMismatch, nil, adu, "modbus: response lrc '%v' does not match expected '%v'", lrcVal, lrc.value())
 		return
 	}
 	return
@@ -161,20 +198,48 @@ type asciiSerialTransporter struct {
 }
 
 func (mb *asciiSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but abandons the request when ctx is done.
+func (mb *asciiSerialTransporter) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
 	mb.serialPort.mu.Lock()
 	defer mb.serialPort.mu.Unlock()
 
+	if tx := TransactionFromContext(ctx); tx != nil {
+		tx.Transport = "ascii"
+		tx.Address = mb.Address
+		defer func(connects int) {
+			tx.Reconnects = reconnects(connects, mb.serialPort.connects)
+		}(mb.serialPort.connects)
+	}
+
 	// Make sure port is connected
 	if err = mb.serialPort.connect(); err != nil {
 		return
 	}
+	if err = mb.serialPort.flush(); err != nil {
+		return
+	}
+	if err = mb.serialPort.setReadDeadline(); err != nil {
+		return
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	defer mb.serialPort.watch(ctx)(&aduResponse, &err)
 	// Start the timer to close when idle
 	mb.serialPort.lastActivity = time.Now()
 	mb.serialPort.startCloseTimer()
 
 	// Send the request
-	mb.serialPort.logf("modbus: sending %q\n", aduRequest)
-	if _, err = mb.port.Write(aduRequest); err != nil {
+	mb.serialPort.log(ctx, LogLevelDebug, "modbus: sending", LogField{"adu", string(aduRequest)})
+	if err = mb.serialPort.write(aduRequest); err != nil {
+		return
+	}
+	// Broadcast requests are not answered
+	if slaveId, _ := readHex(aduRequest[1:]); slaveId == 0 {
+		mb.serialPort.turnaround()
 		return
 	}
 	// Get the response
@@ -197,7 +262,7 @@ func (mb *asciiSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, e
 		}
 	}
 	aduResponse = data[:length]
-	mb.serialPort.logf("modbus: received %q\n", aduResponse)
+	mb.serialPort.log(ctx, LogLevelDebug, "modbus: received", LogField{"adu", string(aduResponse)})
 	return
 }
 
@@ -0,0 +1,161 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Bits is a sequence of coils or discrete inputs packed as in requests and
+// responses: eight per byte, least significant bit first, the last byte
+// padded with zeros.
+type Bits struct {
+	data []byte
+	n    int
+}
+
+// NewBits allocates n bits cleared.
+func NewBits(n int) Bits {
+	return Bits{data: make([]byte, (n+7)/8), n: n}
+}
+
+// BitsFromBools packs values.
+func BitsFromBools(values []bool) Bits {
+	bits := NewBits(len(values))
+	for i, value := range values {
+		bits.Set(i, value)
+	}
+	return bits
+}
+
+// BitsFromBytes returns the first n bits packed in data, as returned by
+// ReadCoils and ReadDiscreteInputs. data is shared, not copied. It
+// returns a ValidationError if data holds less than n bits.
+func BitsFromBytes(data []byte, n int) (bits Bits, err error) {
+	if n < 0 || len(data) < (n+7)/8 {
+		err = &ValidationError{
+			Field: "data size",
+			Value: len(data),
+			Min:   (n + 7) / 8,
+			Msg:   fmt.Sprintf("modbus: data size '%v' is too small for '%v' bits", len(data), n),
+		}
+		return
+	}
+	bits = Bits{data: data[:(n+7)/8], n: n}
+	return
+}
+
+// Len returns the number of bits.
+func (b Bits) Len() int {
+	return b.n
+}
+
+// Get returns bit i. It panics if i is out of range.
+func (b Bits) Get(i int) bool {
+	b.check(i)
+	return b.data[i/8]&(1<<uint(i%8)) != 0
+}
+
+// Set sets bit i to value. It panics if i is out of range.
+func (b Bits) Set(i int, value bool) {
+	b.check(i)
+	if value {
+		b.data[i/8] |= 1 << uint(i%8)
+	} else {
+		b.data[i/8] &^= 1 << uint(i%8)
+	}
+}
+
+func (b Bits) check(i int) {
+	if i < 0 || i >= b.n {
+		panic(fmt.Sprintf("modbus: bit index %v out of range [0, %v)", i, b.n))
+	}
+}
+
+// Range calls f with each bit in order until f returns false.
+func (b Bits) Range(f func(i int, value bool) bool) {
+	for i := 0; i < b.n; i++ {
+		if !f(i, b.data[i/8]&(1<<uint(i%8)) != 0) {
+			return
+		}
+	}
+}
+
+// Bools returns the bits unpacked.
+func (b Bits) Bools() []bool {
+	values := make([]bool, b.n)
+	b.Range(func(i int, value bool) bool {
+		values[i] = value
+		return true
+	})
+	return values
+}
+
+// Bytes returns the packed bits, as expected by WriteMultipleCoils.
+func (b Bits) Bytes() []byte {
+	return b.data
+}
+
+// String returns the bits as 0 and 1 in order, e.g. "1011".
+func (b Bits) String() string {
+	var s strings.Builder
+	s.Grow(b.n)
+	b.Range(func(i int, value bool) bool {
+		if value {
+			s.WriteByte('1')
+		} else {
+			s.WriteByte('0')
+		}
+		return true
+	})
+	return s.String()
+}
+
+// ReadCoilsBool reads quantity coils from address with client like
+// ReadCoils and returns them unpacked, one bool per coil.
+func ReadCoilsBool(ctx context.Context, client Client, address, quantity uint16) (values []bool, err error) {
+	results, err := NewContextClient(client).ReadCoilsContext(ctx, address, quantity)
+	if err != nil {
+		return
+	}
+	return unpackBits(results, quantity)
+}
+
+// ReadDiscreteInputsBool reads quantity discrete inputs from address with
+// client like ReadDiscreteInputs and returns them unpacked.
+func ReadDiscreteInputsBool(ctx context.Context, client Client, address, quantity uint16) (values []bool, err error) {
+	results, err := NewContextClient(client).ReadDiscreteInputsContext(ctx, address, quantity)
+	if err != nil {
+		return
+	}
+	return unpackBits(results, quantity)
+}
+
+// unpackBits unpacks quantity bits of a response.
+func unpackBits(results []byte, quantity uint16) (values []bool, err error) {
+	if len(results) != (int(quantity)+7)/8 {
+		err = newFrameError(ErrLengthMismatch, nil, nil, "modbus: response data size '%v' does not match quantity '%v'", len(results), quantity)
+		return
+	}
+	bits, err := BitsFromBytes(results, int(quantity))
+	if err != nil {
+		return
+	}
+	values = bits.Bools()
+	return
+}
+
+// WriteMultipleCoilsBool writes values to consecutive coils from address
+// with client like WriteMultipleCoils, the quantity being the number of
+// values.
+func WriteMultipleCoilsBool(ctx context.Context, client Client, address uint16, values []bool) (results []byte, err error) {
+	if len(values) < 1 || len(values) > 1968 {
+		err = newRangeError("quantity", len(values), 1, 1968)
+		return
+	}
+	return NewContextClient(client).WriteMultipleCoilsContext(ctx, address, uint16(len(values)), BitsFromBools(values).Bytes())
+}
@@ -0,0 +1,95 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBits(t *testing.T) {
+	values := []bool{true, false, true, true, false, false, false, false, true, true}
+	bits := BitsFromBools(values)
+	if !reflect.DeepEqual([]byte{0x0D, 0x03}, bits.Bytes()) {
+		t.Fatalf("expected % x, actual % x", []byte{0x0D, 0x03}, bits.Bytes())
+	}
+	if bits.Len() != 10 || bits.String() != "1011000011" {
+		t.Fatalf("unexpected bits %v", bits)
+	}
+	bits.Set(0, false)
+	bits.Set(9, false)
+	bits.Set(4, true)
+	if !bits.Get(4) || bits.Get(0) || !reflect.DeepEqual([]byte{0x1C, 0x01}, bits.Bytes()) {
+		t.Fatalf("unexpected bits % x", bits.Bytes())
+	}
+
+	var set []int
+	bits.Range(func(i int, value bool) bool {
+		if value {
+			set = append(set, i)
+		}
+		return i < 4
+	})
+	if !reflect.DeepEqual([]int{2, 3, 4}, set) {
+		t.Fatalf("expected %v, actual %v", []int{2, 3, 4}, set)
+	}
+
+	bits, err := BitsFromBytes([]byte{0xCD, 0x6B, 0x05}, 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bits.String() != "1011001111010110101" {
+		t.Fatalf("unexpected bits %v", bits)
+	}
+	if !reflect.DeepEqual(bits.Bools(), BitsFromBools(bits.Bools()).Bools()) {
+		t.Fatal("bits do not round trip")
+	}
+	var validationError *ValidationError
+	if _, err = BitsFromBytes([]byte{0xCD}, 9); !errors.As(err, &validationError) {
+		t.Fatalf("expected validation error, actual %v", err)
+	}
+}
+
+func TestBitsOutOfRange(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	NewBits(3).Get(3)
+}
+
+func TestCoilsBool(t *testing.T) {
+	device := &testDevice{}
+	client := NewClient(device)
+	ctx := context.Background()
+	values := []bool{true, false, false, true, true, false, true, false, false, true, true}
+	if _, err := WriteMultipleCoilsBool(ctx, client, 5, values); err != nil {
+		t.Fatal(err)
+	}
+	if !device.coils[5] || device.coils[6] || !device.coils[15] || device.coils[16] {
+		t.Fatalf("unexpected coils %v", device.coils[:20])
+	}
+	results, err := ReadCoilsBool(ctx, client, 5, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, results) {
+		t.Fatalf("expected %v, actual %v", values, results)
+	}
+	if _, err = WriteMultipleCoilsBool(ctx, client, 0, nil); err == nil {
+		t.Fatal("expected error")
+	}
+
+	device.inputs[2] = true
+	if results, err = ReadDiscreteInputsBool(ctx, client, 0, 3); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]bool{false, false, true}, results) {
+		t.Fatalf("unexpected inputs %v", results)
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// Default circuit breaker settings
+	breakerFailureThreshold = 5
+	breakerOpenTimeout      = 30 * time.Second
+	breakerHalfOpenRequests = 1
+)
+
+// ErrBreakerOpen is returned without sending the request while the
+// circuit breaker of the slave is open.
+var ErrBreakerOpen = errors.New("modbus: circuit breaker is open")
+
+// BreakerState is the state of the circuit breaker of one slave.
+type BreakerState int
+
+const (
+	// BreakerClosed lets all requests through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails all requests fast.
+	BreakerOpen
+	// BreakerHalfOpen lets probe requests through to test the slave.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// BreakerStatus describes the health of one slave.
+type BreakerStatus struct {
+	SlaveId byte
+	State   BreakerState
+	// Consecutive failures
+	Failures    int
+	LastError   error
+	LastFailure time.Time
+	// Time the breaker last opened
+	OpenedAt time.Time
+}
+
+// BreakerHandler implements Packager and Transporter interface. It keeps
+// a circuit breaker per slave id which opens after FailureThreshold
+// consecutive failures, so that requests to a dead device fail fast
+// instead of waiting for Timeout. After OpenTimeout the breaker lets
+// probe requests through and closes again when they succeed.
+//
+// Timeouts, broken connections, corrupted frames and the exception
+// gateway target device failed to respond count as failures. Other
+// exceptions prove that the device is alive.
+type BreakerHandler struct {
+	ClientHandler
+
+	// Consecutive failures to open the breaker, defaults to 5
+	FailureThreshold int
+	// Time to fail fast before probing the slave, defaults to 30s
+	OpenTimeout time.Duration
+	// Successful probes in a row to close the breaker, which is also the
+	// number of probes allowed in flight
+	HalfOpenRequests int
+	// OnStateChange, if set, is called when the breaker of a slave changes
+	// state. It must not call the handler.
+	OnStateChange func(slaveId byte, from, to BreakerState)
+
+	mu      sync.Mutex
+	breaker map[byte]*breaker
+}
+
+// breaker is the state of one slave. Access is guarded by the handler mutex.
+type breaker struct {
+	status    BreakerStatus
+	probes    int
+	successes int
+}
+
+// NewBreakerHandler allocates a BreakerHandler around handler with the
+// default settings.
+func NewBreakerHandler(handler ClientHandler) *BreakerHandler {
+	return &BreakerHandler{
+		ClientHandler:    handler,
+		FailureThreshold: breakerFailureThreshold,
+		OpenTimeout:      breakerOpenTimeout,
+		HalfOpenRequests: breakerHalfOpenRequests,
+	}
+}
+
+// Send sends the request unless the breaker of the slave is open.
+func (mb *BreakerHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but abandons the request when ctx is done.
+// Requests abandoned by the caller count as neither success nor failure.
+func (mb *BreakerHandler) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	slaveId, _ := aduSlaveId(mb.ClientHandler, aduRequest)
+	probe, err := mb.allow(slaveId)
+	if err != nil {
+		return
+	}
+	aduResponse, err = sendContext(ctx, mb.ClientHandler, aduRequest)
+	failure := err
+	if failure == nil {
+		failure = checkResponse(mb.ClientHandler, aduRequest, aduResponse)
+		var e *ModbusError
+		if errors.As(failure, &e) && e.ExceptionCode != ExceptionCodeGatewayTargetDeviceFailedToRespond {
+			failure = nil
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		mb.abandon(slaveId, probe)
+		return
+	}
+	mb.record(slaveId, probe, failure)
+	return
+}
+
+// Status returns the health of the slave.
+func (mb *BreakerHandler) Status(slaveId byte) BreakerStatus {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if b, ok := mb.breaker[slaveId]; ok {
+		return mb.status(b)
+	}
+	return BreakerStatus{SlaveId: slaveId}
+}
+
+// Statuses returns the health of all slaves which have been addressed.
+func (mb *BreakerHandler) Statuses() []BreakerStatus {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(mb.breaker))
+	for slaveId := 0; slaveId <= 0xFF; slaveId++ {
+		if b, ok := mb.breaker[byte(slaveId)]; ok {
+			statuses = append(statuses, mb.status(b))
+		}
+	}
+	return statuses
+}
+
+// Reset closes the breaker of the slave.
+func (mb *BreakerHandler) Reset(slaveId byte) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	b, ok := mb.breaker[slaveId]
+	if !ok {
+		return
+	}
+	b.status.Failures = 0
+	b.probes = 0
+	b.successes = 0
+	mb.setState(b, BreakerClosed)
+}
+
+// Close closes the wrapped handler if it can be closed.
+func (mb *BreakerHandler) Close() error {
+	if closer, ok := mb.ClientHandler.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (mb *BreakerHandler) slaveIdOf(adu []byte) (byte, bool) {
+	return aduSlaveId(mb.ClientHandler, adu)
+}
+
+func (mb *BreakerHandler) encodingSlaveId() (byte, bool) {
+	return packagerSlaveId(mb.ClientHandler)
+}
+
+func (mb *BreakerHandler) functionCodeOf(adu []byte) (byte, bool) {
+	return aduFunctionCode(mb.ClientHandler, adu)
+}
+
+func (mb *BreakerHandler) withSlaveId(slaveId byte) Packager {
+	packager, _ := packagerWithSlaveId(mb.ClientHandler, slaveId)
+	return packager
+}
+
+func (mb *BreakerHandler) setTimeout(timeout time.Duration) (time.Duration, bool) {
+	return setHandlerTimeout(mb.ClientHandler, timeout)
+}
+
+// allow reports whether a request to the slave may be sent and whether
+// it is a probe of a half-open breaker.
+func (mb *BreakerHandler) allow(slaveId byte) (probe bool, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	b := mb.get(slaveId)
+	if mb.expired(b) {
+		b.probes = 0
+		b.successes = 0
+		mb.setState(b, BreakerHalfOpen)
+	}
+	switch b.status.State {
+	case BreakerOpen:
+		err = ErrBreakerOpen
+	case BreakerHalfOpen:
+		if b.probes >= mb.halfOpenRequests() {
+			err = ErrBreakerOpen
+			return
+		}
+		b.probes++
+		probe = true
+	}
+	return
+}
+
+// abandon releases the probe of a request abandoned by the caller.
+func (mb *BreakerHandler) abandon(slaveId byte, probe bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	b := mb.get(slaveId)
+	if probe && b.status.State == BreakerHalfOpen {
+		b.probes--
+	}
+}
+
+// record updates the breaker of the slave with the request outcome.
+func (mb *BreakerHandler) record(slaveId byte, probe bool, failure error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	b := mb.get(slaveId)
+	if probe && b.status.State == BreakerHalfOpen {
+		b.probes--
+	}
+	if failure == nil {
+		b.status.Failures = 0
+		if b.status.State == BreakerHalfOpen {
+			b.successes++
+			if b.successes >= mb.halfOpenRequests() {
+				mb.setState(b, BreakerClosed)
+			}
+		}
+		return
+	}
+	b.status.Failures++
+	b.status.LastError = failure
+	b.status.LastFailure = time.Now()
+	if b.status.State == BreakerHalfOpen || b.status.Failures >= mb.failureThreshold() {
+		b.status.OpenedAt = b.status.LastFailure
+		mb.setState(b, BreakerOpen)
+	}
+}
+
+// get returns the breaker of the slave. Caller must hold the mutex.
+func (mb *BreakerHandler) get(slaveId byte) *breaker {
+	if mb.breaker == nil {
+		mb.breaker = make(map[byte]*breaker)
+	}
+	b, ok := mb.breaker[slaveId]
+	if !ok {
+		b = &breaker{status: BreakerStatus{SlaveId: slaveId}}
+		mb.breaker[slaveId] = b
+	}
+	return b
+}
+
+// expired reports whether the breaker is open since OpenTimeout and lets
+// probes through. Caller must hold the mutex.
+func (mb *BreakerHandler) expired(b *breaker) bool {
+	return b.status.State == BreakerOpen && time.Now().Sub(b.status.OpenedAt) >= mb.openTimeout()
+}
+
+// status returns the status of the breaker, half-open once it has expired
+// even if no request has been sent since. Caller must hold the mutex.
+func (mb *BreakerHandler) status(b *breaker) BreakerStatus {
+	status := b.status
+	if mb.expired(b) {
+		status.State = BreakerHalfOpen
+	}
+	return status
+}
+
+// setState changes the state of the breaker. Caller must hold the mutex.
+func (mb *BreakerHandler) setState(b *breaker, state BreakerState) {
+	from := b.status.State
+	b.status.State = state
+	if from != state && mb.OnStateChange != nil {
+		mb.OnStateChange(b.status.SlaveId, from, state)
+	}
+}
+
+func (mb *BreakerHandler) failureThreshold() int {
+	if mb.FailureThreshold < 1 {
+		return breakerFailureThreshold
+	}
+	return mb.FailureThreshold
+}
+
+func (mb *BreakerHandler) openTimeout() time.Duration {
+	if mb.OpenTimeout <= 0 {
+		return breakerOpenTimeout
+	}
+	return mb.OpenTimeout
+}
+
+func (mb *BreakerHandler) halfOpenRequests() int {
+	if mb.HalfOpenRequests < 1 {
+		return 1
+	}
+	return mb.HalfOpenRequests
+}
@@ -0,0 +1,92 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerHandler(t *testing.T) {
+	handler := &scriptedHandler{
+		responses: []*ProtocolDataUnit{
+			nil,
+			nil,
+			{FunctionCode: 0x03, Data: []byte{2, 0x12, 0x34}},
+		},
+	}
+	handler.SlaveId = 7
+	breaker := NewBreakerHandler(handler)
+	breaker.FailureThreshold = 2
+	breaker.OpenTimeout = 50 * time.Millisecond
+	client := NewClient(breaker)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.ReadHoldingRegisters(1, 1); err == nil {
+			t.Fatal("timeout is expected to fail")
+		}
+	}
+	status := breaker.Status(7)
+	if status.State != BreakerOpen || status.Failures != 2 || status.LastError == nil {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if _, err := client.ReadHoldingRegisters(1, 1); err != ErrBreakerOpen {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if handler.requests != 2 {
+		t.Fatalf("requests: expected %v, actual %v", 2, handler.requests)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	// Reported half-open before the next request
+	if status = breaker.Status(7); status.State != BreakerHalfOpen {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if statuses := breaker.Statuses(); len(statuses) != 1 || statuses[0].State != BreakerHalfOpen {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+	if _, err := client.ReadHoldingRegisters(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if status = breaker.Status(7); status.State != BreakerClosed || status.Failures != 0 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if statuses := breaker.Statuses(); len(statuses) != 1 || statuses[0].SlaveId != 7 {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+}
+
+func TestBreakerHandlerZeroValue(t *testing.T) {
+	handler := &scriptedHandler{responses: []*ProtocolDataUnit{nil, nil, nil, nil, nil}}
+	handler.SlaveId = 7
+	breaker := &BreakerHandler{ClientHandler: handler}
+	client := NewClient(breaker)
+
+	if status := breaker.Status(3); status.SlaveId != 3 || status.State != BreakerClosed {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	breaker.Reset(4)
+	if statuses := breaker.Statuses(); len(statuses) != 0 {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.ReadHoldingRegisters(1, 1); err == nil {
+			t.Fatal("timeout is expected to fail")
+		}
+	}
+	if status := breaker.Status(7); status.State != BreakerClosed || status.Failures != 2 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	// The breaker stays open for the default timeout
+	for i := 0; i < 3; i++ {
+		client.ReadHoldingRegisters(1, 1)
+	}
+	if _, err := client.ReadHoldingRegisters(1, 1); err != ErrBreakerOpen {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status := breaker.Status(7); status.State != BreakerOpen {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Priority orders the transactions waiting for a SerialBus. Transactions
+// with a higher priority are sent first, transactions with the same
+// priority in the order they were queued.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context which sends the request with priority on
+// a SerialBus, overriding the priority of the BusHandler.
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// SerialBus shares the serial port of one RTU or ASCII handler among the
+// clients of several slaves. Transactions are serialized in order of
+// priority and separated by InterFrameDelay of silence on the bus.
+type SerialBus struct {
+	// InterFrameDelay is the silence kept between the end of a transaction
+	// and the next request, defaults to 3.5 characters (t3.5) at BaudRate.
+	InterFrameDelay time.Duration
+
+	transporter Transporter
+	port        *serialPort
+	newPackager func(slaveId byte) Packager
+
+	mu      sync.Mutex
+	busy    bool
+	waiters []*busWaiter
+	seq     uint64
+	last    time.Time
+}
+
+// busWaiter is a transaction waiting for the bus.
+type busWaiter struct {
+	priority Priority
+	seq      uint64
+	ready    chan struct{}
+}
+
+// NewRTUBus allocates a SerialBus on the port of handler, whose SlaveId
+// is ignored.
+func NewRTUBus(handler *RTUClientHandler) *SerialBus {
+	return &SerialBus{
+		transporter: &handler.rtuSerialTransporter,
+		port:        &handler.serialPort,
+		newPackager: func(slaveId byte) Packager {
+			return &rtuPackager{SlaveId: slaveId}
+		},
+	}
+}
+
+// NewASCIIBus allocates a SerialBus on the port of handler, whose SlaveId
+// is ignored.
+func NewASCIIBus(handler *ASCIIClientHandler) *SerialBus {
+	return &SerialBus{
+		transporter: &handler.asciiSerialTransporter,
+		port:        &handler.serialPort,
+		newPackager: func(slaveId byte) Packager {
+			return &asciiPackager{SlaveId: slaveId}
+		},
+	}
+}
+
+// Handler returns a handler sending the requests of a client to slaveId
+// on the bus.
+func (b *SerialBus) Handler(slaveId byte) *BusHandler {
+	return &BusHandler{
+		Packager: b.newPackager(slaveId),
+		bus:      b,
+	}
+}
+
+// Connect opens the port of the bus.
+func (b *SerialBus) Connect() error {
+	return b.port.Connect()
+}
+
+// Close closes the port of the bus.
+func (b *SerialBus) Close() error {
+	return b.port.Close()
+}
+
+// send sends aduRequest once the bus is granted to the request.
+func (b *SerialBus) send(ctx context.Context, priority Priority, aduRequest []byte) (aduResponse []byte, err error) {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		priority = p
+	}
+	if err = b.acquire(ctx, priority); err != nil {
+		return
+	}
+	defer b.release()
+
+	if wait := b.interFrameDelay() - time.Since(b.last); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+	return sendContext(ctx, b.transporter, aduRequest)
+}
+
+// interFrameDelay returns InterFrameDelay or its default.
+func (b *SerialBus) interFrameDelay() time.Duration {
+	if b.InterFrameDelay > 0 {
+		return b.InterFrameDelay
+	}
+	_, t35 := rtuTimings(b.port.BaudRate)
+	return t35
+}
+
+// acquire waits until the bus is granted to a transaction of priority.
+func (b *SerialBus) acquire(ctx context.Context, priority Priority) error {
+	b.mu.Lock()
+	if !b.busy {
+		b.busy = true
+		b.mu.Unlock()
+		return nil
+	}
+	w := &busWaiter{priority: priority, seq: b.seq, ready: make(chan struct{})}
+	b.seq++
+	b.waiters = append(b.waiters, w)
+	b.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+	b.mu.Lock()
+	select {
+	case <-w.ready:
+		// Granted while giving up, pass the bus on
+		b.mu.Unlock()
+		b.release()
+	default:
+		for i, waiter := range b.waiters {
+			if waiter == w {
+				b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+				break
+			}
+		}
+		b.mu.Unlock()
+	}
+	return ctx.Err()
+}
+
+// release grants the bus to the waiting transaction with the highest
+// priority, if any.
+func (b *SerialBus) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.last = time.Now()
+	if len(b.waiters) == 0 {
+		b.busy = false
+		return
+	}
+	next := 0
+	for i, w := range b.waiters {
+		if w.priority > b.waiters[next].priority {
+			next = i
+		}
+	}
+	w := b.waiters[next]
+	b.waiters = append(b.waiters[:next], b.waiters[next+1:]...)
+	close(w.ready)
+}
+
+// BusHandler implements Packager and Transporter interface. It sends the
+// requests of one slave on a SerialBus.
+type BusHandler struct {
+	Packager
+	// Priority of the requests, unless set in their context with
+	// WithPriority.
+	Priority Priority
+
+	bus *SerialBus
+}
+
+// Send sends the request on the bus.
+func (mb *BusHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but stops waiting for the bus or the response
+// when ctx is done.
+func (mb *BusHandler) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.bus.send(ctx, mb.Priority, aduRequest)
+}
+
+// Close does nothing, the port is owned by the bus.
+func (mb *BusHandler) Close() error {
+	return nil
+}
+
+func (mb *BusHandler) slaveIdOf(adu []byte) (byte, bool) {
+	return aduSlaveId(mb.Packager, adu)
+}
+
+func (mb *BusHandler) encodingSlaveId() (byte, bool) {
+	return packagerSlaveId(mb.Packager)
+}
+
+func (mb *BusHandler) functionCodeOf(adu []byte) (byte, bool) {
+	return aduFunctionCode(mb.Packager, adu)
+}
+
+func (mb *BusHandler) withSlaveId(slaveId byte) Packager {
+	packager, _ := packagerWithSlaveId(mb.Packager, slaveId)
+	return packager
+}
+
+// setTimeout replaces the Timeout of the port of the bus.
+func (mb *BusHandler) setTimeout(timeout time.Duration) (time.Duration, bool) {
+	return mb.bus.port.setTimeout(timeout)
+}
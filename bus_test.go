@@ -0,0 +1,120 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slavePort answers reads of one holding register with the slave id.
+type slavePort struct {
+	busPort
+
+	requests [][]byte
+}
+
+func (p *slavePort) Write(b []byte) (int, error) {
+	p.requests = append(p.requests, append([]byte(nil), b...))
+	response := []byte{b[0], 0x03, 0x02, 0x00, b[0]}
+	var crc crc
+	crc.reset().pushBytes(response)
+	checksum := crc.value()
+	p.input.Write(append(response, byte(checksum), byte(checksum>>8)))
+	return len(b), nil
+}
+
+func TestSerialBus(t *testing.T) {
+	port := &slavePort{}
+	handler := NewRTUClientHandlerWithPort(func() (io.ReadWriteCloser, error) {
+		return port, nil
+	})
+	bus := NewRTUBus(handler)
+	bus.InterFrameDelay = time.Millisecond
+	defer bus.Close()
+
+	var wg sync.WaitGroup
+	for slaveId := byte(1); slaveId <= 4; slaveId++ {
+		wg.Add(1)
+		go func(slaveId byte) {
+			defer wg.Done()
+			client := NewClient(bus.Handler(slaveId))
+			for i := 0; i < 5; i++ {
+				results, err := client.ReadHoldingRegisters(0, 1)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if expected := []byte{0, slaveId}; !bytes.Equal(expected, results) {
+					t.Errorf("results: expected % x, actual % x", expected, results)
+				}
+			}
+		}(slaveId)
+	}
+	wg.Wait()
+	if len(port.requests) != 20 {
+		t.Fatalf("requests: expected %v, actual %v", 20, len(port.requests))
+	}
+}
+
+func TestSerialBusPriority(t *testing.T) {
+	bus := &SerialBus{}
+	if err := bus.acquire(context.Background(), PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var order []Priority
+	var wg sync.WaitGroup
+	for _, priority := range []Priority{PriorityLow, PriorityNormal, PriorityHigh} {
+		wg.Add(1)
+		go func(priority Priority) {
+			defer wg.Done()
+			if err := bus.acquire(context.Background(), priority); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, priority)
+			mu.Unlock()
+			bus.release()
+		}(priority)
+		// Queue the waiters in order
+		for {
+			bus.mu.Lock()
+			n := len(bus.waiters)
+			bus.mu.Unlock()
+			if n == int(priority)+2 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	bus.release()
+	wg.Wait()
+	if expected := []Priority{PriorityHigh, PriorityNormal, PriorityLow}; len(order) != 3 ||
+		order[0] != expected[0] || order[1] != expected[1] || order[2] != expected[2] {
+		t.Fatalf("order: expected %v, actual %v", expected, order)
+	}
+}
+
+func TestSerialBusCancel(t *testing.T) {
+	bus := &SerialBus{}
+	if err := bus.acquire(context.Background(), PriorityNormal); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := bus.acquire(ctx, PriorityHigh); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, actual %v", err)
+	}
+	bus.release()
+	if bus.busy || len(bus.waiters) != 0 {
+		t.Fatalf("bus is not released: %+v", bus)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ReadCache holds the results of reads for TTL, to be shared by the
+// CachingClients of the services polling the same devices.
+type ReadCache struct {
+	// TTL is how long a result is served from the cache.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[cacheKey][]*cacheEntry
+	// generations count the invalidations of each key, so that reads
+	// sent before a write are not cached after it.
+	generations map[cacheKey]uint64
+}
+
+// cacheKey identifies an address space of a slave.
+type cacheKey struct {
+	slaveId byte
+	space   AddressSpace
+}
+
+// cacheEntry is the result of a read of [start, end).
+type cacheEntry struct {
+	start, end int
+	data       []byte
+	expires    time.Time
+}
+
+// NewReadCache allocates a ReadCache serving results for ttl.
+func NewReadCache(ttl time.Duration) *ReadCache {
+	return &ReadCache{TTL: ttl}
+}
+
+// Client returns a client reading through the cache with handler. The
+// results are cached for the slave handler sends each request to, so
+// that the clients of the same slave share them whatever the handler.
+func (c *ReadCache) Client(handler ClientHandler, interceptors ...Interceptor) *CachingClient {
+	return &CachingClient{
+		ContextClient: NewContextClient(NewClient(handler, interceptors...)),
+		cache:         c,
+		packager:      handler,
+	}
+}
+
+// get returns the bits or registers of [start, end) from an entry
+// holding them and not expired.
+func (c *ReadCache) get(key cacheKey, start, end int) (data []byte, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, entry := range c.entries[key] {
+		if entry.start <= start && end <= entry.end && now.Before(entry.expires) {
+			return entry.slice(key.space, start, end), true
+		}
+	}
+	return
+}
+
+// generation returns the number of invalidations of key.
+func (c *ReadCache) generation(key cacheKey) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generations[key]
+}
+
+// put caches the result of a read of [start, end) sent at generation,
+// unless the key has been invalidated since. Expired entries and the
+// entries within [start, end) are dropped.
+func (c *ReadCache) put(key cacheKey, start, end int, data []byte, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations[key] != generation {
+		return
+	}
+	if c.entries == nil {
+		c.entries = make(map[cacheKey][]*cacheEntry)
+	}
+	now := time.Now()
+	entries := c.entries[key][:0]
+	for _, entry := range c.entries[key] {
+		if now.Before(entry.expires) && (entry.start < start || end < entry.end) {
+			entries = append(entries, entry)
+		}
+	}
+	c.entries[key] = append(entries, &cacheEntry{
+		start:   start,
+		end:     end,
+		data:    append([]byte(nil), data...),
+		expires: now.Add(c.TTL),
+	})
+}
+
+// invalidate drops the entries overlapping [start, end).
+func (c *ReadCache) invalidate(key cacheKey, start, end int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations == nil {
+		c.generations = make(map[cacheKey]uint64)
+	}
+	c.generations[key]++
+	entries := c.entries[key][:0]
+	for _, entry := range c.entries[key] {
+		if entry.end <= start || end <= entry.start {
+			entries = append(entries, entry)
+		}
+	}
+	c.entries[key] = entries
+}
+
+// slice returns a copy of the bits or registers of [start, end).
+func (e *cacheEntry) slice(space AddressSpace, start, end int) []byte {
+	offset := start - e.start
+	if !space.IsBit() {
+		return append([]byte(nil), e.data[2*offset:2*(end-e.start)]...)
+	}
+	bits, _ := BitsFromBytes(e.data, e.end-e.start)
+	slice := NewBits(end - start)
+	for i := 0; i < slice.Len(); i++ {
+		slice.Set(i, bits.Get(offset+i))
+	}
+	return slice.Bytes()
+}
+
+// CachingClient is a Client serving reads of coils, discrete inputs,
+// holding and input registers from a ReadCache, including reads of part
+// of a result cached. The writes through it invalidate the results
+// they overlap, the writes through other clients do not.
+type CachingClient struct {
+	ContextClient
+
+	cache    *ReadCache
+	packager Packager
+}
+
+// NewCachingClient allocates a CachingClient of handler with a cache of
+// its own serving results for ttl.
+func NewCachingClient(handler ClientHandler, ttl time.Duration, interceptors ...Interceptor) *CachingClient {
+	return NewReadCache(ttl).Client(handler, interceptors...)
+}
+
+// key returns the key of space for the slave the packager currently
+// encodes the requests for, which may change with its SlaveId. The slave
+// id is zero if the packager does not tell it.
+func (mb *CachingClient) key(space AddressSpace) cacheKey {
+	slaveId, _ := packagerSlaveId(mb.packager)
+	return cacheKey{slaveId, space}
+}
+
+// read reads through the cache.
+func (mb *CachingClient) read(ctx context.Context, space AddressSpace, address, quantity uint16,
+	read func(ctx context.Context, address, quantity uint16) ([]byte, error)) (results []byte, err error) {
+	key := mb.key(space)
+	start, end := int(address), int(address)+int(quantity)
+	if quantity > 0 {
+		if data, ok := mb.cache.get(key, start, end); ok {
+			return data, nil
+		}
+	}
+	generation := mb.cache.generation(key)
+	if results, err = read(ctx, address, quantity); err != nil {
+		return
+	}
+	mb.cache.put(key, start, end, results, generation)
+	return
+}
+
+// invalidate drops the results overlapping quantity addresses from
+// address once a write is done, whether it fails or not.
+func (mb *CachingClient) invalidate(space AddressSpace, address, quantity uint16) {
+	mb.cache.invalidate(mb.key(space), int(address), int(address)+int(quantity))
+}
+
+func (mb *CachingClient) ReadCoils(address, quantity uint16) (results []byte, err error) {
+	return mb.ReadCoilsContext(context.Background(), address, quantity)
+}
+
+func (mb *CachingClient) ReadCoilsContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
+	return mb.read(ctx, SpaceCoils, address, quantity, mb.ContextClient.ReadCoilsContext)
+}
+
+func (mb *CachingClient) ReadDiscreteInputs(address, quantity uint16) (results []byte, err error) {
+	return mb.ReadDiscreteInputsContext(context.Background(), address, quantity)
+}
+
+func (mb *CachingClient) ReadDiscreteInputsContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
+	return mb.read(ctx, SpaceDiscreteInputs, address, quantity, mb.ContextClient.ReadDiscreteInputsContext)
+}
+
+func (mb *CachingClient) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	return mb.ReadHoldingRegistersContext(context.Background(), address, quantity)
+}
+
+func (mb *CachingClient) ReadHoldingRegistersContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
+	return mb.read(ctx, SpaceHoldingRegisters, address, quantity, mb.ContextClient.ReadHoldingRegistersContext)
+}
+
+func (mb *CachingClient) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
+	return mb.ReadInputRegistersContext(context.Background(), address, quantity)
+}
+
+func (mb *CachingClient) ReadInputRegistersContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
+	return mb.read(ctx, SpaceInputRegisters, address, quantity, mb.ContextClient.ReadInputRegistersContext)
+}
+
+func (mb *CachingClient) WriteSingleCoil(address, value uint16) (results []byte, err error) {
+	return mb.WriteSingleCoilContext(context.Background(), address, value)
+}
+
+func (mb *CachingClient) WriteSingleCoilContext(ctx context.Context, address, value uint16) (results []byte, err error) {
+	defer mb.invalidate(SpaceCoils, address, 1)
+	return mb.ContextClient.WriteSingleCoilContext(ctx, address, value)
+}
+
+func (mb *CachingClient) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
+	return mb.WriteMultipleCoilsContext(context.Background(), address, quantity, value)
+}
+
+func (mb *CachingClient) WriteMultipleCoilsContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error) {
+	defer mb.invalidate(SpaceCoils, address, quantity)
+	return mb.ContextClient.WriteMultipleCoilsContext(ctx, address, quantity, value)
+}
+
+func (mb *CachingClient) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	return mb.WriteSingleRegisterContext(context.Background(), address, value)
+}
+
+func (mb *CachingClient) WriteSingleRegisterContext(ctx context.Context, address, value uint16) (results []byte, err error) {
+	defer mb.invalidate(SpaceHoldingRegisters, address, 1)
+	return mb.ContextClient.WriteSingleRegisterContext(ctx, address, value)
+}
+
+func (mb *CachingClient) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	return mb.WriteMultipleRegistersContext(context.Background(), address, quantity, value)
+}
+
+func (mb *CachingClient) WriteMultipleRegistersContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error) {
+	defer mb.invalidate(SpaceHoldingRegisters, address, quantity)
+	return mb.ContextClient.WriteMultipleRegistersContext(ctx, address, quantity, value)
+}
+
+func (mb *CachingClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	return mb.ReadWriteMultipleRegistersContext(context.Background(), readAddress, readQuantity, writeAddress, writeQuantity, value)
+}
+
+func (mb *CachingClient) ReadWriteMultipleRegistersContext(ctx context.Context, readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	defer mb.invalidate(SpaceHoldingRegisters, writeAddress, writeQuantity)
+	return mb.ContextClient.ReadWriteMultipleRegistersContext(ctx, readAddress, readQuantity, writeAddress, writeQuantity, value)
+}
+
+func (mb *CachingClient) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
+	return mb.MaskWriteRegisterContext(context.Background(), address, andMask, orMask)
+}
+
+func (mb *CachingClient) MaskWriteRegisterContext(ctx context.Context, address, andMask, orMask uint16) (results []byte, err error) {
+	defer mb.invalidate(SpaceHoldingRegisters, address, 1)
+	return mb.ContextClient.MaskWriteRegisterContext(ctx, address, andMask, orMask)
+}
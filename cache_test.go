@@ -0,0 +1,151 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCachingClient(t *testing.T) {
+	device := &testDevice{}
+	device.setHolding(0, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19)
+	client := NewCachingClient(device, time.Minute)
+
+	results, err := client.ReadHoldingRegisters(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Subset served from the cache
+	if results, err = client.ReadHoldingRegisters(2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0, 12, 0, 13, 0, 14}, results) {
+		t.Fatalf("unexpected results % x", results)
+	}
+	if device.requests != 1 {
+		t.Fatalf("requests: expected %v, actual %v", 1, device.requests)
+	}
+	// Results are copies
+	results[1] = 99
+	if results, _ = client.ReadHoldingRegisters(2, 1); results[1] != 12 {
+		t.Fatalf("cache modified: % x", results)
+	}
+	// Not covered
+	if _, err = client.ReadHoldingRegisters(8, 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.ReadInputRegisters(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if device.requests != 3 {
+		t.Fatalf("requests: expected %v, actual %v", 3, device.requests)
+	}
+
+	writes := []func() error{
+		func() (err error) { _, err = client.WriteSingleRegister(3, 1); return },
+		func() (err error) { _, err = client.WriteMultipleRegisters(4, 1, []byte{0, 2}); return },
+		func() (err error) { _, err = client.MaskWriteRegister(5, 0, 3); return },
+	}
+	for i, write := range writes {
+		if _, err = client.ReadHoldingRegisters(0, 10); err != nil {
+			t.Fatal(err)
+		}
+		if err = write(); err != nil {
+			t.Fatal(err)
+		}
+		requests := device.requests
+		if results, err = client.ReadHoldingRegisters(3, 3); err != nil {
+			t.Fatal(err)
+		}
+		if device.requests != requests+1 {
+			t.Fatalf("write %v: cache not invalidated", i)
+		}
+	}
+	if !bytes.Equal([]byte{0, 1, 0, 2, 0, 3}, results) {
+		t.Fatalf("unexpected results % x", results)
+	}
+	// Writes elsewhere keep the cache
+	requests := device.requests
+	if _, err = client.ReadWriteMultipleRegisters(20, 1, 30, 1, []byte{0, 1}); err == nil {
+		t.Fatal("read/write multiple registers is not supported by the device")
+	}
+	if _, err = client.ReadHoldingRegisters(3, 3); err != nil {
+		t.Fatal(err)
+	}
+	if device.requests != requests+1 {
+		t.Fatalf("requests: expected %v, actual %v", requests+1, device.requests)
+	}
+}
+
+func TestCachingClientBits(t *testing.T) {
+	device := &testDevice{}
+	for _, i := range []int{1, 3, 9, 12} {
+		device.coils[i] = true
+	}
+	client := NewCachingClient(device, time.Minute)
+	ctx := context.Background()
+	if _, err := client.ReadCoils(0, 16); err != nil {
+		t.Fatal(err)
+	}
+	values, err := ReadCoilsBool(ctx, client, 3, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []bool{true, false, false, false, false, false, true, false, false, true}
+	if !reflect.DeepEqual(expected, values) {
+		t.Fatalf("expected %v, actual %v", expected, values)
+	}
+	if device.requests != 1 {
+		t.Fatalf("requests: expected %v, actual %v", 1, device.requests)
+	}
+	if _, err = client.WriteSingleCoil(4, 0xFF00); err != nil {
+		t.Fatal(err)
+	}
+	if values, err = ReadCoilsBool(ctx, client, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]bool{true, true}, values) || device.requests != 3 {
+		t.Fatalf("unexpected coils %v after %v requests", values, device.requests)
+	}
+}
+
+func TestReadCacheExpiry(t *testing.T) {
+	device := &testDevice{}
+	device.SlaveId = 1
+	cache := NewReadCache(20 * time.Millisecond)
+	first := cache.Client(device)
+	second := cache.Client(device)
+
+	if _, err := first.ReadInputRegisters(0, 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.ReadInputRegisters(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	// Reads served from the cache do not use up transaction ids
+	if device.transactionId != 1 {
+		t.Fatalf("transaction id: expected %v, actual %v", 1, device.transactionId)
+	}
+	// The results of slave 1 are not served for slave 2
+	device.SlaveId = 2
+	if _, err := second.ReadInputRegisters(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if device.requests != 2 {
+		t.Fatalf("requests: expected %v, actual %v", 2, device.requests)
+	}
+	device.SlaveId = 1
+	time.Sleep(30 * time.Millisecond)
+	if _, err := second.ReadInputRegisters(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if device.requests != 3 {
+		t.Fatalf("requests: expected %v, actual %v", 3, device.requests)
+	}
+}
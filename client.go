@@ -6,9 +6,15 @@ package modbus
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"time"
+
+	"github.com/goburrow/serial"
 )
 
 // ClientHandler is the interface that groups the Packager and Transporter methods.
@@ -18,18 +24,23 @@ type ClientHandler interface {
 }
 
 type client struct {
-	packager    Packager
-	transporter Transporter
+	packager     Packager
+	transporter  Transporter
+	roundTripper RoundTripper
 }
 
 // NewClient creates a new modbus client with given backend handler.
-func NewClient(handler ClientHandler) Client {
-	return &client{packager: handler, transporter: handler}
+// Requests pass through the interceptors in order before being sent. The
+// client is a ContextClient.
+func NewClient(handler ClientHandler, interceptors ...Interceptor) Client {
+	return NewClient2(handler, handler, interceptors...)
 }
 
 // NewClient2 creates a new modbus client with given backend packager and transporter.
-func NewClient2(packager Packager, transporter Transporter) Client {
-	return &client{packager: packager, transporter: transporter}
+func NewClient2(packager Packager, transporter Transporter, interceptors ...Interceptor) Client {
+	mb := &client{packager: packager, transporter: transporter}
+	mb.roundTripper = ChainInterceptors(interceptors...)(RoundTripperFunc(mb.roundTrip))
+	return mb
 }
 
 // Request:
@@ -44,22 +55,26 @@ func NewClient2(packager Packager, transporter Transporter) Client {
 //	Byte count            : 1 byte
 //	Coil status           : N* bytes (=N or N+1)
 func (mb *client) ReadCoils(address, quantity uint16) (results []byte, err error) {
+	return mb.ReadCoilsContext(context.Background(), address, quantity)
+}
+
+func (mb *client) ReadCoilsContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
 	if quantity < 1 || quantity > 2000 {
-		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 2000)
+		err = newRangeError("quantity", int(quantity), 1, 2000)
 		return
 	}
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeReadCoils,
 		Data:         dataBlock(address, quantity),
 	}
-	response, err := mb.send(&request)
+	response, tx, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
 	count := int(response.Data[0])
 	length := len(response.Data) - 1
 	if count != length {
-		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", length, count)
+		err = tx.frameError(ErrLengthMismatch, "modbus: response data size '%v' does not match count '%v'", length, count)
 		return
 	}
 	results = response.Data[1:]
@@ -78,22 +93,26 @@ func (mb *client) ReadCoils(address, quantity uint16) (results []byte, err error
 //	Byte count            : 1 byte
 //	Input status          : N* bytes (=N or N+1)
 func (mb *client) ReadDiscreteInputs(address, quantity uint16) (results []byte, err error) {
+	return mb.ReadDiscreteInputsContext(context.Background(), address, quantity)
+}
+
+func (mb *client) ReadDiscreteInputsContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
 	if quantity < 1 || quantity > 2000 {
-		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 2000)
+		err = newRangeError("quantity", int(quantity), 1, 2000)
 		return
 	}
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeReadDiscreteInputs,
 		Data:         dataBlock(address, quantity),
 	}
-	response, err := mb.send(&request)
+	response, tx, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
 	count := int(response.Data[0])
 	length := len(response.Data) - 1
 	if count != length {
-		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", length, count)
+		err = tx.frameError(ErrLengthMismatch, "modbus: response data size '%v' does not match count '%v'", length, count)
 		return
 	}
 	results = response.Data[1:]
@@ -112,22 +131,26 @@ func (mb *client) ReadDiscreteInputs(address, quantity uint16) (results []byte,
 //	Byte count            : 1 byte
 //	Register value        : Nx2 bytes
 func (mb *client) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	return mb.ReadHoldingRegistersContext(context.Background(), address, quantity)
+}
+
+func (mb *client) ReadHoldingRegistersContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
 	if quantity < 1 || quantity > 125 {
-		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 125)
+		err = newRangeError("quantity", int(quantity), 1, 125)
 		return
 	}
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeReadHoldingRegisters,
 		Data:         dataBlock(address, quantity),
 	}
-	response, err := mb.send(&request)
+	response, tx, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
 	count := int(response.Data[0])
 	length := len(response.Data) - 1
 	if count != length {
-		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", length, count)
+		err = tx.frameError(ErrLengthMismatch, "modbus: response data size '%v' does not match count '%v'", length, count)
 		return
 	}
 	results = response.Data[1:]
@@ -146,22 +169,26 @@ func (mb *client) ReadHoldingRegisters(address, quantity uint16) (results []byte
 //	Byte count            : 1 byte
 //	Input registers       : N bytes
 func (mb *client) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
+	return mb.ReadInputRegistersContext(context.Background(), address, quantity)
+}
+
+func (mb *client) ReadInputRegistersContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
 	if quantity < 1 || quantity > 125 {
-		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 125)
+		err = newRangeError("quantity", int(quantity), 1, 125)
 		return
 	}
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeReadInputRegisters,
 		Data:         dataBlock(address, quantity),
 	}
-	response, err := mb.send(&request)
+	response, tx, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
 	count := int(response.Data[0])
 	length := len(response.Data) - 1
 	if count != length {
-		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", length, count)
+		err = tx.frameError(ErrLengthMismatch, "modbus: response data size '%v' does not match count '%v'", length, count)
 		return
 	}
 	results = response.Data[1:]
@@ -180,33 +207,41 @@ func (mb *client) ReadInputRegisters(address, quantity uint16) (results []byte,
 //	Output address        : 2 bytes
 //	Output value          : 2 bytes
 func (mb *client) WriteSingleCoil(address, value uint16) (results []byte, err error) {
+	return mb.WriteSingleCoilContext(context.Background(), address, value)
+}
+
+func (mb *client) WriteSingleCoilContext(ctx context.Context, address, value uint16) (results []byte, err error) {
 	// The requested ON/OFF state can only be 0xFF00 and 0x0000
 	if value != 0xFF00 && value != 0x0000 {
-		err = fmt.Errorf("modbus: state '%v' must be either 0xFF00 (ON) or 0x0000 (OFF)", value)
+		err = &ValidationError{
+			Field: "value",
+			Value: int(value),
+			Msg:   fmt.Sprintf("modbus: state '%v' must be either 0xFF00 (ON) or 0x0000 (OFF)", value),
+		}
 		return
 	}
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeWriteSingleCoil,
 		Data:         dataBlock(address, value),
 	}
-	response, err := mb.send(&request)
-	if err != nil {
+	response, tx, err := mb.send(ctx, &request)
+	if err != nil || response == nil {
 		return
 	}
 	// Fixed response length
 	if len(response.Data) != 4 {
-		err = fmt.Errorf("modbus: response data size '%v' does not match expected '%v'", len(response.Data), 4)
+		err = tx.frameError(ErrLengthMismatch, "modbus: response data size '%v' does not match expected '%v'", len(response.Data), 4)
 		return
 	}
 	respValue := binary.BigEndian.Uint16(response.Data)
 	if address != respValue {
-		err = fmt.Errorf("modbus: response address '%v' does not match request '%v'", respValue, address)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response address '%v' does not match request '%v'", respValue, address)
 		return
 	}
 	results = response.Data[2:]
 	respValue = binary.BigEndian.Uint16(results)
 	if value != respValue {
-		err = fmt.Errorf("modbus: response value '%v' does not match request '%v'", respValue, value)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response value '%v' does not match request '%v'", respValue, value)
 		return
 	}
 	return
@@ -224,28 +259,32 @@ func (mb *client) WriteSingleCoil(address, value uint16) (results []byte, err er
 //	Register address      : 2 bytes
 //	Register value        : 2 bytes
 func (mb *client) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	return mb.WriteSingleRegisterContext(context.Background(), address, value)
+}
+
+func (mb *client) WriteSingleRegisterContext(ctx context.Context, address, value uint16) (results []byte, err error) {
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeWriteSingleRegister,
 		Data:         dataBlock(address, value),
 	}
-	response, err := mb.send(&request)
-	if err != nil {
+	response, tx, err := mb.send(ctx, &request)
+	if err != nil || response == nil {
 		return
 	}
 	// Fixed response length
 	if len(response.Data) != 4 {
-		err = fmt.Errorf("modbus: response data size '%v' does not match expected '%v'", len(response.Data), 4)
+		err = tx.frameError(ErrLengthMismatch, "modbus: response data size '%v' does not match expected '%v'", len(response.Data), 4)
 		return
 	}
 	respValue := binary.BigEndian.Uint16(response.Data)
 	if address != respValue {
-		err = fmt.Errorf("modbus: response address '%v' does not match request '%v'", respValue, address)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response address '%v' does not match request '%v'", respValue, address)
 		return
 	}
 	results = response.Data[2:]
 	respValue = binary.BigEndian.Uint16(results)
 	if value != respValue {
-		err = fmt.Errorf("modbus: response value '%v' does not match request '%v'", respValue, value)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response value '%v' does not match request '%v'", respValue, value)
 		return
 	}
 	return
@@ -265,32 +304,36 @@ func (mb *client) WriteSingleRegister(address, value uint16) (results []byte, er
 //	Starting address      : 2 bytes
 //	Quantity of outputs   : 2 bytes
 func (mb *client) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
+	return mb.WriteMultipleCoilsContext(context.Background(), address, quantity, value)
+}
+
+func (mb *client) WriteMultipleCoilsContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error) {
 	if quantity < 1 || quantity > 1968 {
-		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 1968)
+		err = newRangeError("quantity", int(quantity), 1, 1968)
 		return
 	}
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeWriteMultipleCoils,
 		Data:         dataBlockSuffix(value, address, quantity),
 	}
-	response, err := mb.send(&request)
-	if err != nil {
+	response, tx, err := mb.send(ctx, &request)
+	if err != nil || response == nil {
 		return
 	}
 	// Fixed response length
 	if len(response.Data) != 4 {
-		err = fmt.Errorf("modbus: response data size '%v' does not match expected '%v'", len(response.Data), 4)
+		err = tx.frameError(ErrLengthMismatch, "modbus: response data size '%v' does not match expected '%v'", len(response.Data), 4)
 		return
 	}
 	respValue := binary.BigEndian.Uint16(response.Data)
 	if address != respValue {
-		err = fmt.Errorf("modbus: response address '%v' does not match request '%v'", respValue, address)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response address '%v' does not match request '%v'", respValue, address)
 		return
 	}
 	results = response.Data[2:]
 	respValue = binary.BigEndian.Uint16(results)
 	if quantity != respValue {
-		err = fmt.Errorf("modbus: response quantity '%v' does not match request '%v'", respValue, quantity)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response quantity '%v' does not match request '%v'", respValue, quantity)
 		return
 	}
 	return
@@ -310,32 +353,36 @@ func (mb *client) WriteMultipleCoils(address, quantity uint16, value []byte) (re
 //	Starting address      : 2 bytes
 //	Quantity of registers : 2 bytes
 func (mb *client) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	return mb.WriteMultipleRegistersContext(context.Background(), address, quantity, value)
+}
+
+func (mb *client) WriteMultipleRegistersContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error) {
 	if quantity < 1 || quantity > 123 {
-		err = fmt.Errorf("modbus: quantity '%v' must be between '%v' and '%v',", quantity, 1, 123)
+		err = newRangeError("quantity", int(quantity), 1, 123)
 		return
 	}
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeWriteMultipleRegisters,
 		Data:         dataBlockSuffix(value, address, quantity),
 	}
-	response, err := mb.send(&request)
-	if err != nil {
+	response, tx, err := mb.send(ctx, &request)
+	if err != nil || response == nil {
 		return
 	}
 	// Fixed response length
 	if len(response.Data) != 4 {
-		err = fmt.Errorf("modbus: response data size '%v' does not match expected '%v'", len(response.Data), 4)
+		err = tx.frameError(ErrLengthMismatch, "modbus: response data size '%v' does not match expected '%v'", len(response.Data), 4)
 		return
 	}
 	respValue := binary.BigEndian.Uint16(response.Data)
 	if address != respValue {
-		err = fmt.Errorf("modbus: response address '%v' does not match request '%v'", respValue, address)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response address '%v' does not match request '%v'", respValue, address)
 		return
 	}
 	results = response.Data[2:]
 	respValue = binary.BigEndian.Uint16(results)
 	if quantity != respValue {
-		err = fmt.Errorf("modbus: response quantity '%v' does not match request '%v'", respValue, quantity)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response quantity '%v' does not match request '%v'", respValue, quantity)
 		return
 	}
 	return
@@ -362,14 +409,14 @@ func (mb *client) WriteMultipleRegisters(address, quantity uint16, value []byte)
 // 			Object Value 	: Object length
 
 // private helper – request + full parse
-func (mb *client) readDeviceIdentification(objectID, readDeviceIDCode uint8) (map[uint8][]byte, error) {
+func (mb *client) readDeviceIdentification(ctx context.Context, objectID, readDeviceIDCode uint8) (map[uint8][]byte, error) {
 	const meiType uint8 = 0x0E
 	data := []byte{meiType, readDeviceIDCode, objectID}
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeReadDeviceIdentification,
 		Data:         data,
 	}
-	response, err := mb.send(&request)
+	response, tx, err := mb.send(ctx, &request)
 	if err != nil {
 		return nil, err
 	}
@@ -382,7 +429,7 @@ func (mb *client) readDeviceIdentification(objectID, readDeviceIDCode uint8) (ma
 		return nil, err
 	}
 	if respMeiType != meiType {
-		return nil, fmt.Errorf("modbus: response mei type '%v' does not match request '%v'", respMeiType, meiType)
+		return nil, tx.frameError(ErrResponseMismatch, "modbus: response mei type '%v' does not match request '%v'", respMeiType, meiType)
 	}
 
 	respDeviceIDCode, err := r.ReadByte()
@@ -390,7 +437,7 @@ func (mb *client) readDeviceIdentification(objectID, readDeviceIDCode uint8) (ma
 		return nil, err
 	}
 	if respDeviceIDCode != readDeviceIDCode {
-		return nil, fmt.Errorf("modbus: response device ID code '%v' does not match request '%v'", respDeviceIDCode, readDeviceIDCode)
+		return nil, tx.frameError(ErrResponseMismatch, "modbus: response device ID code '%v' does not match request '%v'", respDeviceIDCode, readDeviceIDCode)
 	}
 
 	respConformityLevel, err := r.ReadByte()
@@ -398,7 +445,7 @@ func (mb *client) readDeviceIdentification(objectID, readDeviceIDCode uint8) (ma
 		return nil, err
 	}
 	if respConformityLevel&0x01 > 3 {
-		return nil, fmt.Errorf("modbus: invalid response conformity level '%v'", respConformityLevel)
+		return nil, tx.frameError(ErrInvalidFrame, "modbus: invalid response conformity level '%v'", respConformityLevel)
 	}
 
 	moreFollows, err := r.ReadByte()
@@ -406,7 +453,7 @@ func (mb *client) readDeviceIdentification(objectID, readDeviceIDCode uint8) (ma
 		return nil, err
 	}
 	if moreFollows != 0 && moreFollows != 0xFF {
-		return nil, fmt.Errorf("modbus: invalid response more follows flag '%v'", moreFollows)
+		return nil, tx.frameError(ErrInvalidFrame, "modbus: invalid response more follows flag '%v'", moreFollows)
 	}
 
 	nextObjectID, err := r.ReadByte()
@@ -418,7 +465,7 @@ func (mb *client) readDeviceIdentification(objectID, readDeviceIDCode uint8) (ma
 		return nil, err
 	}
 	if nextObjectID != 0 {
-		return nil, fmt.Errorf("modbus: currently not supporting multi-transaction responses. Received first '%v' objects", numberOfObjects)
+		return nil, tx.frameError(ErrInvalidFrame, "modbus: currently not supporting multi-transaction responses. Received first '%v' objects", numberOfObjects)
 	}
 
 	results := make(map[uint8][]byte)
@@ -442,7 +489,11 @@ func (mb *client) readDeviceIdentification(objectID, readDeviceIDCode uint8) (ma
 
 // Basic (0x01)
 func (mb *client) ReadDeviceIdentificationBasic() (out BasicDeviceID, err error) {
-	objs, err := mb.readDeviceIdentification(0, 0x01)
+	return mb.ReadDeviceIdentificationBasicContext(context.Background())
+}
+
+func (mb *client) ReadDeviceIdentificationBasicContext(ctx context.Context) (out BasicDeviceID, err error) {
+	objs, err := mb.readDeviceIdentification(ctx, 0, 0x01)
 	if err != nil {
 		return
 	}
@@ -454,7 +505,11 @@ func (mb *client) ReadDeviceIdentificationBasic() (out BasicDeviceID, err error)
 
 // Regular (0x02)
 func (mb *client) ReadDeviceIdentificationRegular() (out RegularDeviceID, err error) {
-	objs, err := mb.readDeviceIdentification(0, 0x02)
+	return mb.ReadDeviceIdentificationRegularContext(context.Background())
+}
+
+func (mb *client) ReadDeviceIdentificationRegularContext(ctx context.Context) (out RegularDeviceID, err error) {
+	objs, err := mb.readDeviceIdentification(ctx, 0, 0x02)
 	if err != nil {
 		return
 	}
@@ -475,7 +530,11 @@ func (mb *client) ReadDeviceIdentificationRegular() (out RegularDeviceID, err er
 
 // Extended (0x03)
 func (mb *client) ReadDeviceIdentificationExtended() (out ExtendedDeviceID, err error) {
-	objs, err := mb.readDeviceIdentification(0, 0x03)
+	return mb.ReadDeviceIdentificationExtendedContext(context.Background())
+}
+
+func (mb *client) ReadDeviceIdentificationExtendedContext(ctx context.Context) (out ExtendedDeviceID, err error) {
+	objs, err := mb.readDeviceIdentification(ctx, 0, 0x03)
 	if err != nil {
 		return
 	}
@@ -503,10 +562,12 @@ func (mb *client) ReadDeviceIdentificationExtended() (out ExtendedDeviceID, err
 }
 
 // Specific (0x04)
-func (mb *client) ReadDeviceIdentificationSpecific(objectID uint8) (
-	value []byte, err error,
-) {
-	objs, err := mb.readDeviceIdentification(objectID, 0x04)
+func (mb *client) ReadDeviceIdentificationSpecific(objectID uint8) (value []byte, err error) {
+	return mb.ReadDeviceIdentificationSpecificContext(context.Background(), objectID)
+}
+
+func (mb *client) ReadDeviceIdentificationSpecificContext(ctx context.Context, objectID uint8) (value []byte, err error) {
+	objs, err := mb.readDeviceIdentification(ctx, objectID, 0x04)
 	if err != nil {
 		return
 	}
@@ -515,7 +576,11 @@ func (mb *client) ReadDeviceIdentificationSpecific(objectID uint8) (
 }
 
 func (mb *client) ReadDeviceIdentificationMap(readDeviceIDCode uint8) (objs map[uint8][]byte, err error) {
-	objs, err = mb.readDeviceIdentification(0, readDeviceIDCode)
+	return mb.ReadDeviceIdentificationMapContext(context.Background(), readDeviceIDCode)
+}
+
+func (mb *client) ReadDeviceIdentificationMapContext(ctx context.Context, readDeviceIDCode uint8) (objs map[uint8][]byte, err error) {
+	objs, err = mb.readDeviceIdentification(ctx, 0, readDeviceIDCode)
 	return
 }
 
@@ -536,14 +601,34 @@ func (mb *client) ReadDeviceIdentificationMap(readDeviceIDCode uint8) (objs map[
 //  The normal response is an echo of the request.
 
 func (mb *client) WriteFileRecord(fileNumber uint16, recordNumber uint16, value []uint16, count uint16) (err error) {
+	return mb.WriteFileRecordContext(context.Background(), fileNumber, recordNumber, value, count)
+}
+
+func (mb *client) WriteFileRecordContext(ctx context.Context, fileNumber uint16, recordNumber uint16, value []uint16, count uint16) (err error) {
 	if fileNumber == 0x0000 {
-		return fmt.Errorf("modbus: invalid file number: %v", fileNumber)
+		return &ValidationError{
+			Field: "file number",
+			Value: int(fileNumber),
+			Min:   1,
+			Max:   0xFFFF,
+			Msg:   fmt.Sprintf("modbus: invalid file number: %v", fileNumber),
+		}
 	}
 	if recordNumber > 0x270F {
-		return fmt.Errorf("modbus: invalid record number: %v", recordNumber)
+		return &ValidationError{
+			Field: "record number",
+			Value: int(recordNumber),
+			Max:   0x270F,
+			Msg:   fmt.Sprintf("modbus: invalid record number: %v", recordNumber),
+		}
 	}
 	if count > 122 {
-		return fmt.Errorf("modbus: invalid record count: %v", count)
+		return &ValidationError{
+			Field: "record count",
+			Value: int(count),
+			Max:   122,
+			Msg:   fmt.Sprintf("modbus: invalid record count: %v", count),
+		}
 	}
 
 	dataSize := uint8(count) * 2
@@ -560,8 +645,8 @@ func (mb *client) WriteFileRecord(fileNumber uint16, recordNumber uint16, value
 		Data:         data,
 	}
 
-	response, err := mb.send(&request)
-	if err != nil {
+	response, tx, err := mb.send(ctx, &request)
+	if err != nil || response == nil {
 		return
 	}
 
@@ -572,7 +657,7 @@ func (mb *client) WriteFileRecord(fileNumber uint16, recordNumber uint16, value
 		return
 	}
 	if responseSize > 251 {
-		err = fmt.Errorf("modbus: response size invalid: %v", responseSize)
+		err = tx.frameError(ErrInvalidFrame, "modbus: response size invalid: %v", responseSize)
 		return
 	}
 
@@ -581,7 +666,7 @@ func (mb *client) WriteFileRecord(fileNumber uint16, recordNumber uint16, value
 		return
 	}
 	if respReferenceType != 6 {
-		err = fmt.Errorf("modbus: response reference type invalid: %v", respReferenceType)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response reference type invalid: %v", respReferenceType)
 		return
 	}
 
@@ -593,7 +678,7 @@ func (mb *client) WriteFileRecord(fileNumber uint16, recordNumber uint16, value
 
 	respFileNumber := binary.BigEndian.Uint16(buf[:])
 	if respFileNumber != fileNumber {
-		err = fmt.Errorf("modbus: response file number invalid: %v", respFileNumber)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response file number invalid: %v", respFileNumber)
 		return
 	}
 
@@ -604,7 +689,7 @@ func (mb *client) WriteFileRecord(fileNumber uint16, recordNumber uint16, value
 
 	respRecordNumber := binary.BigEndian.Uint16(buf[:])
 	if respRecordNumber != recordNumber {
-		err = fmt.Errorf("modbus: response record number invalid: %v", respRecordNumber)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response record number invalid: %v", respRecordNumber)
 		return
 	}
 
@@ -615,7 +700,7 @@ func (mb *client) WriteFileRecord(fileNumber uint16, recordNumber uint16, value
 
 	respRecordLength := binary.BigEndian.Uint16(buf[:])
 	if respRecordLength != count {
-		err = fmt.Errorf("modbus: response record length invalid: %v", respRecordLength)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response record length invalid: %v", respRecordLength)
 		return
 	}
 
@@ -629,7 +714,7 @@ func (mb *client) WriteFileRecord(fileNumber uint16, recordNumber uint16, value
 
 	responseRecordDataUint16 := bytesToUint16s(responseRecordDataBytes, binary.BigEndian)
 	if !equalUint16Slices(value, responseRecordDataUint16) {
-		err = fmt.Errorf("modbus: request and response file record does not match")
+		err = tx.frameError(ErrResponseMismatch, "modbus: request and response file record does not match")
 		return
 	}
 
@@ -650,32 +735,36 @@ func (mb *client) WriteFileRecord(fileNumber uint16, recordNumber uint16, value
 //	AND-mask              : 2 bytes
 //	OR-mask               : 2 bytes
 func (mb *client) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
+	return mb.MaskWriteRegisterContext(context.Background(), address, andMask, orMask)
+}
+
+func (mb *client) MaskWriteRegisterContext(ctx context.Context, address, andMask, orMask uint16) (results []byte, err error) {
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeMaskWriteRegister,
 		Data:         dataBlock(address, andMask, orMask),
 	}
-	response, err := mb.send(&request)
-	if err != nil {
+	response, tx, err := mb.send(ctx, &request)
+	if err != nil || response == nil {
 		return
 	}
 	// Fixed response length
 	if len(response.Data) != 6 {
-		err = fmt.Errorf("modbus: response data size '%v' does not match expected '%v'", len(response.Data), 6)
+		err = tx.frameError(ErrLengthMismatch, "modbus: response data size '%v' does not match expected '%v'", len(response.Data), 6)
 		return
 	}
 	respValue := binary.BigEndian.Uint16(response.Data)
 	if address != respValue {
-		err = fmt.Errorf("modbus: response address '%v' does not match request '%v'", respValue, address)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response address '%v' does not match request '%v'", respValue, address)
 		return
 	}
 	respValue = binary.BigEndian.Uint16(response.Data[2:])
 	if andMask != respValue {
-		err = fmt.Errorf("modbus: response AND-mask '%v' does not match request '%v'", respValue, andMask)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response AND-mask '%v' does not match request '%v'", respValue, andMask)
 		return
 	}
 	respValue = binary.BigEndian.Uint16(response.Data[4:])
 	if orMask != respValue {
-		err = fmt.Errorf("modbus: response OR-mask '%v' does not match request '%v'", respValue, orMask)
+		err = tx.frameError(ErrResponseMismatch, "modbus: response OR-mask '%v' does not match request '%v'", respValue, orMask)
 		return
 	}
 	results = response.Data[2:]
@@ -698,25 +787,29 @@ func (mb *client) MaskWriteRegister(address, andMask, orMask uint16) (results []
 //	Byte count            : 1 byte
 //	Read registers value  : Nx2 bytes
 func (mb *client) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	return mb.ReadWriteMultipleRegistersContext(context.Background(), readAddress, readQuantity, writeAddress, writeQuantity, value)
+}
+
+func (mb *client) ReadWriteMultipleRegistersContext(ctx context.Context, readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
 	if readQuantity < 1 || readQuantity > 125 {
-		err = fmt.Errorf("modbus: quantity to read '%v' must be between '%v' and '%v',", readQuantity, 1, 125)
+		err = newRangeError("quantity to read", int(readQuantity), 1, 125)
 		return
 	}
 	if writeQuantity < 1 || writeQuantity > 121 {
-		err = fmt.Errorf("modbus: quantity to write '%v' must be between '%v' and '%v',", writeQuantity, 1, 121)
+		err = newRangeError("quantity to write", int(writeQuantity), 1, 121)
 		return
 	}
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeReadWriteMultipleRegisters,
 		Data:         dataBlockSuffix(value, readAddress, readQuantity, writeAddress, writeQuantity),
 	}
-	response, err := mb.send(&request)
-	if err != nil {
+	response, tx, err := mb.send(ctx, &request)
+	if err != nil || response == nil {
 		return
 	}
 	count := int(response.Data[0])
 	if count != (len(response.Data) - 1) {
-		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", len(response.Data)-1, count)
+		err = tx.frameError(ErrLengthMismatch, "modbus: response data size '%v' does not match count '%v'", len(response.Data)-1, count)
 		return
 	}
 	results = response.Data[1:]
@@ -736,42 +829,121 @@ func (mb *client) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAdd
 //	FIFO count            : 2 bytes (<=31)
 //	FIFO value register   : Nx2 bytes
 func (mb *client) ReadFIFOQueue(address uint16) (results []byte, err error) {
+	return mb.ReadFIFOQueueContext(context.Background(), address)
+}
+
+func (mb *client) ReadFIFOQueueContext(ctx context.Context, address uint16) (results []byte, err error) {
 	request := ProtocolDataUnit{
 		FunctionCode: FuncCodeReadFIFOQueue,
 		Data:         dataBlock(address),
 	}
-	response, err := mb.send(&request)
+	response, tx, err := mb.send(ctx, &request)
 	if err != nil {
 		return
 	}
 	if len(response.Data) < 4 {
-		err = fmt.Errorf("modbus: response data size '%v' is less than expected '%v'", len(response.Data), 4)
+		err = tx.frameError(ErrLengthMismatch, "modbus: response data size '%v' is less than expected '%v'", len(response.Data), 4)
 		return
 	}
 	count := int(binary.BigEndian.Uint16(response.Data))
 	if count != (len(response.Data) - 1) {
-		err = fmt.Errorf("modbus: response data size '%v' does not match count '%v'", len(response.Data)-1, count)
+		err = tx.frameError(ErrLengthMismatch, "modbus: response data size '%v' does not match count '%v'", len(response.Data)-1, count)
 		return
 	}
 	count = int(binary.BigEndian.Uint16(response.Data[2:]))
 	if count > 31 {
-		err = fmt.Errorf("modbus: fifo count '%v' is greater than expected '%v'", count, 31)
+		err = tx.frameError(ErrInvalidFrame, "modbus: fifo count '%v' is greater than expected '%v'", count, 31)
 		return
 	}
 	results = response.Data[4:]
 	return
 }
 
+// reportSlaveId reads the description of the type, the current status
+// and other information specific to a remote device for the Scanner. It
+// returns the data following the byte count.
+//
+// Request:
+//
+//	Function code         : 1 byte (0x11)
+//
+// Response:
+//
+//	Function code         : 1 byte (0x11)
+//	Byte count            : 1 byte
+//	Slave id              : device specific
+//	Run indicator status  : 1 byte (0x00 = OFF, 0xFF = ON)
+//	Additional data       : device specific
+func (mb *client) reportSlaveId(ctx context.Context) (results []byte, err error) {
+	request := ProtocolDataUnit{
+		FunctionCode: FuncCodeReportSlaveId,
+	}
+	response, tx, err := mb.send(ctx, &request)
+	if err != nil {
+		return
+	}
+	count := int(response.Data[0])
+	if count != len(response.Data)-1 {
+		err = tx.frameError(ErrLengthMismatch, "modbus: response data size '%v' does not match count '%v'", len(response.Data)-1, count)
+		return
+	}
+	results = response.Data[1:]
+	return
+}
+
 // Helpers
 
-// send sends request and checks possible exception in the response.
-func (mb *client) send(request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
+// send sends request through the interceptors. The transaction is
+// returned to report errors in the response with the frames.
+func (mb *client) send(ctx context.Context, request *ProtocolDataUnit) (response *ProtocolDataUnit, tx *Transaction, err error) {
+	tx = &Transaction{FunctionCode: request.FunctionCode, Start: time.Now()}
+	response, err = mb.roundTripper.RoundTrip(withTransaction(ctx, tx), request)
+	return
+}
+
+// roundTrip sends request and checks possible exception in the response.
+func (mb *client) roundTrip(ctx context.Context, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error) {
 	aduRequest, err := mb.packager.Encode(request)
 	if err != nil {
 		return
 	}
-	aduResponse, err := mb.transporter.Send(aduRequest)
+	tx := TransactionFromContext(ctx)
+	if tx != nil {
+		tx.FunctionCode = request.FunctionCode
+		tx.SlaveId, _ = aduSlaveId(mb.packager, aduRequest)
+		tx.Request = aduRequest
+	}
+	class := ErrorClassFrame
+	defer func() {
+		if tx != nil {
+			tx.Err = err
+			tx.ErrorClass = ""
+			if err != nil {
+				tx.ErrorClass = class
+			}
+		}
+	}()
+	start := time.Now()
+	aduResponse, err := sendContext(ctx, mb.transporter, aduRequest)
+	if tx != nil {
+		tx.Response = aduResponse
+		tx.Latency = time.Now().Sub(start)
+	}
 	if err != nil {
+		class = ErrorClassTransport
+		if isTimeout(err) {
+			class = ErrorClassTimeout
+			if !errors.Is(err, ErrTimeout) && err != context.DeadlineExceeded {
+				err = &timeoutError{err}
+			}
+		}
+		return
+	}
+	if len(aduResponse) == 0 {
+		// Broadcast requests are not answered, only writes may be broadcast
+		if !isWriteFunction(request.FunctionCode) {
+			err = newFrameError(ErrNoResponse, aduRequest, nil, "modbus: no response to function '%v'", request.FunctionCode)
+		}
 		return
 	}
 	if err = mb.packager.Verify(aduRequest, aduResponse); err != nil {
@@ -779,21 +951,201 @@ func (mb *client) send(request *ProtocolDataUnit) (response *ProtocolDataUnit, e
 	}
 	response, err = mb.packager.Decode(aduResponse)
 	if err != nil {
+		if errors.Is(err, ErrCRCMismatch) || errors.Is(err, ErrLRCMismatch) {
+			class = ErrorClassChecksum
+		}
+		var frameError *FrameError
+		if errors.As(err, &frameError) && frameError.Request == nil {
+			frameError.Request = aduRequest
+		}
 		return
 	}
 	// Check correct function code returned (exception)
 	if response.FunctionCode != request.FunctionCode {
+		class = ErrorClassException
 		err = responseError(response)
+		if errors.Is(err, ErrGatewayPathUnavailable) || errors.Is(err, ErrGatewayTargetFailed) {
+			class = ErrorClassGateway
+		}
 		return
 	}
 	if response.Data == nil || len(response.Data) == 0 {
 		// Empty response
-		err = fmt.Errorf("modbus: response data is empty")
+		err = newFrameError(ErrLengthMismatch, aduRequest, aduResponse, "modbus: response data is empty")
+		return
+	}
+	return
+}
+
+// sendContext sends the request with ctx if the transporter supports it.
+func sendContext(ctx context.Context, transporter Transporter, aduRequest []byte) ([]byte, error) {
+	if t, ok := transporter.(ContextTransporter); ok {
+		return t.SendContext(ctx, aduRequest)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return transporter.Send(aduRequest)
+}
+
+// checkResponse verifies and decodes the response like the client does,
+// so that wrapping handlers can tell corrupted frames and exceptions
+// apart from valid responses.
+func checkResponse(packager Packager, aduRequest, aduResponse []byte) (err error) {
+	if len(aduResponse) == 0 {
 		return
 	}
+	if err = packager.Verify(aduRequest, aduResponse); err != nil {
+		return
+	}
+	response, err := packager.Decode(aduResponse)
+	if err != nil {
+		return
+	}
+	if response.FunctionCode&0x80 != 0 {
+		err = responseError(response)
+	}
+	return
+}
+
+// frameInspector is implemented by the packagers of this package and the
+// handlers wrapping them to read the slave id and function code of an
+// encoded frame.
+type frameInspector interface {
+	slaveIdOf(adu []byte) (slaveId byte, ok bool)
+	functionCodeOf(adu []byte) (functionCode byte, ok bool)
+}
+
+// aduSlaveId returns the slave id of the frame encoded by packager.
+func aduSlaveId(packager Packager, adu []byte) (slaveId byte, ok bool) {
+	if inspector, is := packager.(frameInspector); is {
+		return inspector.slaveIdOf(adu)
+	}
+	return
+}
+
+// aduFunctionCode returns the function code of the frame encoded by
+// packager.
+func aduFunctionCode(packager Packager, adu []byte) (functionCode byte, ok bool) {
+	if inspector, is := packager.(frameInspector); is {
+		return inspector.functionCodeOf(adu)
+	}
+	return
+}
+
+// slaveIdSwitcher is implemented by the packagers of this package and the
+// handlers wrapping them to encode frames for another slave.
+type slaveIdSwitcher interface {
+	withSlaveId(slaveId byte) Packager
+}
+
+// packagerWithSlaveId returns a packager encoding the frames of packager
+// for slaveId.
+func packagerWithSlaveId(packager Packager, slaveId byte) (Packager, bool) {
+	if switcher, ok := packager.(slaveIdSwitcher); ok {
+		// Wrapping handlers return nil if the wrapped one cannot switch
+		packager = switcher.withSlaveId(slaveId)
+		return packager, packager != nil
+	}
+	return nil, false
+}
+
+// slaveIdEncoder is implemented by the packagers of this package and the
+// handlers wrapping them to tell the slave id they encode frames for.
+type slaveIdEncoder interface {
+	encodingSlaveId() (slaveId byte, ok bool)
+}
+
+// packagerSlaveId returns the slave id packager encodes frames for.
+func packagerSlaveId(packager Packager) (slaveId byte, ok bool) {
+	if encoder, is := packager.(slaveIdEncoder); is {
+		return encoder.encodingSlaveId()
+	}
+	return
+}
+
+// timeoutSetter is implemented by the transporters of this package and
+// the handlers wrapping them to replace their Timeout.
+type timeoutSetter interface {
+	setTimeout(timeout time.Duration) (previous time.Duration, ok bool)
+}
+
+// setHandlerTimeout replaces the Timeout of transporter and returns the
+// previous one.
+func setHandlerTimeout(transporter Transporter, timeout time.Duration) (previous time.Duration, ok bool) {
+	if setter, is := transporter.(timeoutSetter); is {
+		return setter.setTimeout(timeout)
+	}
 	return
 }
 
+// frameError creates a FrameError with the frames of the transaction.
+func (tx *Transaction) frameError(kind error, format string, v ...interface{}) error {
+	return newFrameError(kind, tx.Request, tx.Response, format, v...)
+}
+
+// isTimeout reports whether err is caused by a response not received in time.
+func isTimeout(err error) bool {
+	if errors.Is(err, ErrTimeout) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, serial.ErrTimeout) {
+		return true
+	}
+	var netError net.Error
+	return errors.As(err, &netError) && netError.Timeout()
+}
+
+// watchContext calls interrupt in a new goroutine if ctx is done before
+// stop is called. stop reports whether interrupt has been called.
+func watchContext(ctx context.Context, interrupt func()) (stop func() bool) {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+	done := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			interrupt()
+			interrupted <- true
+		case <-done:
+			interrupted <- false
+		}
+	}()
+	return func() bool {
+		close(done)
+		return <-interrupted
+	}
+}
+
+// isWriteFunction reports whether the function code changes the state
+// of the remote device.
+func isWriteFunction(functionCode byte) bool {
+	switch functionCode {
+	case FuncCodeWriteSingleCoil,
+		FuncCodeWriteMultipleCoils,
+		FuncCodeWriteSingleRegister,
+		FuncCodeWriteMultipleRegisters,
+		FuncCodeWriteFileRecord,
+		FuncCodeMaskWriteRegister,
+		FuncCodeReadWriteMultipleRegisters:
+		return true
+	}
+	return false
+}
+
+// checkBroadcast rejects a request of functionCode to the serial
+// broadcast address 0 unless it is a write, as broadcasts are not
+// answered. Read/write multiple registers returns the registers read and
+// is rejected as well.
+func checkBroadcast(slaveId, functionCode byte) error {
+	if slaveId != 0 || isWriteFunction(functionCode) && functionCode != FuncCodeReadWriteMultipleRegisters {
+		return nil
+	}
+	return &ValidationError{
+		Field: "slave id",
+		Msg:   fmt.Sprintf("modbus: function '%v' cannot be broadcast, only writes may be sent to slave id '0'", functionCode),
+	}
+}
+
 // dataBlock creates a sequence of uint16 data.
 func dataBlock(value ...uint16) []byte {
 	data := make([]byte, 2*len(value))
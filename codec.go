@@ -0,0 +1,195 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The codecs below convert between the registers in data, as returned by
+// the read functions and expected by WriteMultipleRegisters, and strings,
+// BCD numbers and time stamps. The order applies to all registers of a
+// value: strings whose characters are swapped in each register are in
+// WordOrderBADC.
+
+// DecodeString decodes the ASCII string held in the registers in data,
+// trimming its NUL and space padding on both ends.
+func DecodeString(data []byte, order WordOrder) string {
+	buf := make([]byte, len(data)&^1)
+	copy(buf, data)
+	order.reorder(buf)
+	return strings.Trim(string(buf), "\x00 ")
+}
+
+// EncodeString encodes s in registers, padded with NULs.
+func EncodeString(s string, registers int, order WordOrder) (data []byte, err error) {
+	if len(s) > 2*registers {
+		err = &ValidationError{
+			Field: "string length",
+			Value: len(s),
+			Max:   2 * registers,
+			Msg:   fmt.Sprintf("modbus: string length '%v' must not be bigger than '%v'", len(s), 2*registers),
+		}
+		return
+	}
+	data = make([]byte, 2*registers)
+	copy(data, s)
+	order.reorder(data)
+	return
+}
+
+// DecodeBCD decodes the unsigned number held in the registers in data
+// as binary-coded decimal, four digits per register with the most
+// significant first. Up to four registers are decoded.
+func DecodeBCD(data []byte, order WordOrder) (value uint64, err error) {
+	size := len(data) &^ 1
+	if size == 0 || size > 8 {
+		err = &ValidationError{
+			Field: "data size",
+			Value: len(data),
+			Min:   2,
+			Max:   8,
+			Msg:   fmt.Sprintf("modbus: data size '%v' must be between '%v' and '%v'", len(data), 2, 8),
+		}
+		return
+	}
+	buf := make([]byte, size)
+	copy(buf, data)
+	order.reorder(buf)
+	for _, b := range buf {
+		for _, digit := range []byte{b >> 4, b & 0x0F} {
+			if digit > 9 {
+				err = &ValidationError{
+					Field: "bcd digit",
+					Value: int(digit),
+					Max:   9,
+					Msg:   fmt.Sprintf("modbus: invalid bcd digit '%#x'", digit),
+				}
+				return
+			}
+			value = 10*value + uint64(digit)
+		}
+	}
+	return
+}
+
+// EncodeBCD encodes value in registers as binary-coded decimal.
+func EncodeBCD(value uint64, registers int, order WordOrder) (data []byte, err error) {
+	data = make([]byte, 2*registers)
+	remainder := value
+	for i := len(data) - 1; i >= 0; i-- {
+		data[i] = byte(remainder%10) | byte(remainder/10%10)<<4
+		remainder /= 100
+	}
+	if remainder != 0 {
+		data = nil
+		err = &ValidationError{
+			Field: "bcd value",
+			Value: int(value),
+			Msg:   fmt.Sprintf("modbus: value '%v' does not fit in '%v' bcd registers", value, registers),
+		}
+		return
+	}
+	order.reorder(data)
+	return
+}
+
+// CP56Time2a is the 7 byte time stamp of IEC 60870-5, held in four
+// registers. Its bytes, least significant first, are the milliseconds
+// of the minute (2 bytes), the minute, the hour, the day of the month
+// and of the week, the month and the year of the century.
+type CP56Time2a struct {
+	// Time is in the location given to DecodeCP56Time2a, its year from
+	// 2000 to 2099.
+	Time time.Time
+	// Invalid is the IV flag of the minute.
+	Invalid bool
+	// SummerTime is the SU flag of the hour.
+	SummerTime bool
+}
+
+// cp56Time2aRegisters is the number of registers of a CP56Time2a, the
+// last byte being unused.
+const cp56Time2aRegisters = 4
+
+// DecodeCP56Time2a decodes a time stamp in loc, UTC if nil, from the
+// registers in data.
+func DecodeCP56Time2a(data []byte, order WordOrder, loc *time.Location) (ts CP56Time2a, err error) {
+	if len(data) < 2*cp56Time2aRegisters {
+		err = &ValidationError{
+			Field: "data size",
+			Value: len(data),
+			Min:   2 * cp56Time2aRegisters,
+			Msg:   fmt.Sprintf("modbus: data size '%v' is too small for a cp56time2a", len(data)),
+		}
+		return
+	}
+	buf := make([]byte, 2*cp56Time2aRegisters)
+	copy(buf, data)
+	order.reorder(buf)
+
+	milliseconds := int(binary.LittleEndian.Uint16(buf))
+	minute := int(buf[2] & 0x3F)
+	hour := int(buf[3] & 0x1F)
+	day := int(buf[4] & 0x1F)
+	month := int(buf[5] & 0x0F)
+	year := int(buf[6] & 0x7F)
+	for _, field := range []struct {
+		name            string
+		value, min, max int
+	}{
+		{"milliseconds", milliseconds, 0, 59999},
+		{"minute", minute, 0, 59},
+		{"hour", hour, 0, 23},
+		{"day", day, 1, 31},
+		{"month", month, 1, 12},
+	} {
+		if field.value < field.min || field.value > field.max {
+			err = newRangeError(field.name, field.value, field.min, field.max)
+			return
+		}
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	ts.Time = time.Date(2000+year, time.Month(month), day, hour, minute,
+		milliseconds/1000, milliseconds%1000*int(time.Millisecond), loc)
+	ts.Invalid = buf[2]&0x80 != 0
+	ts.SummerTime = buf[3]&0x80 != 0
+	return
+}
+
+// EncodeCP56Time2a encodes a time stamp in four registers, with the
+// fields of ts.Time in its location.
+func EncodeCP56Time2a(ts CP56Time2a, order WordOrder) (data []byte, err error) {
+	t := ts.Time
+	if t.Year() < 2000 || t.Year() > 2099 {
+		err = newRangeError("year", t.Year(), 2000, 2099)
+		return
+	}
+	data = make([]byte, 2*cp56Time2aRegisters)
+	binary.LittleEndian.PutUint16(data, uint16(t.Second()*1000+t.Nanosecond()/int(time.Millisecond)))
+	data[2] = byte(t.Minute())
+	if ts.Invalid {
+		data[2] |= 0x80
+	}
+	data[3] = byte(t.Hour())
+	if ts.SummerTime {
+		data[3] |= 0x80
+	}
+	// Day of the week from 1 (Monday) to 7 (Sunday)
+	weekday := int(t.Weekday())
+	if weekday == 0 {
+		weekday = 7
+	}
+	data[4] = byte(t.Day()) | byte(weekday)<<5
+	data[5] = byte(t.Month())
+	data[6] = byte(t.Year() - 2000)
+	order.reorder(data)
+	return
+}
@@ -0,0 +1,91 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestString(t *testing.T) {
+	if s := DecodeString([]byte("  Acme Corp\x00\x00\x00"), WordOrderABCD); s != "Acme Corp" {
+		t.Fatalf("expected %q, actual %q", "Acme Corp", s)
+	}
+	if s := DecodeString([]byte("cAem\x00"), WordOrderBADC); s != "Acme" {
+		t.Fatalf("expected %q, actual %q", "Acme", s)
+	}
+	data, err := EncodeString("Acme", 3, WordOrderBADC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte("cAem\x00\x00"), data) {
+		t.Fatalf("expected %q, actual %q", "cAem\x00\x00", data)
+	}
+	if _, err = EncodeString("Acme", 1, WordOrderABCD); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestBCD(t *testing.T) {
+	value, err := DecodeBCD([]byte{0x12, 0x34, 0x56, 0x78}, WordOrderABCD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 12345678 {
+		t.Fatalf("expected %v, actual %v", 12345678, value)
+	}
+	if value, err = DecodeBCD([]byte{0x56, 0x78, 0x12, 0x34}, WordOrderCDAB); err != nil || value != 12345678 {
+		t.Fatalf("expected %v, actual %v, %v", 12345678, value, err)
+	}
+	if _, err = DecodeBCD([]byte{0x12, 0x3A}, WordOrderABCD); err == nil {
+		t.Fatal("expected error")
+	}
+
+	data, err := EncodeBCD(9021, 2, WordOrderABCD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte{0x00, 0x00, 0x90, 0x21}, data) {
+		t.Fatalf("expected % x, actual % x", []byte{0x00, 0x00, 0x90, 0x21}, data)
+	}
+	if _, err = EncodeBCD(12345, 1, WordOrderABCD); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestCP56Time2a(t *testing.T) {
+	// Tuesday 2023-10-17 14:05:09.250, summer time
+	expected := time.Date(2023, time.October, 17, 14, 5, 9, 250*int(time.Millisecond), time.UTC)
+	data := []byte{0x22, 0x24, 0x05, 0x8E, 0x51, 0x0A, 0x17, 0x00}
+	ts, err := DecodeCP56Time2a(data, WordOrderABCD, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Time.Equal(expected) || !ts.SummerTime || ts.Invalid {
+		t.Fatalf("unexpected time stamp %+v", ts)
+	}
+	encoded, err := EncodeCP56Time2a(ts, WordOrderABCD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, encoded) {
+		t.Fatalf("expected % x, actual % x", data, encoded)
+	}
+
+	// Registers holding the bytes swapped
+	swapped := []byte{0x24, 0x22, 0x8E, 0x05, 0x0A, 0x51, 0x00, 0x17}
+	if ts, err = DecodeCP56Time2a(swapped, WordOrderBADC, nil); err != nil || !ts.Time.Equal(expected) {
+		t.Fatalf("unexpected time stamp %+v, %v", ts, err)
+	}
+
+	data[5] = 13
+	if _, err = DecodeCP56Time2a(data, WordOrderABCD, nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err = EncodeCP56Time2a(CP56Time2a{Time: time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC)}, WordOrderABCD); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import "context"
+
+// NewContextClient returns client if it is a ContextClient, otherwise a
+// ContextClient whose context-aware methods return ctx.Err() if ctx is
+// done before the request and call the methods of client otherwise.
+func NewContextClient(client Client) ContextClient {
+	if c, ok := client.(ContextClient); ok {
+		return c
+	}
+	return contextClient{client}
+}
+
+// contextClient adapts a Client which is not context-aware.
+type contextClient struct {
+	Client
+}
+
+func (mb contextClient) ReadCoilsContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.ReadCoils(address, quantity)
+}
+
+func (mb contextClient) ReadDiscreteInputsContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.ReadDiscreteInputs(address, quantity)
+}
+
+func (mb contextClient) WriteSingleCoilContext(ctx context.Context, address, value uint16) (results []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.WriteSingleCoil(address, value)
+}
+
+func (mb contextClient) WriteMultipleCoilsContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.WriteMultipleCoils(address, quantity, value)
+}
+
+func (mb contextClient) ReadInputRegistersContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.ReadInputRegisters(address, quantity)
+}
+
+func (mb contextClient) ReadHoldingRegistersContext(ctx context.Context, address, quantity uint16) (results []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.ReadHoldingRegisters(address, quantity)
+}
+
+func (mb contextClient) WriteSingleRegisterContext(ctx context.Context, address, value uint16) (results []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.WriteSingleRegister(address, value)
+}
+
+func (mb contextClient) WriteMultipleRegistersContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.WriteMultipleRegisters(address, quantity, value)
+}
+
+func (mb contextClient) ReadWriteMultipleRegistersContext(ctx context.Context, readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value)
+}
+
+func (mb contextClient) MaskWriteRegisterContext(ctx context.Context, address, andMask, orMask uint16) (results []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.MaskWriteRegister(address, andMask, orMask)
+}
+
+func (mb contextClient) ReadFIFOQueueContext(ctx context.Context, address uint16) (results []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.ReadFIFOQueue(address)
+}
+
+func (mb contextClient) ReadDeviceIdentificationBasicContext(ctx context.Context) (value BasicDeviceID, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.ReadDeviceIdentificationBasic()
+}
+
+func (mb contextClient) ReadDeviceIdentificationRegularContext(ctx context.Context) (value RegularDeviceID, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.ReadDeviceIdentificationRegular()
+}
+
+func (mb contextClient) ReadDeviceIdentificationExtendedContext(ctx context.Context) (value ExtendedDeviceID, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.ReadDeviceIdentificationExtended()
+}
+
+func (mb contextClient) ReadDeviceIdentificationSpecificContext(ctx context.Context, objectID uint8) (value []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.ReadDeviceIdentificationSpecific(objectID)
+}
+
+func (mb contextClient) ReadDeviceIdentificationMapContext(ctx context.Context, readDeviceIDCode uint8) (objs map[uint8][]byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.ReadDeviceIdentificationMap(readDeviceIDCode)
+}
+
+func (mb contextClient) WriteFileRecordContext(ctx context.Context, fileNumber uint16, recordNumber uint16, value []uint16, count uint16) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	return mb.WriteFileRecord(fileNumber, recordNumber, value, count)
+}
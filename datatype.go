@@ -0,0 +1,428 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// AddressSpace is one of the four data tables of a device.
+type AddressSpace int
+
+const (
+	SpaceCoils AddressSpace = iota
+	SpaceDiscreteInputs
+	SpaceHoldingRegisters
+	SpaceInputRegisters
+)
+
+func (s AddressSpace) String() string {
+	switch s {
+	case SpaceCoils:
+		return "coils"
+	case SpaceDiscreteInputs:
+		return "discrete inputs"
+	case SpaceHoldingRegisters:
+		return "holding registers"
+	case SpaceInputRegisters:
+		return "input registers"
+	}
+	return fmt.Sprintf("AddressSpace(%d)", int(s))
+}
+
+// ParseAddressSpace parses the name of an address space, as returned by
+// String or abbreviated, e.g. "coil", "di", "holding" or "ir".
+func ParseAddressSpace(name string) (AddressSpace, error) {
+	switch normalizeName(name) {
+	case "coils", "coil", "co", "c":
+		return SpaceCoils, nil
+	case "discreteinputs", "discreteinput", "discrete", "di":
+		return SpaceDiscreteInputs, nil
+	case "holdingregisters", "holdingregister", "holding", "hr":
+		return SpaceHoldingRegisters, nil
+	case "inputregisters", "inputregister", "input", "ir":
+		return SpaceInputRegisters, nil
+	}
+	return 0, fmt.Errorf("modbus: invalid address space '%v'", name)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s AddressSpace) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler with ParseAddressSpace.
+func (s *AddressSpace) UnmarshalText(text []byte) (err error) {
+	*s, err = ParseAddressSpace(string(text))
+	return
+}
+
+// IsBit reports whether the address space holds bits rather than 16-bit
+// registers.
+func (s AddressSpace) IsBit() bool {
+	return s == SpaceCoils || s == SpaceDiscreteInputs
+}
+
+// MaxQuantity returns the maximum quantity of one read request.
+func (s AddressSpace) MaxQuantity() int {
+	if s.IsBit() {
+		return 2000
+	}
+	return 125
+}
+
+// read reads quantity bits or registers from the address space.
+func (s AddressSpace) read(ctx context.Context, c Client, address, quantity uint16) ([]byte, error) {
+	client := NewContextClient(c)
+	switch s {
+	case SpaceCoils:
+		return client.ReadCoilsContext(ctx, address, quantity)
+	case SpaceDiscreteInputs:
+		return client.ReadDiscreteInputsContext(ctx, address, quantity)
+	case SpaceHoldingRegisters:
+		return client.ReadHoldingRegistersContext(ctx, address, quantity)
+	case SpaceInputRegisters:
+		return client.ReadInputRegistersContext(ctx, address, quantity)
+	}
+	return nil, &ValidationError{
+		Field: "address space",
+		Value: int(s),
+		Msg:   fmt.Sprintf("modbus: invalid address space '%v'", int(s)),
+	}
+}
+
+// DataType is the type of a value held in one bit or in consecutive
+// registers.
+type DataType int
+
+const (
+	TypeBool DataType = iota
+	TypeUint16
+	TypeInt16
+	TypeUint32
+	TypeInt32
+	TypeFloat32
+	TypeUint64
+	TypeInt64
+	TypeFloat64
+)
+
+func (t DataType) String() string {
+	switch t {
+	case TypeBool:
+		return "bool"
+	case TypeUint16:
+		return "uint16"
+	case TypeInt16:
+		return "int16"
+	case TypeUint32:
+		return "uint32"
+	case TypeInt32:
+		return "int32"
+	case TypeFloat32:
+		return "float32"
+	case TypeUint64:
+		return "uint64"
+	case TypeInt64:
+		return "int64"
+	case TypeFloat64:
+		return "float64"
+	}
+	return fmt.Sprintf("DataType(%d)", int(t))
+}
+
+// ParseDataType parses the name of a data type as returned by String,
+// also accepting "boolean", "bit", "word", "short", "dword", "int", "uint",
+// "float", "real" and "double".
+func ParseDataType(name string) (DataType, error) {
+	switch normalizeName(name) {
+	case "bool", "boolean", "bit":
+		return TypeBool, nil
+	case "uint16", "word", "u16":
+		return TypeUint16, nil
+	case "int16", "short", "i16":
+		return TypeInt16, nil
+	case "uint32", "dword", "uint", "u32":
+		return TypeUint32, nil
+	case "int32", "int", "i32":
+		return TypeInt32, nil
+	case "float32", "float", "real", "f32":
+		return TypeFloat32, nil
+	case "uint64", "u64":
+		return TypeUint64, nil
+	case "int64", "i64":
+		return TypeInt64, nil
+	case "float64", "double", "f64":
+		return TypeFloat64, nil
+	}
+	return 0, fmt.Errorf("modbus: invalid data type '%v'", name)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t DataType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler with ParseDataType.
+func (t *DataType) UnmarshalText(text []byte) (err error) {
+	*t, err = ParseDataType(string(text))
+	return
+}
+
+// Registers returns the number of registers holding the type, or the
+// number of bits for TypeBool.
+func (t DataType) Registers() int {
+	switch t {
+	case TypeUint32, TypeInt32, TypeFloat32:
+		return 2
+	case TypeUint64, TypeInt64, TypeFloat64:
+		return 4
+	}
+	return 1
+}
+
+// WordOrder is the order of the bytes of values held in several registers,
+// named after the bytes A to D of a 32-bit value from the most to the least
+// significant. Values of 64 bits extend the order of the words and of the
+// bytes in the words accordingly.
+type WordOrder int
+
+const (
+	// WordOrderABCD is big-endian, the order of the MODBUS specification.
+	WordOrderABCD WordOrder = iota
+	// WordOrderCDAB is big-endian with the words swapped.
+	WordOrderCDAB
+	// WordOrderBADC is big-endian with the bytes of the words swapped.
+	WordOrderBADC
+	// WordOrderDCBA is little-endian.
+	WordOrderDCBA
+)
+
+func (o WordOrder) String() string {
+	switch o {
+	case WordOrderABCD:
+		return "ABCD"
+	case WordOrderCDAB:
+		return "CDAB"
+	case WordOrderBADC:
+		return "BADC"
+	case WordOrderDCBA:
+		return "DCBA"
+	}
+	return fmt.Sprintf("WordOrder(%d)", int(o))
+}
+
+// ParseWordOrder parses the name of a word order as returned by String,
+// also accepting "big" and "little" for ABCD and DCBA.
+func ParseWordOrder(name string) (WordOrder, error) {
+	switch normalizeName(name) {
+	case "abcd", "big", "bigendian", "":
+		return WordOrderABCD, nil
+	case "cdab":
+		return WordOrderCDAB, nil
+	case "badc":
+		return WordOrderBADC, nil
+	case "dcba", "little", "littleendian":
+		return WordOrderDCBA, nil
+	}
+	return 0, fmt.Errorf("modbus: invalid word order '%v'", name)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (o WordOrder) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler with ParseWordOrder.
+func (o *WordOrder) UnmarshalText(text []byte) (err error) {
+	*o, err = ParseWordOrder(string(text))
+	return
+}
+
+// reorder converts data between order and big-endian, in place.
+func (o WordOrder) reorder(data []byte) {
+	if o == WordOrderCDAB || o == WordOrderDCBA {
+		// Reverse the words
+		for i, j := 0, len(data)-2; i < j; i, j = i+2, j-2 {
+			data[i], data[i+1], data[j], data[j+1] = data[j], data[j+1], data[i], data[i+1]
+		}
+	}
+	if o == WordOrderBADC || o == WordOrderDCBA {
+		for i := 0; i+1 < len(data); i += 2 {
+			data[i], data[i+1] = data[i+1], data[i]
+		}
+	}
+}
+
+// DecodeValue decodes a value of type t from the registers in data, as
+// returned by the read functions. Bools are decoded from the first bit
+// of data.
+func DecodeValue(data []byte, t DataType, order WordOrder) (value float64, err error) {
+	if t == TypeBool {
+		if len(data) < 1 {
+			err = dataSizeError(data, 1, t)
+			return
+		}
+		if data[0]&1 != 0 {
+			value = 1
+		}
+		return
+	}
+	size := 2 * t.Registers()
+	if len(data) < size {
+		err = dataSizeError(data, size, t)
+		return
+	}
+	buf := make([]byte, size)
+	copy(buf, data)
+	order.reorder(buf)
+	switch t {
+	case TypeUint16:
+		value = float64(binary.BigEndian.Uint16(buf))
+	case TypeInt16:
+		value = float64(int16(binary.BigEndian.Uint16(buf)))
+	case TypeUint32:
+		value = float64(binary.BigEndian.Uint32(buf))
+	case TypeInt32:
+		value = float64(int32(binary.BigEndian.Uint32(buf)))
+	case TypeFloat32:
+		value = float64(math.Float32frombits(binary.BigEndian.Uint32(buf)))
+	case TypeUint64:
+		value = float64(binary.BigEndian.Uint64(buf))
+	case TypeInt64:
+		value = float64(int64(binary.BigEndian.Uint64(buf)))
+	case TypeFloat64:
+		value = math.Float64frombits(binary.BigEndian.Uint64(buf))
+	default:
+		err = invalidDataType(t)
+	}
+	return
+}
+
+// EncodeValue encodes value as type t into registers, as expected by
+// WriteMultipleRegisters. Integer types are rounded to the nearest
+// integer. Bools are encoded as 0xFF00 (ON) or 0x0000 (OFF), as expected
+// by WriteSingleCoil. A *ValidationError is returned for NaN and values
+// out of the range of t, e.g. negative values of unsigned types.
+func EncodeValue(value float64, t DataType, order WordOrder) (data []byte, err error) {
+	if err = checkValue(value, t); err != nil {
+		return
+	}
+	data = make([]byte, 2*t.Registers())
+	switch t {
+	case TypeBool:
+		if value != 0 {
+			data[0] = 0xFF
+		}
+		return
+	case TypeUint16:
+		binary.BigEndian.PutUint16(data, uint16(math.Round(value)))
+	case TypeInt16:
+		binary.BigEndian.PutUint16(data, uint16(int16(math.Round(value))))
+	case TypeUint32:
+		binary.BigEndian.PutUint32(data, uint32(math.Round(value)))
+	case TypeInt32:
+		binary.BigEndian.PutUint32(data, uint32(int32(math.Round(value))))
+	case TypeFloat32:
+		binary.BigEndian.PutUint32(data, math.Float32bits(float32(value)))
+	case TypeUint64:
+		binary.BigEndian.PutUint64(data, uint64(math.Round(value)))
+	case TypeInt64:
+		binary.BigEndian.PutUint64(data, uint64(int64(math.Round(value))))
+	case TypeFloat64:
+		binary.BigEndian.PutUint64(data, math.Float64bits(value))
+	default:
+		data = nil
+		err = invalidDataType(t)
+		return
+	}
+	order.reorder(data)
+	return
+}
+
+// checkValue returns a ValidationError if value cannot be encoded as type
+// t: NaN, integers out of range once rounded and finite values out of
+// the range of float32.
+func checkValue(value float64, t DataType) error {
+	if math.IsNaN(value) {
+		return &ValidationError{
+			Field: "value",
+			Msg:   fmt.Sprintf("modbus: value '%v' of '%v' is not a number", value, t),
+		}
+	}
+	// The range is [min, max), the bounds are powers of two
+	var min, max float64
+	switch t {
+	case TypeUint16:
+		min, max = 0, 1<<16
+	case TypeInt16:
+		min, max = -1<<15, 1<<15
+	case TypeUint32:
+		min, max = 0, 1<<32
+	case TypeInt32:
+		min, max = -1<<31, 1<<31
+	case TypeUint64:
+		min, max = 0, 1<<64
+	case TypeInt64:
+		min, max = -1<<63, 1<<63
+	case TypeFloat32:
+		if math.IsInf(value, 0) || math.Abs(value) <= math.MaxFloat32 {
+			return nil
+		}
+		return &ValidationError{
+			Field: "value",
+			Msg:   fmt.Sprintf("modbus: value '%v' is out of range of '%v'", value, t),
+		}
+	default:
+		return nil
+	}
+	if rounded := math.Round(value); rounded >= min && rounded < max {
+		return nil
+	}
+	e := &ValidationError{
+		Field: "value",
+		Msg:   fmt.Sprintf("modbus: value '%v' is out of range of '%v'", value, t),
+	}
+	// The range is only reported when it fits in an int of 32 bits
+	if min >= math.MinInt32 && max-1 <= math.MaxInt32 {
+		e.Min, e.Max = int(min), int(max-1)
+		if math.Abs(value) <= math.MaxInt32 {
+			e.Value = int(value)
+		}
+	}
+	return e
+}
+
+func invalidDataType(t DataType) error {
+	return &ValidationError{
+		Field: "data type",
+		Value: int(t),
+		Msg:   fmt.Sprintf("modbus: invalid data type '%v'", int(t)),
+	}
+}
+
+func dataSizeError(data []byte, size int, t DataType) error {
+	return &ValidationError{
+		Field: "data size",
+		Value: len(data),
+		Min:   size,
+		Msg:   fmt.Sprintf("modbus: data size '%v' is too small for '%v'", len(data), t),
+	}
+}
+
+// normalizeName lowers name and removes spaces, underscores and hyphens.
+func normalizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '_', '-':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(name)))
+}
@@ -0,0 +1,102 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestWordOrder(t *testing.T) {
+	tests := []struct {
+		order WordOrder
+		data  []byte
+	}{
+		{WordOrderABCD, []byte{0x12, 0x34, 0x56, 0x78}},
+		{WordOrderCDAB, []byte{0x56, 0x78, 0x12, 0x34}},
+		{WordOrderBADC, []byte{0x34, 0x12, 0x78, 0x56}},
+		{WordOrderDCBA, []byte{0x78, 0x56, 0x34, 0x12}},
+	}
+	for _, test := range tests {
+		value, err := DecodeValue(test.data, TypeUint32, test.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != 0x12345678 {
+			t.Errorf("%v: expected %x, actual %x", test.order, 0x12345678, uint32(value))
+		}
+		data, err := EncodeValue(value, TypeUint32, test.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(test.data, data) {
+			t.Errorf("%v: expected % x, actual % x", test.order, test.data, data)
+		}
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	tests := []struct {
+		t     DataType
+		data  []byte
+		value float64
+	}{
+		{TypeBool, []byte{0x01}, 1},
+		{TypeInt16, []byte{0xFF, 0xFE}, -2},
+		{TypeInt32, []byte{0xFF, 0xFF, 0xFF, 0xFE}, -2},
+		{TypeFloat32, []byte{0x40, 0x80, 0x00, 0x00}, 4},
+		{TypeFloat64, []byte{0x40, 0x10, 0, 0, 0, 0, 0, 0}, 4},
+		{TypeUint64, []byte{0, 0, 0, 1, 0, 0, 0, 0}, 1 << 32},
+	}
+	for _, test := range tests {
+		value, err := DecodeValue(test.data, test.t, WordOrderABCD)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != test.value {
+			t.Errorf("%v: expected %v, actual %v", test.t, test.value, value)
+		}
+	}
+	if _, err := DecodeValue([]byte{0, 1}, TypeUint32, WordOrderABCD); err == nil {
+		t.Fatal("expected error for short data")
+	}
+}
+
+func TestEncodeValueRange(t *testing.T) {
+	tests := []struct {
+		t     DataType
+		value float64
+		valid bool
+	}{
+		{TypeUint16, 65535, true},
+		{TypeUint16, 70000, false},
+		{TypeUint16, -1, false},
+		{TypeUint16, -0.4, true},
+		{TypeInt16, -32768, true},
+		{TypeInt16, 32767.5, false},
+		{TypeUint32, 1 << 32, false},
+		{TypeInt32, -1 << 31, true},
+		{TypeUint64, 1 << 63, true},
+		{TypeUint64, 1 << 64, false},
+		{TypeInt64, 1 << 63, false},
+		{TypeFloat32, 1e39, false},
+		{TypeFloat32, math.Inf(-1), true},
+		{TypeFloat64, math.NaN(), false},
+		{TypeBool, math.NaN(), false},
+	}
+	for _, test := range tests {
+		_, err := EncodeValue(test.value, test.t, WordOrderABCD)
+		var validationError *ValidationError
+		if test.valid && err != nil || !test.valid && !errors.As(err, &validationError) {
+			t.Errorf("%v %v: unexpected error %v", test.t, test.value, err)
+		}
+	}
+	_, err := EncodeValue(70000, TypeUint16, WordOrderABCD)
+	if e := err.(*ValidationError); e.Value != 70000 || e.Min != 0 || e.Max != 65535 {
+		t.Fatalf("unexpected error %+v", e)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kinds of failures, to be matched with errors.Is. Failures of a response
+// frame are reported as *FrameError, invalid request arguments as
+// *ValidationError and exception responses as *ModbusError, which also
+// matches the exception kinds below. Writes verified by a VerifyingClient
+// fail with *VerifyError.
+var (
+	// ErrTimeout is a response not received within Timeout.
+	ErrTimeout = errors.New("modbus: timeout")
+	// ErrConnectionClosed is a connection closed while waiting for the response.
+	ErrConnectionClosed = errors.New("modbus: connection closed")
+	// ErrCRCMismatch is an RTU response with an invalid checksum.
+	ErrCRCMismatch = errors.New("modbus: crc mismatch")
+	// ErrLRCMismatch is an ASCII response with an invalid checksum.
+	ErrLRCMismatch = errors.New("modbus: lrc mismatch")
+	// ErrTransactionIdMismatch is a TCP response to another transaction.
+	ErrTransactionIdMismatch = errors.New("modbus: transaction id mismatch")
+	// ErrProtocolIdMismatch is a TCP response with another protocol id.
+	ErrProtocolIdMismatch = errors.New("modbus: protocol id mismatch")
+	// ErrSlaveIdMismatch is a response from another slave.
+	ErrSlaveIdMismatch = errors.New("modbus: slave id mismatch")
+	// ErrLengthMismatch is a response shorter or longer than expected.
+	ErrLengthMismatch = errors.New("modbus: length mismatch")
+	// ErrResponseMismatch is a response whose fields do not echo the request.
+	ErrResponseMismatch = errors.New("modbus: response mismatch")
+	// ErrInvalidFrame is a response which cannot be framed or parsed.
+	ErrInvalidFrame = errors.New("modbus: invalid frame")
+	// ErrNoResponse is a request which is not answered, i.e. a broadcast read.
+	ErrNoResponse = errors.New("modbus: no response")
+	// ErrEchoMismatch is a local echo which differs from the request.
+	ErrEchoMismatch = errors.New("modbus: local echo mismatch")
+
+	// ErrAcknowledge is an acknowledge exception: the request is accepted
+	// but takes long to complete.
+	ErrAcknowledge = errors.New("modbus: acknowledge")
+	// ErrServerDeviceBusy is a server device busy exception.
+	ErrServerDeviceBusy = errors.New("modbus: server device busy")
+	// ErrGatewayPathUnavailable is a gateway which cannot route the request,
+	// a fault of the gateway rather than of the target device.
+	ErrGatewayPathUnavailable = errors.New("modbus: gateway path unavailable")
+	// ErrGatewayTargetFailed is a target device not responding to a gateway.
+	ErrGatewayTargetFailed = errors.New("modbus: gateway target device failed to respond")
+)
+
+// FrameError is a response frame which cannot be accepted. It carries
+// the encoded request and response, when available, for diagnostics.
+type FrameError struct {
+	// Err is the kind of failure, one of the Err variables.
+	Err      error
+	Msg      string
+	Request  []byte
+	Response []byte
+}
+
+func (e *FrameError) Error() string {
+	return e.Msg
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// newFrameError creates a FrameError of the given kind.
+func newFrameError(kind error, request, response []byte, format string, v ...interface{}) *FrameError {
+	return &FrameError{
+		Err:      kind,
+		Msg:      fmt.Sprintf(format, v...),
+		Request:  request,
+		Response: response,
+	}
+}
+
+// ValidationError is an invalid request argument, detected before the
+// request is sent.
+type ValidationError struct {
+	// Field is the name of the argument, e.g. "quantity".
+	Field string
+	Value int
+	// Min and Max are the allowed range of Value, if any.
+	Min, Max int
+	Msg      string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Msg
+}
+
+// newRangeError creates a ValidationError for a value out of range.
+func newRangeError(field string, value, min, max int) *ValidationError {
+	return &ValidationError{
+		Field: field,
+		Value: value,
+		Min:   min,
+		Max:   max,
+		Msg:   fmt.Sprintf("modbus: %s '%v' must be between '%v' and '%v',", field, value, min, max),
+	}
+}
+
+// VerifyError is a write whose values read back differ from the values
+// written.
+type VerifyError struct {
+	Space      AddressSpace
+	Mismatches []Mismatch
+}
+
+// Mismatch is a bit or register read back with another value than
+// written. Bits are 0 or 1.
+type Mismatch struct {
+	Address       uint16
+	Written, Read uint16
+}
+
+func (e *VerifyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "modbus: %v read back differ from the values written at", e.Space)
+	for i, m := range e.Mismatches {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, " %v (written %v, read %v)", m.Address, m.Written, m.Read)
+	}
+	return b.String()
+}
+
+// Addresses returns the addresses of the mismatches.
+func (e *VerifyError) Addresses() []uint16 {
+	addresses := make([]uint16, len(e.Mismatches))
+	for i, m := range e.Mismatches {
+		addresses[i] = m.Address
+	}
+	return addresses
+}
+
+// timeoutError wraps a timeout of the underlying connection or port so
+// that it matches ErrTimeout.
+type timeoutError struct {
+	err error
+}
+
+func (e *timeoutError) Error() string   { return e.err.Error() }
+func (e *timeoutError) Unwrap() error   { return e.err }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+func (e *timeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
@@ -0,0 +1,78 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestRTUCRCMismatchError(t *testing.T) {
+	packager := &rtuPackager{}
+	adu := []byte{0x11, 0x03, 0x02, 0x02, 0x2B, 0x38, 0xF9}
+	_, err := packager.Decode(adu)
+	if !errors.Is(err, ErrCRCMismatch) {
+		t.Fatalf("expected crc mismatch, actual %v", err)
+	}
+	var frameError *FrameError
+	if !errors.As(err, &frameError) || !bytes.Equal(adu, frameError.Response) {
+		t.Fatalf("unexpected frame error: %#v", err)
+	}
+}
+
+func TestTCPTransactionIdMismatchError(t *testing.T) {
+	packager := &tcpPackager{}
+	request := []byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1}
+	response := []byte{0, 2, 0, 0, 0, 5, 1, 3, 2, 0, 0}
+	err := packager.Verify(request, response)
+	if !errors.Is(err, ErrTransactionIdMismatch) {
+		t.Fatalf("expected transaction id mismatch, actual %v", err)
+	}
+	var frameError *FrameError
+	if !errors.As(err, &frameError) || !bytes.Equal(request, frameError.Request) {
+		t.Fatalf("unexpected frame error: %#v", err)
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	client := NewClient(&scriptedHandler{})
+	_, err := client.ReadHoldingRegisters(0, 126)
+	var validationError *ValidationError
+	if !errors.As(err, &validationError) {
+		t.Fatalf("expected validation error, actual %v", err)
+	}
+	if validationError.Field != "quantity" || validationError.Value != 126 || validationError.Max != 125 {
+		t.Fatalf("unexpected validation error: %#v", validationError)
+	}
+	if IsTransient(err) {
+		t.Fatal("validation error must not be transient")
+	}
+}
+
+func TestResponseMismatchError(t *testing.T) {
+	handler := &scriptedHandler{
+		responses: []*ProtocolDataUnit{
+			{FunctionCode: 0x06, Data: []byte{0, 2, 0, 5}},
+		},
+	}
+	client := NewClient(handler)
+	_, err := client.WriteSingleRegister(1, 5)
+	if !errors.Is(err, ErrResponseMismatch) {
+		t.Fatalf("expected response mismatch, actual %v", err)
+	}
+	var frameError *FrameError
+	if !errors.As(err, &frameError) || len(frameError.Request) == 0 || len(frameError.Response) == 0 {
+		t.Fatalf("unexpected frame error: %#v", err)
+	}
+}
+
+func TestTimeoutError(t *testing.T) {
+	client := NewClient(&scriptedHandler{responses: []*ProtocolDataUnit{nil}})
+	_, err := client.ReadHoldingRegisters(1, 1)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected timeout, actual %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	// Default exception policy
+	exceptionAcknowledgeTimeout  = 10 * time.Second
+	exceptionAcknowledgeInterval = 500 * time.Millisecond
+	exceptionBusyTimeout         = 5 * time.Second
+	exceptionBusyWait            = 200 * time.Millisecond
+)
+
+// ExceptionHandler implements Packager and Transporter interface. It
+// handles the exceptions of the wrapped handler which do not report a
+// failure but a request still being processed:
+//
+// A write answered with acknowledge is accepted by the device but takes
+// long to complete. It is not sent again: if AcknowledgePoll is set, it is
+// called every AcknowledgeInterval until it reports the write complete or
+// AcknowledgeTimeout has elapsed. A completed write has no results, like a
+// broadcast write.
+//
+// A request answered with server device busy is resent after BusyWait
+// until the device answers otherwise or BusyTimeout has elapsed.
+//
+// Once a timeout has elapsed, the last exception is returned. Polling
+// stops as well when the context of the request is done.
+type ExceptionHandler struct {
+	ClientHandler
+
+	// Time to poll an acknowledged write for completion, zero disables
+	// polling.
+	AcknowledgeTimeout  time.Duration
+	AcknowledgeInterval time.Duration
+	// AcknowledgePoll reports whether an acknowledged write is complete,
+	// e.g. by reading a status register of the device. The client sends
+	// the requests to the slave of the write through the wrapped handler.
+	// Acknowledged writes are not polled if it is nil.
+	AcknowledgePoll func(ctx context.Context, client ContextClient) (done bool, err error)
+	// Time to wait for a busy device, zero disables waiting.
+	BusyTimeout time.Duration
+	BusyWait    time.Duration
+}
+
+// NewExceptionHandler allocates an ExceptionHandler around handler with
+// the default timeouts.
+func NewExceptionHandler(handler ClientHandler) *ExceptionHandler {
+	return &ExceptionHandler{
+		ClientHandler:       handler,
+		AcknowledgeTimeout:  exceptionAcknowledgeTimeout,
+		AcknowledgeInterval: exceptionAcknowledgeInterval,
+		BusyTimeout:         exceptionBusyTimeout,
+		BusyWait:            exceptionBusyWait,
+	}
+}
+
+// Send sends the request, resending it while the device is busy, and
+// polls acknowledged writes for completion.
+func (mb *ExceptionHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but stops resending and polling when ctx is
+// done.
+func (mb *ExceptionHandler) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	start := time.Now()
+	for {
+		aduResponse, err = sendContext(ctx, mb.ClientHandler, aduRequest)
+		if err != nil {
+			return
+		}
+		failure := checkResponse(mb.ClientHandler, aduRequest, aduResponse)
+		if errors.Is(failure, ErrAcknowledge) {
+			var done bool
+			if done, err = mb.poll(ctx, aduRequest); done || err != nil {
+				aduResponse = nil
+			}
+			return
+		}
+		if !errors.Is(failure, ErrServerDeviceBusy) || time.Since(start)+mb.BusyWait > mb.BusyTimeout {
+			return
+		}
+		if !exceptionWait(ctx, mb.BusyWait) {
+			// The client reports the error of the last response
+			return
+		}
+	}
+}
+
+// poll calls AcknowledgePoll until the acknowledged write aduRequest is
+// complete or AcknowledgeTimeout has elapsed. The request is not sent
+// again.
+func (mb *ExceptionHandler) poll(ctx context.Context, aduRequest []byte) (done bool, err error) {
+	functionCode, _ := aduFunctionCode(mb.ClientHandler, aduRequest)
+	if mb.AcknowledgePoll == nil || !isWriteFunction(functionCode) {
+		return
+	}
+	var packager Packager = mb.ClientHandler
+	if slaveId, ok := aduSlaveId(mb.ClientHandler, aduRequest); ok {
+		if p, ok := packagerWithSlaveId(mb.ClientHandler, slaveId); ok {
+			packager = p
+		}
+	}
+	client := NewContextClient(NewClient2(packager, mb.ClientHandler))
+	start := time.Now()
+	for !done {
+		if time.Since(start)+mb.AcknowledgeInterval > mb.AcknowledgeTimeout ||
+			!exceptionWait(ctx, mb.AcknowledgeInterval) {
+			return
+		}
+		if done, err = mb.AcknowledgePoll(ctx, client); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// exceptionWait waits d and reports whether it has elapsed before ctx is
+// done.
+func exceptionWait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Close closes the wrapped handler if it can be closed.
+func (mb *ExceptionHandler) Close() error {
+	if closer, ok := mb.ClientHandler.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (mb *ExceptionHandler) slaveIdOf(adu []byte) (byte, bool) {
+	return aduSlaveId(mb.ClientHandler, adu)
+}
+
+func (mb *ExceptionHandler) encodingSlaveId() (byte, bool) {
+	return packagerSlaveId(mb.ClientHandler)
+}
+
+func (mb *ExceptionHandler) functionCodeOf(adu []byte) (byte, bool) {
+	return aduFunctionCode(mb.ClientHandler, adu)
+}
+
+func (mb *ExceptionHandler) withSlaveId(slaveId byte) Packager {
+	packager, _ := packagerWithSlaveId(mb.ClientHandler, slaveId)
+	return packager
+}
+
+func (mb *ExceptionHandler) setTimeout(timeout time.Duration) (time.Duration, bool) {
+	return setHandlerTimeout(mb.ClientHandler, timeout)
+}
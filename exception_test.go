@@ -0,0 +1,135 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExceptionHandlerBusy(t *testing.T) {
+	handler := &scriptedHandler{
+		responses: []*ProtocolDataUnit{
+			{FunctionCode: 0x86, Data: []byte{ExceptionCodeServerDeviceBusy}},
+			{FunctionCode: 0x86, Data: []byte{ExceptionCodeServerDeviceBusy}},
+			{FunctionCode: 0x06, Data: []byte{0, 1, 0, 5}},
+		},
+	}
+	exception := NewExceptionHandler(handler)
+	exception.BusyWait = time.Millisecond
+
+	if _, err := NewClient(exception).WriteSingleRegister(1, 5); err != nil {
+		t.Fatal(err)
+	}
+	if handler.requests != 3 {
+		t.Fatalf("requests: expected %v, actual %v", 3, handler.requests)
+	}
+}
+
+// acknowledgingDevice acknowledges writes, which complete after the
+// status in holding register 0 has been read polls times.
+type acknowledgingDevice struct {
+	tcpPackager
+
+	polls  int
+	writes int
+	reads  int
+}
+
+func (d *acknowledgingDevice) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	pdu := &ProtocolDataUnit{FunctionCode: aduRequest[tcpHeaderSize]}
+	switch pdu.FunctionCode {
+	case FuncCodeWriteSingleRegister:
+		d.writes++
+		pdu.FunctionCode |= 0x80
+		pdu.Data = []byte{ExceptionCodeAcknowledge}
+	case FuncCodeReadHoldingRegisters:
+		d.reads++
+		pdu.Data = []byte{2, 0, 0}
+		if d.reads >= d.polls {
+			pdu.Data[2] = 1
+		}
+	}
+	aduResponse = make([]byte, tcpHeaderSize+1+len(pdu.Data))
+	copy(aduResponse, aduRequest[:tcpHeaderSize])
+	binary.BigEndian.PutUint16(aduResponse[4:], uint16(2+len(pdu.Data)))
+	aduResponse[tcpHeaderSize] = pdu.FunctionCode
+	copy(aduResponse[tcpHeaderSize+1:], pdu.Data)
+	return
+}
+
+func TestExceptionHandlerAcknowledge(t *testing.T) {
+	device := &acknowledgingDevice{polls: 3}
+	exception := NewExceptionHandler(device)
+	exception.AcknowledgeInterval = time.Millisecond
+	exception.AcknowledgePoll = func(ctx context.Context, client ContextClient) (bool, error) {
+		results, err := client.ReadHoldingRegistersContext(ctx, 0, 1)
+		if err != nil {
+			return false, err
+		}
+		return results[1] == 1, nil
+	}
+
+	if _, err := NewClient(exception).WriteSingleRegister(1, 5); err != nil {
+		t.Fatal(err)
+	}
+	if device.writes != 1 || device.reads != 3 {
+		t.Fatalf("writes %v, reads %v: expected 1 write and 3 reads", device.writes, device.reads)
+	}
+
+	// Acknowledged writes are not polled nor sent again without
+	// AcknowledgePoll
+	exception.AcknowledgePoll = nil
+	if _, err := NewClient(exception).WriteSingleRegister(1, 5); !errors.Is(err, ErrAcknowledge) {
+		t.Fatalf("expected acknowledge, actual %v", err)
+	}
+	if device.writes != 2 || device.reads != 3 {
+		t.Fatalf("writes %v, reads %v: expected 2 writes and 3 reads", device.writes, device.reads)
+	}
+}
+
+func TestExceptionHandlerTimeout(t *testing.T) {
+	busy := &ProtocolDataUnit{FunctionCode: 0x83, Data: []byte{ExceptionCodeServerDeviceBusy}}
+	handler := &scriptedHandler{
+		responses: []*ProtocolDataUnit{busy, busy, busy, busy},
+	}
+	exception := NewExceptionHandler(handler)
+	exception.BusyWait = 10 * time.Millisecond
+	exception.BusyTimeout = 25 * time.Millisecond
+
+	_, err := NewClient(exception).ReadHoldingRegisters(1, 1)
+	if !errors.Is(err, ErrServerDeviceBusy) {
+		t.Fatalf("expected busy, actual %v", err)
+	}
+	if handler.requests < 2 || handler.requests > 3 {
+		t.Fatalf("requests: expected %v, actual %v", 3, handler.requests)
+	}
+}
+
+func TestGatewayException(t *testing.T) {
+	handler := &scriptedHandler{
+		responses: []*ProtocolDataUnit{
+			{FunctionCode: 0x83, Data: []byte{ExceptionCodeGatewayPathUnavailable}},
+		},
+	}
+	var class string
+	client := NewClient(handler, ObserverInterceptor(observerFunc(func(tx *Transaction) {
+		class = tx.ErrorClass
+	})))
+	_, err := client.ReadHoldingRegisters(1, 1)
+	if !errors.Is(err, ErrGatewayPathUnavailable) || errors.Is(err, ErrGatewayTargetFailed) {
+		t.Fatalf("expected gateway path unavailable, actual %v", err)
+	}
+	if class != ErrorClassGateway {
+		t.Fatalf("class: expected %v, actual %v", ErrorClassGateway, class)
+	}
+}
+
+type observerFunc func(tx *Transaction)
+
+func (f observerFunc) ObserveTransaction(tx *Transaction) { f(tx) }
@@ -0,0 +1,41 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+)
+
+// RoundTripper sends a request and returns its response. Exception
+// responses are returned as *ModbusError. The response of a broadcast
+// request is nil.
+type RoundTripper interface {
+	RoundTrip(ctx context.Context, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error)
+}
+
+// RoundTripperFunc is an adapter to use an ordinary function as RoundTripper.
+type RoundTripperFunc func(ctx context.Context, request *ProtocolDataUnit) (response *ProtocolDataUnit, err error)
+
+// RoundTrip calls f(ctx, request).
+func (f RoundTripperFunc) RoundTrip(ctx context.Context, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+	return f(ctx, request)
+}
+
+// Interceptor wraps the sending of requests of a Client, e.g. for
+// logging, metrics, retries, caching, authorization or fault injection.
+// It may inspect or replace the request, call next any number of times
+// or answer without calling next at all.
+type Interceptor func(next RoundTripper) RoundTripper
+
+// ChainInterceptors composes interceptors into one. The first
+// interceptor is the outermost, seeing requests first and responses last.
+func ChainInterceptors(interceptors ...Interceptor) Interceptor {
+	return func(next RoundTripper) RoundTripper {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			next = interceptors[i](next)
+		}
+		return next
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestInterceptors(t *testing.T) {
+	handler := &scriptedHandler{
+		responses: []*ProtocolDataUnit{
+			{FunctionCode: 0x03, Data: []byte{2, 0x12, 0x34}},
+		},
+	}
+	var calls []string
+	trace := func(name string) Interceptor {
+		return func(next RoundTripper) RoundTripper {
+			return RoundTripperFunc(func(ctx context.Context, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+				calls = append(calls, name+" request")
+				response, err := next.RoundTrip(ctx, request)
+				calls = append(calls, name+" response")
+				return response, err
+			})
+		}
+	}
+	deny := func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+			if isWriteFunction(request.FunctionCode) {
+				return nil, &ModbusError{FunctionCode: request.FunctionCode | 0x80, ExceptionCode: ExceptionCodeIllegalFunction}
+			}
+			return next.RoundTrip(ctx, request)
+		})
+	}
+	client := NewClient(handler, trace("outer"), trace("inner"), deny)
+
+	if _, err := client.ReadHoldingRegisters(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"outer request", "inner request", "inner response", "outer response"}
+	if !reflect.DeepEqual(expected, calls) {
+		t.Fatalf("calls: expected %v, actual %v", expected, calls)
+	}
+	if _, err := client.WriteSingleRegister(1, 1); err == nil {
+		t.Fatal("write is expected to be denied")
+	}
+	if handler.requests != 1 {
+		t.Fatalf("requests: expected %v, actual %v", 1, handler.requests)
+	}
+}
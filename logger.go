@@ -0,0 +1,139 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// LogLevel is the severity of a log entry. The values match log/slog.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = -4
+	LogLevelInfo  LogLevel = 0
+	LogLevelWarn  LogLevel = 4
+	LogLevelError LogLevel = 8
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "DEBUG"
+	case LogLevelInfo:
+		return "INFO"
+	case LogLevelWarn:
+		return "WARN"
+	case LogLevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// LogField is a key-value pair of a structured log entry.
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// LeveledLogger writes structured log entries.
+type LeveledLogger interface {
+	Log(ctx context.Context, level LogLevel, msg string, fields ...LogField)
+}
+
+// stdLogger adapts *log.Logger to LeveledLogger.
+type stdLogger struct {
+	logger *log.Logger
+	level  LogLevel
+}
+
+// NewStdLogger returns a LeveledLogger which writes entries of at least
+// the given level to logger as "LEVEL msg key=value ...".
+func NewStdLogger(logger *log.Logger, level LogLevel) LeveledLogger {
+	return &stdLogger{logger: logger, level: level}
+}
+
+func (l *stdLogger) Log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	if level < l.level {
+		return
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%v %s", level, msg)
+	for _, field := range fields {
+		fmt.Fprintf(&buf, " %s=%v", field.Key, field.Value)
+	}
+	l.logger.Print(buf.String())
+}
+
+// LoggingInterceptor logs every transaction of a Client to logger. Valid
+// responses are logged at debug level, exceptions at warning level and
+// other failures at error level, with the fields transport, address,
+// slave_id, function_code, transaction_id, request_bytes, response_bytes,
+// latency and error.
+func LoggingInterceptor(logger LeveledLogger) Interceptor {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+			start := time.Now()
+			response, err := next.RoundTrip(ctx, request)
+			tx := TransactionFromContext(ctx)
+			if tx == nil {
+				tx = &Transaction{FunctionCode: request.FunctionCode}
+			}
+			level := LogLevelDebug
+			fields := []LogField{
+				{"transport", tx.Transport},
+				{"address", tx.Address},
+				{"slave_id", tx.SlaveId},
+				{"function_code", tx.FunctionCode},
+				{"transaction_id", tx.TransactionId},
+				{"request_bytes", len(tx.Request)},
+				{"response_bytes", len(tx.Response)},
+				{"latency", time.Now().Sub(start)},
+			}
+			if err != nil {
+				level = LogLevelError
+				var e *ModbusError
+				if errors.As(err, &e) {
+					level = LogLevelWarn
+					fields = append(fields, LogField{"exception_code", e.ExceptionCode})
+				}
+				fields = append(fields, LogField{"error", err.Error()})
+			}
+			logger.Log(ctx, level, "modbus: transaction", fields...)
+			return response, err
+		})
+	}
+}
+
+// hexBytes formats a frame as hexadecimal bytes, e.g. "01 03 00 00", in
+// log entries.
+type hexBytes []byte
+
+func (b hexBytes) String() string {
+	return fmt.Sprintf("% x", []byte(b))
+}
+
+// MarshalText implements encoding.TextMarshaler so that JSON handlers
+// write the hexadecimal bytes too.
+func (b hexBytes) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// transportLog writes an entry of a transport to leveled or, if it is
+// nil, to logger.
+func transportLog(leveled LeveledLogger, logger *log.Logger, ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	if leveled == nil {
+		if logger == nil {
+			return
+		}
+		leveled = &stdLogger{logger: logger, level: LogLevelDebug}
+	}
+	leveled.Log(ctx, level, msg, fields...)
+}
@@ -0,0 +1,38 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build go1.21
+// +build go1.21
+
+package modbus
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogLogger adapts *slog.Logger to LeveledLogger.
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a LeveledLogger which writes entries to logger,
+// e.g. with a slog.JSONHandler for JSON-structured output.
+func NewSlogLogger(logger *slog.Logger) LeveledLogger {
+	return &slogLogger{logger: logger}
+}
+
+func (l *slogLogger) Log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !l.logger.Enabled(ctx, slog.Level(level)) {
+		return
+	}
+	attrs := make([]slog.Attr, len(fields))
+	for i, field := range fields {
+		attrs[i] = slog.Any(field.Key, field.Value)
+	}
+	l.logger.LogAttrs(ctx, slog.Level(level), msg, attrs...)
+}
@@ -0,0 +1,107 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+type recordingLogger struct {
+	levels   []LogLevel
+	messages []string
+	entries  []map[string]interface{}
+}
+
+func (l *recordingLogger) Log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	entry := make(map[string]interface{})
+	for _, field := range fields {
+		entry[field.Key] = field.Value
+	}
+	l.levels = append(l.levels, level)
+	l.messages = append(l.messages, msg)
+	l.entries = append(l.entries, entry)
+}
+
+func TestLoggingInterceptor(t *testing.T) {
+	handler := &scriptedHandler{
+		responses: []*ProtocolDataUnit{
+			{FunctionCode: 0x03, Data: []byte{2, 0x12, 0x34}},
+			{FunctionCode: 0x83, Data: []byte{ExceptionCodeIllegalDataAddress}},
+		},
+	}
+	handler.SlaveId = 9
+	logger := &recordingLogger{}
+	client := NewClient(handler, LoggingInterceptor(logger))
+
+	client.ReadHoldingRegisters(1, 1)
+	client.ReadHoldingRegisters(2, 1)
+
+	if len(logger.entries) != 2 {
+		t.Fatalf("entries: expected %v, actual %v", 2, len(logger.entries))
+	}
+	if logger.levels[0] != LogLevelDebug || logger.levels[1] != LogLevelWarn {
+		t.Fatalf("unexpected levels: %v", logger.levels)
+	}
+	entry := logger.entries[0]
+	if entry["slave_id"] != byte(9) || entry["function_code"] != byte(3) ||
+		entry["request_bytes"] != 12 || entry["response_bytes"] != 11 {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+	if entry = logger.entries[1]; entry["exception_code"] != byte(ExceptionCodeIllegalDataAddress) {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), LogLevelInfo)
+	logger.Log(context.Background(), LogLevelDebug, "hidden")
+	logger.Log(context.Background(), LogLevelError, "modbus: transaction", LogField{"slave_id", 1}, LogField{"error", "timeout"})
+
+	if expected := "ERROR modbus: transaction slave_id=1 error=timeout\n"; expected != buf.String() {
+		t.Fatalf("expected %q, actual %q", expected, buf.String())
+	}
+}
+
+func TestTransportLeveledLogger(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		var request [8]byte
+		if _, err := io.ReadFull(server, request[:]); err != nil {
+			t.Error(err)
+			return
+		}
+		server.Write([]byte{0x11, 0x03, 0x02, 0x02, 0x2B, 0x38, 0xF8})
+	}()
+
+	handler := NewRTUClientHandlerWithPort(func() (io.ReadWriteCloser, error) {
+		return client, nil
+	})
+	handler.Address = "pipe"
+	handler.SlaveId = 0x11
+	handler.Timeout = time.Second
+	logger := &recordingLogger{}
+	handler.LeveledLogger = logger
+	defer handler.Close()
+
+	if _, err := NewClient(handler).ReadHoldingRegisters(0x006B, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.messages) != 2 || logger.messages[0] != "modbus: sending" || logger.messages[1] != "modbus: received" {
+		t.Fatalf("unexpected messages: %q", logger.messages)
+	}
+	entry := logger.entries[1]
+	if adu, ok := entry["adu"].(hexBytes); !ok || adu.String() != "11 03 02 02 2b 38 f8" || entry["address"] != "pipe" {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// MemoryRange is a range of valid addresses found by a MemoryProbe.
+type MemoryRange struct {
+	AddressRange
+	// Access is AccessReadOnly for discrete inputs and input registers,
+	// and for holding registers rejecting a mask write when writes are
+	// probed, AccessReadWrite otherwise.
+	Access Access
+}
+
+// MemoryProbe maps the valid addresses of a device.
+//
+// Each address space is read in blocks of the maximum quantity. A block
+// answered with an illegal data address is split in halves which are
+// read in turn, down to Resolution addresses, so that mapping a space
+// mostly invalid takes many requests: restrict Ranges where possible.
+// An address space answered with an illegal function is not probed
+// further, the ranges found in it until then are kept.
+type MemoryProbe struct {
+	Client Client
+	// Ranges are the ranges probed, default to all addresses of the four
+	// address spaces.
+	Ranges []AddressRange
+	// Resolution is the size of the smallest block read. Invalid blocks
+	// of up to Resolution addresses are not split and valid addresses in
+	// them are missed. Defaults to 1.
+	Resolution int
+	// Write probes whether the valid holding registers can be written
+	// with MaskWriteRegister and identity masks (AND 0xFFFF, OR 0x0000),
+	// which leave their values unchanged.
+	Write bool
+}
+
+// NewMemoryProbe allocates a MemoryProbe of all address spaces.
+func NewMemoryProbe(client Client) *MemoryProbe {
+	return &MemoryProbe{Client: client, Resolution: 1}
+}
+
+// errSpaceUnsupported stops the probe of an address space answered with
+// an illegal function.
+var errSpaceUnsupported = errors.New("modbus: address space not supported")
+
+// Probe returns the valid ranges, in the order of Ranges and by address.
+// It stops at the first error other than an exception.
+func (p *MemoryProbe) Probe(ctx context.Context) (ranges []MemoryRange, err error) {
+	type span struct {
+		space      AddressSpace
+		start, end int
+	}
+	var spans []span
+	if p.Ranges == nil {
+		for _, space := range []AddressSpace{SpaceCoils, SpaceDiscreteInputs, SpaceHoldingRegisters, SpaceInputRegisters} {
+			spans = append(spans, span{space, 0, 0x10000})
+		}
+	}
+	for _, r := range p.Ranges {
+		spans = append(spans, span{r.Space, int(r.Address), r.end()})
+	}
+
+	unsupported := make(map[AddressSpace]bool)
+	for _, s := range spans {
+		if unsupported[s.space] {
+			continue
+		}
+		var found []MemoryRange
+		add := func(start, end int) {
+			access := AccessReadWrite
+			if s.space == SpaceDiscreteInputs || s.space == SpaceInputRegisters {
+				access = AccessReadOnly
+			}
+			found = appendMemoryRange(found, s.space, start, end, access)
+		}
+		max := s.space.MaxQuantity()
+		for start := s.start; start < s.end && err == nil; start += max {
+			end := start + max
+			if end > s.end {
+				end = s.end
+			}
+			err = p.search(ctx, s.space, start, end, add)
+		}
+		if err == errSpaceUnsupported {
+			unsupported[s.space] = true
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+		if p.Write && s.space == SpaceHoldingRegisters {
+			if found, err = p.probeWrites(ctx, found); err != nil {
+				return
+			}
+		}
+		ranges = append(ranges, found...)
+	}
+	return
+}
+
+// search reads [start, end) and splits it while the addresses are
+// illegal.
+func (p *MemoryProbe) search(ctx context.Context, space AddressSpace, start, end int, add func(start, end int)) error {
+	_, err := space.read(ctx, p.Client, uint16(start), uint16(end-start))
+	if err == nil {
+		add(start, end)
+		return nil
+	}
+	var modbusError *ModbusError
+	if !errors.As(err, &modbusError) {
+		return err
+	}
+	switch modbusError.ExceptionCode {
+	case ExceptionCodeIllegalFunction:
+		return errSpaceUnsupported
+	case ExceptionCodeIllegalDataAddress, ExceptionCodeIllegalDataValue:
+		// Some devices answer an illegal data value to reads crossing
+		// the end of their memory
+	default:
+		return err
+	}
+	resolution := p.Resolution
+	if resolution < 1 {
+		resolution = 1
+	}
+	if end-start <= resolution {
+		return nil
+	}
+	middle := start + (end-start)/2
+	if err = p.search(ctx, space, start, middle, add); err != nil {
+		return err
+	}
+	return p.search(ctx, space, middle, end, add)
+}
+
+// probeWrites splits the holding register ranges by whether they accept
+// a mask write. The ranges are returned unchanged if the device does not
+// support MaskWriteRegister. Registers rejected with illegal data address
+// or value are read-only, other exceptions are returned.
+func (p *MemoryProbe) probeWrites(ctx context.Context, ranges []MemoryRange) (probed []MemoryRange, err error) {
+	for _, r := range ranges {
+		for address := int(r.Address); address < r.end(); address++ {
+			access := AccessReadWrite
+			if _, err = NewContextClient(p.Client).MaskWriteRegisterContext(ctx, uint16(address), 0xFFFF, 0x0000); err != nil {
+				var modbusError *ModbusError
+				if !errors.As(err, &modbusError) {
+					return
+				}
+				switch modbusError.ExceptionCode {
+				case ExceptionCodeIllegalFunction:
+					return ranges, nil
+				case ExceptionCodeIllegalDataAddress, ExceptionCodeIllegalDataValue:
+					access = AccessReadOnly
+					err = nil
+				default:
+					return
+				}
+			}
+			probed = appendMemoryRange(probed, r.Space, address, address+1, access)
+		}
+	}
+	return
+}
+
+// appendMemoryRange appends [start, end) to ranges, merged with the last
+// range if adjacent with the same access.
+func appendMemoryRange(ranges []MemoryRange, space AddressSpace, start, end int, access Access) []MemoryRange {
+	if n := len(ranges); n > 0 {
+		last := &ranges[n-1]
+		if last.Space == space && last.Access == access && last.end() == start && end-int(last.Address) <= 0xFFFF {
+			last.Quantity = uint16(end - int(last.Address))
+			return ranges
+		}
+	}
+	return append(ranges, MemoryRange{
+		AddressRange: AddressRange{Space: space, Address: uint16(start), Quantity: uint16(end - start)},
+		Access:       access,
+	})
+}
+
+// MemoryProfile returns a profile with one point per bit or register of
+// ranges, as a starting point for the register map of a device. Bits are
+// of type bool and registers of type uint16.
+func MemoryProfile(name string, ranges []MemoryRange) *Profile {
+	profile := &Profile{Name: name}
+	for _, r := range ranges {
+		prefix, dataType := "", TypeUint16
+		switch r.Space {
+		case SpaceCoils:
+			prefix, dataType = "coil", TypeBool
+		case SpaceDiscreteInputs:
+			prefix, dataType = "di", TypeBool
+		case SpaceHoldingRegisters:
+			prefix = "hr"
+		case SpaceInputRegisters:
+			prefix = "ir"
+		}
+		for address := int(r.Address); address < r.end(); address++ {
+			profile.Points = append(profile.Points, Point{
+				Name:    fmt.Sprintf("%s%d", prefix, address),
+				Space:   r.Space,
+				Address: uint16(address),
+				Type:    dataType,
+				Access:  r.Access,
+			})
+		}
+	}
+	return profile
+}
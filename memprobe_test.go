@@ -0,0 +1,185 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testMemory is a testDevice with holes in its memory, read-only holding
+// registers and holding registers failing writes. Discrete inputs are not
+// supported, nor reads overlapping unsupported.
+type testMemory struct {
+	testDevice
+
+	holes       []AddressRange
+	readOnly    AddressRange
+	failing     AddressRange
+	unsupported AddressRange
+}
+
+func (m *testMemory) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	functionCode := aduRequest[tcpHeaderSize]
+	address := int(binary.BigEndian.Uint16(aduRequest[tcpHeaderSize+1:]))
+	quantity := 1
+	space := SpaceHoldingRegisters
+	switch functionCode {
+	case FuncCodeReadCoils:
+		space = SpaceCoils
+	case FuncCodeReadDiscreteInputs:
+		return m.exception(aduRequest, ExceptionCodeIllegalFunction), nil
+	case FuncCodeReadInputRegisters:
+		space = SpaceInputRegisters
+	case FuncCodeMaskWriteRegister:
+		if m.readOnly.overlaps(space, address, address+1) {
+			return m.exception(aduRequest, ExceptionCodeIllegalDataAddress), nil
+		}
+		if m.failing.overlaps(space, address, address+1) {
+			return m.exception(aduRequest, ExceptionCodeServerDeviceFailure), nil
+		}
+	}
+	if functionCode != FuncCodeMaskWriteRegister {
+		quantity = int(binary.BigEndian.Uint16(aduRequest[tcpHeaderSize+3:]))
+	}
+	if m.unsupported.overlaps(space, address, address+quantity) {
+		return m.exception(aduRequest, ExceptionCodeIllegalFunction), nil
+	}
+	for _, hole := range m.holes {
+		if hole.overlaps(space, address, address+quantity) {
+			return m.exception(aduRequest, ExceptionCodeIllegalDataAddress), nil
+		}
+	}
+	return m.testDevice.Send(aduRequest)
+}
+
+func (m *testMemory) exception(aduRequest []byte, exceptionCode byte) []byte {
+	aduResponse := append([]byte{}, aduRequest[:tcpHeaderSize]...)
+	aduResponse[5] = 3
+	return append(aduResponse, aduRequest[tcpHeaderSize]|0x80, exceptionCode)
+}
+
+func TestMemoryProbe(t *testing.T) {
+	device := &testMemory{
+		holes: []AddressRange{
+			{Space: SpaceHoldingRegisters, Address: 10, Quantity: 5},
+			{Space: SpaceHoldingRegisters, Address: 130, Quantity: 1},
+			{Space: SpaceCoils, Address: 0, Quantity: 8},
+		},
+		readOnly: AddressRange{Space: SpaceHoldingRegisters, Address: 2, Quantity: 2},
+	}
+	device.Size = 200
+	device.setHolding(0, 1, 2, 3, 4, 5)
+	probe := NewMemoryProbe(NewClient(device))
+	probe.Ranges = []AddressRange{
+		{Space: SpaceCoils, Address: 0, Quantity: 300},
+		{Space: SpaceDiscreteInputs, Address: 0, Quantity: 300},
+		{Space: SpaceHoldingRegisters, Address: 0, Quantity: 300},
+		{Space: SpaceInputRegisters, Address: 100, Quantity: 50},
+	}
+	ranges, err := probe.Probe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []MemoryRange{
+		{AddressRange{SpaceCoils, 8, 192}, AccessReadWrite},
+		{AddressRange{SpaceHoldingRegisters, 0, 10}, AccessReadWrite},
+		{AddressRange{SpaceHoldingRegisters, 15, 115}, AccessReadWrite},
+		{AddressRange{SpaceHoldingRegisters, 131, 69}, AccessReadWrite},
+		{AddressRange{SpaceInputRegisters, 100, 50}, AccessReadOnly},
+	}
+	if !reflect.DeepEqual(expected, ranges) {
+		t.Fatalf("expected %v, actual %v", expected, ranges)
+	}
+
+	probe.Write = true
+	probe.Ranges = []AddressRange{{Space: SpaceHoldingRegisters, Address: 0, Quantity: 12}}
+	if ranges, err = probe.Probe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected = []MemoryRange{
+		{AddressRange{SpaceHoldingRegisters, 0, 2}, AccessReadWrite},
+		{AddressRange{SpaceHoldingRegisters, 2, 2}, AccessReadOnly},
+		{AddressRange{SpaceHoldingRegisters, 4, 6}, AccessReadWrite},
+	}
+	if !reflect.DeepEqual(expected, ranges) {
+		t.Fatalf("expected %v, actual %v", expected, ranges)
+	}
+	// Identity masks leave the values unchanged
+	if !reflect.DeepEqual([]uint16{1, 2, 3, 4, 5}, device.holding[:5]) {
+		t.Fatalf("holding registers changed: %v", device.holding[:5])
+	}
+
+	profile := MemoryProfile("probed", ranges)
+	if err = profile.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(profile.Points) != 10 || profile.Points[3].Name != "hr3" || profile.Points[3].Access != AccessReadOnly {
+		t.Fatalf("unexpected profile %+v", profile)
+	}
+}
+
+func TestMemoryProbeWriteException(t *testing.T) {
+	device := &testMemory{
+		failing: AddressRange{Space: SpaceHoldingRegisters, Address: 5, Quantity: 1},
+	}
+	device.Size = 10
+	probe := NewMemoryProbe(NewClient(device))
+	probe.Ranges = []AddressRange{{Space: SpaceHoldingRegisters, Address: 0, Quantity: 10}}
+	probe.Write = true
+	// A failed write does not tell that the register is read-only
+	_, err := probe.Probe(context.Background())
+	var modbusError *ModbusError
+	if !errors.As(err, &modbusError) || modbusError.ExceptionCode != ExceptionCodeServerDeviceFailure {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMemoryProbeIllegalFunction(t *testing.T) {
+	device := &testMemory{
+		unsupported: AddressRange{Space: SpaceHoldingRegisters, Address: 200, Quantity: 100},
+	}
+	device.Size = 1000
+	probe := NewMemoryProbe(NewClient(device))
+	probe.Ranges = []AddressRange{
+		{Space: SpaceHoldingRegisters, Address: 0, Quantity: 300},
+		{Space: SpaceInputRegisters, Address: 0, Quantity: 10},
+		{Space: SpaceHoldingRegisters, Address: 400, Quantity: 10},
+	}
+	ranges, err := probe.Probe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The holding registers found before the illegal function are kept,
+	// the other holding registers are not probed
+	expected := []MemoryRange{
+		{AddressRange{SpaceHoldingRegisters, 0, 125}, AccessReadWrite},
+		{AddressRange{SpaceInputRegisters, 0, 10}, AccessReadOnly},
+	}
+	if !reflect.DeepEqual(expected, ranges) {
+		t.Fatalf("expected %v, actual %v", expected, ranges)
+	}
+}
+
+func TestMemoryProbeResolution(t *testing.T) {
+	device := &testMemory{}
+	device.Size = 100
+	client := NewClient(device)
+	probe := NewMemoryProbe(client)
+	probe.Resolution = 16
+	probe.Ranges = []AddressRange{{Space: SpaceInputRegisters, Address: 0, Quantity: 125}}
+	ranges, err := probe.Probe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The invalid block [93, 109) is not split
+	expected := []MemoryRange{{AddressRange{SpaceInputRegisters, 0, 93}, AccessReadOnly}}
+	if !reflect.DeepEqual(expected, ranges) {
+		t.Fatalf("expected %v, actual %v", expected, ranges)
+	}
+}
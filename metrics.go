@@ -0,0 +1,216 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Observer is notified of every transaction of a Client once it has
+// completed. The transaction is filled in by the client and the
+// transporter, e.g. with latency, reconnects and error class.
+type Observer interface {
+	ObserveTransaction(tx *Transaction)
+}
+
+// ObserverInterceptor notifies observer of every transaction of a Client.
+func ObserverInterceptor(observer Observer) Interceptor {
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, request *ProtocolDataUnit) (*ProtocolDataUnit, error) {
+			response, err := next.RoundTrip(ctx, request)
+			if tx := TransactionFromContext(ctx); tx != nil {
+				observer.ObserveTransaction(tx)
+			}
+			return response, err
+		})
+	}
+}
+
+// Default buckets of the latency histogram, in seconds
+var metricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics is an Observer which aggregates transactions and renders them
+// in the Prometheus text exposition format:
+//
+//	modbus_requests_total{transport,address,slave_id,function_code}
+//	modbus_errors_total{transport,address,slave_id,function_code,class,exception_code}
+//	modbus_request_duration_seconds{transport,address,slave_id,function_code}
+//	modbus_reconnects_total{transport,address}
+//	modbus_checksum_failures_total{transport,address,slave_id}
+//
+// Metrics implements http.Handler to be served on the metrics endpoint.
+type Metrics struct {
+	// Upper bounds of the latency histogram buckets in seconds, in
+	// increasing order. It must be set before the first transaction.
+	Buckets []float64
+
+	mu        sync.Mutex
+	requests  map[string]uint64
+	errors    map[string]uint64
+	latencies map[string]*histogram
+	reconnect map[string]uint64
+	checksum  map[string]uint64
+}
+
+// histogram counts observations per bucket, the last one is +Inf.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewMetrics allocates a Metrics with the default buckets.
+func NewMetrics() *Metrics {
+	return &Metrics{Buckets: metricsBuckets}
+}
+
+// Interceptor returns an interceptor which feeds m with the transactions
+// of a Client.
+func (m *Metrics) Interceptor() Interceptor {
+	return ObserverInterceptor(m)
+}
+
+// ObserveTransaction implements Observer.
+func (m *Metrics) ObserveTransaction(tx *Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.requests == nil {
+		m.requests = make(map[string]uint64)
+		m.errors = make(map[string]uint64)
+		m.latencies = make(map[string]*histogram)
+		m.reconnect = make(map[string]uint64)
+		m.checksum = make(map[string]uint64)
+	}
+	connection := labels("transport", tx.Transport, "address", tx.Address)
+	device := connection + "," + labels("slave_id", strconv.Itoa(int(tx.SlaveId)))
+	function := device + "," + labels("function_code", strconv.Itoa(int(tx.FunctionCode)))
+
+	m.requests[function]++
+	h, ok := m.latencies[function]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.Buckets)+1)}
+		m.latencies[function] = h
+	}
+	seconds := tx.Latency.Seconds()
+	i := sort.SearchFloat64s(m.Buckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+	if tx.Reconnects > 0 {
+		m.reconnect[connection] += uint64(tx.Reconnects)
+	}
+	if tx.Err != nil {
+		exceptionCode := ""
+		var e *ModbusError
+		if errors.As(tx.Err, &e) {
+			exceptionCode = strconv.Itoa(int(e.ExceptionCode))
+		}
+		m.errors[function+","+labels("class", tx.ErrorClass, "exception_code", exceptionCode)]++
+		if tx.ErrorClass == ErrorClassChecksum {
+			m.checksum[device]++
+		}
+	}
+}
+
+// ServeHTTP renders the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format to w.
+func (m *Metrics) WriteTo(w io.Writer) (n int64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &countingWriter{w: w}
+	buf := bufio.NewWriter(c)
+	writeCounter(buf, "modbus_requests_total", "Requests sent.", m.requests)
+	writeCounter(buf, "modbus_errors_total", "Requests failed, by error class and exception code.", m.errors)
+
+	fmt.Fprintf(buf, "# HELP modbus_request_duration_seconds Time spent in the transport.\n")
+	fmt.Fprintf(buf, "# TYPE modbus_request_duration_seconds histogram\n")
+	for _, key := range sortedKeys(m.latencies) {
+		h := m.latencies[key]
+		var cumulative uint64
+		for i, count := range h.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(m.Buckets) {
+				le = strconv.FormatFloat(m.Buckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(buf, "modbus_request_duration_seconds_bucket{%s,%s} %d\n", key, labels("le", le), cumulative)
+		}
+		fmt.Fprintf(buf, "modbus_request_duration_seconds_sum{%s} %v\n", key, h.sum)
+		fmt.Fprintf(buf, "modbus_request_duration_seconds_count{%s} %d\n", key, h.count)
+	}
+
+	writeCounter(buf, "modbus_reconnects_total", "Connections re-established by the transport.", m.reconnect)
+	writeCounter(buf, "modbus_checksum_failures_total", "Responses with CRC or LRC mismatch.", m.checksum)
+	err = buf.Flush()
+	n = c.n
+	return
+}
+
+func writeCounter(w io.Writer, name, help string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, key, values[key])
+	}
+}
+
+func sortedKeys(values map[string]*histogram) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labels formats name and value pairs as Prometheus labels.
+func labels(nameValues ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(nameValues[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(nameValues[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,44 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	handler := &scriptedHandler{
+		responses: []*ProtocolDataUnit{
+			{FunctionCode: 0x03, Data: []byte{2, 0x12, 0x34}},
+			{FunctionCode: 0x83, Data: []byte{ExceptionCodeIllegalDataAddress}},
+			nil,
+		},
+	}
+	handler.SlaveId = 3
+	metrics := NewMetrics()
+	client := NewClient(handler, metrics.Interceptor())
+	for i := 0; i < 3; i++ {
+		client.ReadHoldingRegisters(1, 1)
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+
+	device := `transport="",address="",slave_id="3",function_code="3"`
+	for _, line := range []string{
+		`modbus_requests_total{` + device + `} 3`,
+		`modbus_errors_total{` + device + `,class="exception",exception_code="2"} 1`,
+		`modbus_errors_total{` + device + `,class="timeout",exception_code=""} 1`,
+		`modbus_request_duration_seconds_bucket{` + device + `,le="+Inf"} 3`,
+		`modbus_request_duration_seconds_count{` + device + `} 3`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+}
@@ -8,6 +8,7 @@ Package modbus provides a client for MODBUS TCP and RTU/ASCII.
 package modbus
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -28,6 +29,13 @@ const (
 	FuncCodeReadWriteMultipleRegisters = 23 // 0x17
 	FuncCodeReadFIFOQueue              = 24 // 0x18
 	FuncCodeReadDeviceIdentification   = 43 // 0x2B
+
+	// Diagnostics
+	FuncCodeReadExceptionStatus = 7  // 0x07
+	FuncCodeDiagnostics         = 8  // 0x08
+	FuncCodeGetCommEventCounter = 11 // 0x0B
+	FuncCodeGetCommEventLog     = 12 // 0x0C
+	FuncCodeReportSlaveId       = 17 // 0x11
 )
 
 const (
@@ -76,6 +84,23 @@ func (e *ModbusError) Error() string {
 	return fmt.Sprintf("modbus: exception '%v' (%s), function '%v'", e.ExceptionCode, name, e.FunctionCode)
 }
 
+// Is reports whether the exception is of the kind target, so that
+// errors.Is matches ErrAcknowledge, ErrServerDeviceBusy,
+// ErrGatewayPathUnavailable and ErrGatewayTargetFailed.
+func (e *ModbusError) Is(target error) bool {
+	switch target {
+	case ErrAcknowledge:
+		return e.ExceptionCode == ExceptionCodeAcknowledge
+	case ErrServerDeviceBusy:
+		return e.ExceptionCode == ExceptionCodeServerDeviceBusy
+	case ErrGatewayPathUnavailable:
+		return e.ExceptionCode == ExceptionCodeGatewayPathUnavailable
+	case ErrGatewayTargetFailed:
+		return e.ExceptionCode == ExceptionCodeGatewayTargetDeviceFailedToRespond
+	}
+	return false
+}
+
 // ProtocolDataUnit (PDU) is independent of underlying communication layers.
 type ProtocolDataUnit struct {
 	FunctionCode byte
@@ -119,3 +144,11 @@ type Packager interface {
 type Transporter interface {
 	Send(aduRequest []byte) (aduResponse []byte, err error)
 }
+
+// ContextTransporter is a Transporter which can abandon a request when
+// the context is done. The transport must be usable for the next request
+// after SendContext returns ctx.Err().
+type ContextTransporter interface {
+	Transporter
+	SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error)
+}
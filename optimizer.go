@@ -0,0 +1,202 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+)
+
+// AddressRange is a range of consecutive bits or registers of an address
+// space.
+type AddressRange struct {
+	Space    AddressSpace
+	Address  uint16
+	Quantity uint16
+}
+
+// end returns the address following the range.
+func (r AddressRange) end() int {
+	return int(r.Address) + int(r.Quantity)
+}
+
+// overlaps reports whether the range has an address in [start, end).
+func (r AddressRange) overlaps(space AddressSpace, start, end int) bool {
+	return r.Space == space && int(r.Address) < end && start < r.end()
+}
+
+// ReadOptimizer plans the reads of scattered bits and registers as few
+// block reads as possible.
+type ReadOptimizer struct {
+	// MaxGap is the number of unwanted bits or registers which may be read
+	// to join two ranges in one read.
+	MaxGap int
+	// Holes are ranges which the device refuses to read. They are never
+	// read to join two ranges.
+	Holes []AddressRange
+	// MaxRegisters and MaxBits limit the quantity of one read, default to
+	// 125 and 2000 respectively.
+	MaxRegisters int
+	MaxBits      int
+}
+
+// Plan returns the reads covering the wanted ranges, sorted by address
+// space and address.
+func (o *ReadOptimizer) Plan(wanted []AddressRange) (reads []AddressRange) {
+	ranges := make([]AddressRange, 0, len(wanted))
+	for _, r := range wanted {
+		if r.Quantity > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		if ranges[i].Space != ranges[j].Space {
+			return ranges[i].Space < ranges[j].Space
+		}
+		return ranges[i].Address < ranges[j].Address
+	})
+	var start, end int
+	var space AddressSpace
+	open := false
+	flush := func() {
+		max := o.maxQuantity(space)
+		for ; start < end; start += max {
+			quantity := end - start
+			if quantity > max {
+				quantity = max
+			}
+			reads = append(reads, AddressRange{Space: space, Address: uint16(start), Quantity: uint16(quantity)})
+		}
+	}
+	for _, r := range ranges {
+		if open && r.Space == space {
+			if r.end() <= end {
+				// Already covered
+				continue
+			}
+			if o.joinable(space, start, end, r) {
+				end = r.end()
+				continue
+			}
+		}
+		if open {
+			flush()
+		}
+		space, start, end, open = r.Space, int(r.Address), r.end(), true
+	}
+	if open {
+		flush()
+	}
+	return
+}
+
+// joinable reports whether r can be read with [start, end).
+func (o *ReadOptimizer) joinable(space AddressSpace, start, end int, r AddressRange) bool {
+	if int(r.Address) > end+o.MaxGap {
+		return false
+	}
+	if r.end()-start > o.maxQuantity(space) && int(r.Address) > end {
+		// Better start a new read than split r
+		return false
+	}
+	if int(r.Address) > end {
+		for _, hole := range o.Holes {
+			if hole.overlaps(space, end, int(r.Address)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (o *ReadOptimizer) maxQuantity(space AddressSpace) int {
+	if space.IsBit() {
+		if o.MaxBits > 0 {
+			return o.MaxBits
+		}
+	} else if o.MaxRegisters > 0 {
+		return o.MaxRegisters
+	}
+	return space.MaxQuantity()
+}
+
+// Read reads the wanted ranges with the reads planned by Plan and returns
+// the values read. It stops at the first read failing.
+func (o *ReadOptimizer) Read(ctx context.Context, client Client, wanted []AddressRange) (values *Values, err error) {
+	values = &Values{}
+	for _, r := range o.Plan(wanted) {
+		var data []byte
+		if data, err = r.Space.read(ctx, client, r.Address, r.Quantity); err != nil {
+			return
+		}
+		values.put(r, data)
+	}
+	return
+}
+
+// valueKey is the address of a bit or register.
+type valueKey struct {
+	space   AddressSpace
+	address uint16
+}
+
+// Values holds bits and registers read by address.
+type Values struct {
+	values map[valueKey]uint16
+}
+
+// put stores the bits or registers read from r.
+func (v *Values) put(r AddressRange, data []byte) {
+	if v.values == nil {
+		v.values = make(map[valueKey]uint16)
+	}
+	for i := 0; i < int(r.Quantity); i++ {
+		key := valueKey{r.Space, r.Address + uint16(i)}
+		if r.Space.IsBit() {
+			if i/8 < len(data) {
+				v.values[key] = uint16(data[i/8]>>uint(i%8)) & 1
+			}
+		} else if 2*i+1 < len(data) {
+			v.values[key] = binary.BigEndian.Uint16(data[2*i:])
+		}
+	}
+}
+
+// Bit returns the coil or discrete input at address and whether it was
+// read.
+func (v *Values) Bit(space AddressSpace, address uint16) (value bool, ok bool) {
+	bit, ok := v.values[valueKey{space, address}]
+	return bit != 0, ok
+}
+
+// Register returns the register at address and whether it was read.
+func (v *Values) Register(space AddressSpace, address uint16) (value uint16, ok bool) {
+	value, ok = v.values[valueKey{space, address}]
+	return
+}
+
+// Bytes returns the range as returned by the read functions: packed bits,
+// least significant bit first, or big-endian registers. It reports false
+// if any address of the range was not read.
+func (v *Values) Bytes(r AddressRange) (data []byte, ok bool) {
+	if r.Space.IsBit() {
+		data = make([]byte, (int(r.Quantity)+7)/8)
+	} else {
+		data = make([]byte, 2*int(r.Quantity))
+	}
+	for i := 0; i < int(r.Quantity); i++ {
+		value, read := v.values[valueKey{r.Space, r.Address + uint16(i)}]
+		if !read {
+			return nil, false
+		}
+		if r.Space.IsBit() {
+			data[i/8] |= byte(value) << uint(i%8)
+		} else {
+			binary.BigEndian.PutUint16(data[2*i:], value)
+		}
+	}
+	return data, true
+}
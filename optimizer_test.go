@@ -0,0 +1,79 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestReadOptimizerPlan(t *testing.T) {
+	optimizer := &ReadOptimizer{
+		MaxGap: 5,
+		Holes:  []AddressRange{{Space: SpaceHoldingRegisters, Address: 40, Quantity: 2}},
+	}
+	reads := optimizer.Plan([]AddressRange{
+		{Space: SpaceHoldingRegisters, Address: 10, Quantity: 2},
+		{Space: SpaceHoldingRegisters, Address: 0, Quantity: 1},
+		{Space: SpaceHoldingRegisters, Address: 3, Quantity: 1},
+		{Space: SpaceHoldingRegisters, Address: 11, Quantity: 1},
+		// Not joined across the hole
+		{Space: SpaceHoldingRegisters, Address: 38, Quantity: 1},
+		{Space: SpaceHoldingRegisters, Address: 43, Quantity: 1},
+		// Split at the limit of 125 registers
+		{Space: SpaceInputRegisters, Address: 100, Quantity: 100},
+		{Space: SpaceInputRegisters, Address: 200, Quantity: 100},
+		{Space: SpaceCoils, Address: 0, Quantity: 1},
+		{Space: SpaceCoils, Address: 4, Quantity: 1996},
+		{Space: SpaceCoils, Address: 2000, Quantity: 1},
+	})
+	expected := []AddressRange{
+		{Space: SpaceCoils, Address: 0, Quantity: 2000},
+		{Space: SpaceCoils, Address: 2000, Quantity: 1},
+		{Space: SpaceHoldingRegisters, Address: 0, Quantity: 4},
+		{Space: SpaceHoldingRegisters, Address: 10, Quantity: 2},
+		{Space: SpaceHoldingRegisters, Address: 38, Quantity: 1},
+		{Space: SpaceHoldingRegisters, Address: 43, Quantity: 1},
+		{Space: SpaceInputRegisters, Address: 100, Quantity: 125},
+		{Space: SpaceInputRegisters, Address: 225, Quantity: 75},
+	}
+	if !reflect.DeepEqual(expected, reads) {
+		t.Fatalf("expected %v, actual %v", expected, reads)
+	}
+}
+
+func TestReadOptimizerRead(t *testing.T) {
+	device := &testDevice{}
+	device.setHolding(100, 1, 2, 3, 4, 5, 6)
+	device.coils[7] = true
+	client := NewClient(device)
+
+	optimizer := &ReadOptimizer{MaxGap: 10}
+	values, err := optimizer.Read(context.Background(), client, []AddressRange{
+		{Space: SpaceHoldingRegisters, Address: 100, Quantity: 1},
+		{Space: SpaceHoldingRegisters, Address: 104, Quantity: 2},
+		{Space: SpaceCoils, Address: 7, Quantity: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.requests != 2 {
+		t.Fatalf("requests: expected %v, actual %v", 2, device.requests)
+	}
+	if value, ok := values.Register(SpaceHoldingRegisters, 105); !ok || value != 6 {
+		t.Fatalf("register 105: unexpected %v, %v", value, ok)
+	}
+	if value, ok := values.Bit(SpaceCoils, 7); !ok || !value {
+		t.Fatalf("coil 7: unexpected %v, %v", value, ok)
+	}
+	data, ok := values.Bytes(AddressRange{Space: SpaceHoldingRegisters, Address: 104, Quantity: 2})
+	if expected := []byte{0, 5, 0, 6}; !ok || !reflect.DeepEqual(expected, data) {
+		t.Fatalf("bytes: expected % x, actual % x", expected, data)
+	}
+	if _, ok := values.Register(SpaceHoldingRegisters, 200); ok {
+		t.Fatal("register 200 is not read")
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// Default polling configuration
+	pollerInterval       = time.Second
+	pollerMaxConcurrency = 1
+	pollerBufferSize     = 64
+)
+
+// Quality tells whether the value of a sample is current.
+type Quality int
+
+const (
+	// QualityGood is a value just read from the device.
+	QualityGood Quality = iota
+	// QualityBad is a tag which could not be read. The sample holds the
+	// last good value, if any.
+	QualityBad
+)
+
+func (q Quality) String() string {
+	if q == QualityGood {
+		return "good"
+	}
+	return "bad"
+}
+
+// Tag is a value polled by a Poller.
+type Tag struct {
+	Name string
+	// Client of the device holding the value.
+	Client  Client
+	Space   AddressSpace
+	Address uint16
+	// Type and Order of the value in registers. Values in coils and
+	// discrete inputs are always TypeBool, TypeBool values in registers
+	// are true for any value but zero.
+	Type  DataType
+	Order WordOrder
+	// Interval between reads, defaults to Poller.Interval.
+	Interval time.Duration
+	// Deadband is the change of value, from the last sample delivered,
+	// which is not reported.
+	Deadband float64
+}
+
+// Sample is the value of a tag delivered by a Poller.
+type Sample struct {
+	Tag     *Tag
+	Value   float64
+	Quality Quality
+	// Err is the failure of the last read for QualityBad.
+	Err  error
+	Time time.Time
+}
+
+// Poller reads tags at their interval and delivers samples when values
+// change beyond their deadband or their quality changes. Reads failing,
+// e.g. with a timeout or an exception, do not stop polling but deliver a
+// sample with QualityBad until the tag is read again.
+type Poller struct {
+	// Interval of the tags without their own interval.
+	Interval time.Duration
+	// MaxConcurrency is the maximum number of reads in flight per Client.
+	MaxConcurrency int
+	// OnSample, if set, is called with the samples instead of sending them
+	// on the Samples channel. It is called from several goroutines.
+	OnSample func(sample Sample)
+
+	mu      sync.Mutex
+	tags    []*Tag
+	devices map[Client]chan struct{}
+	samples chan Sample
+	started bool
+}
+
+// NewPoller allocates a Poller with the default configuration.
+func NewPoller() *Poller {
+	return &Poller{
+		Interval:       pollerInterval,
+		MaxConcurrency: pollerMaxConcurrency,
+		samples:        make(chan Sample, pollerBufferSize),
+	}
+}
+
+// Add adds a tag to poll and returns the tag referenced by its samples.
+// Tags must be added before Run.
+func (p *Poller) Add(tag Tag) *Tag {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := &tag
+	p.tags = append(p.tags, t)
+	return t
+}
+
+// Samples returns the channel on which samples are delivered unless
+// OnSample is set. It must be drained while the poller is running and is
+// closed when Run returns.
+func (p *Poller) Samples() <-chan Sample {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.samples == nil {
+		p.samples = make(chan Sample, pollerBufferSize)
+	}
+	return p.samples
+}
+
+// Run polls the tags until ctx is done and returns its error. A poller
+// runs only once, Run returns an error when called again.
+func (p *Poller) Run(ctx context.Context) error {
+	p.mu.Lock()
+	if p.started {
+		p.mu.Unlock()
+		return errors.New("modbus: poller has already been run")
+	}
+	p.started = true
+	if p.samples == nil && p.OnSample == nil {
+		p.samples = make(chan Sample, pollerBufferSize)
+	}
+	samples := p.samples
+	tags := p.tags
+	p.devices = make(map[Client]chan struct{})
+	for _, tag := range tags {
+		if _, ok := p.devices[tag.Client]; !ok {
+			p.devices[tag.Client] = make(chan struct{}, p.maxConcurrency())
+		}
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, tag := range tags {
+		wg.Add(1)
+		go func(tag *Tag) {
+			defer wg.Done()
+			p.poll(ctx, tag)
+		}(tag)
+	}
+	wg.Wait()
+	if samples != nil {
+		close(samples)
+	}
+	return ctx.Err()
+}
+
+func (p *Poller) maxConcurrency() int {
+	if p.MaxConcurrency > 0 {
+		return p.MaxConcurrency
+	}
+	return pollerMaxConcurrency
+}
+
+// poll reads tag at its interval until ctx is done.
+func (p *Poller) poll(ctx context.Context, tag *Tag) {
+	interval := tag.Interval
+	if interval <= 0 {
+		interval = p.Interval
+	}
+	if interval <= 0 {
+		interval = pollerInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last *Sample
+	for {
+		sample := p.read(ctx, tag)
+		if ctx.Err() != nil {
+			return
+		}
+		if last != nil && sample.Quality == QualityBad {
+			sample.Value = last.Value
+		}
+		if last == nil || sample.Quality != last.Quality ||
+			math.Abs(sample.Value-last.Value) > tag.Deadband {
+			if !p.deliver(ctx, sample) {
+				return
+			}
+			last = &sample
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// read reads tag once the device allows another read in flight.
+func (p *Poller) read(ctx context.Context, tag *Tag) (sample Sample) {
+	sample.Tag = tag
+	device := p.devices[tag.Client]
+	select {
+	case device <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+	dataType, quantity := tag.Type, tag.Type.Registers()
+	switch {
+	case tag.Space.IsBit():
+		dataType, quantity = TypeBool, 1
+	case dataType == TypeBool:
+		// Any value but zero of the register is true
+		dataType = TypeUint16
+	}
+	data, err := tag.Space.read(ctx, tag.Client, tag.Address, uint16(quantity))
+	<-device
+
+	sample.Time = time.Now()
+	if err == nil {
+		sample.Value, err = DecodeValue(data, dataType, tag.Order)
+	}
+	if err == nil && dataType != tag.Type && sample.Value != 0 {
+		sample.Value = 1
+	}
+	if err != nil {
+		sample.Quality = QualityBad
+		sample.Err = err
+	}
+	return
+}
+
+// deliver delivers sample and reports whether ctx is still running.
+func (p *Poller) deliver(ctx context.Context, sample Sample) bool {
+	if p.OnSample != nil {
+		p.OnSample(sample)
+		return true
+	}
+	select {
+	case p.samples <- sample:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDevice answers TCP requests from its memory. Addresses from Size
+// on are illegal unless Size is zero.
+type testDevice struct {
+	tcpPackager
+
+	Size int
+
+	mu       sync.Mutex
+	coils    [65536]bool
+	inputs   [65536]bool
+	holding  [65536]uint16
+	input    [65536]uint16
+	requests int
+	failures int
+}
+
+func (d *testDevice) setHolding(address uint16, values ...uint16) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	copy(d.holding[address:], values)
+}
+
+// fail makes the next n requests time out.
+func (d *testDevice) fail(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures = n
+}
+
+func (d *testDevice) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.requests++
+	if d.failures > 0 {
+		d.failures--
+		return nil, tcpTimeoutError{}
+	}
+	pdu := d.handle(aduRequest[tcpHeaderSize], aduRequest[tcpHeaderSize+1:])
+	aduResponse = make([]byte, tcpHeaderSize+1+len(pdu.Data))
+	copy(aduResponse, aduRequest[:tcpHeaderSize])
+	binary.BigEndian.PutUint16(aduResponse[4:], uint16(2+len(pdu.Data)))
+	aduResponse[tcpHeaderSize] = pdu.FunctionCode
+	copy(aduResponse[tcpHeaderSize+1:], pdu.Data)
+	return
+}
+
+func (d *testDevice) handle(functionCode byte, data []byte) *ProtocolDataUnit {
+	address := int(binary.BigEndian.Uint16(data))
+	quantity := 1
+	switch functionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs,
+		FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		quantity = int(binary.BigEndian.Uint16(data[2:]))
+	case FuncCodeWriteSingleCoil, FuncCodeWriteSingleRegister, FuncCodeMaskWriteRegister:
+	default:
+		return &ProtocolDataUnit{FunctionCode: functionCode | 0x80, Data: []byte{ExceptionCodeIllegalFunction}}
+	}
+	if d.Size > 0 && address+quantity > d.Size {
+		return &ProtocolDataUnit{FunctionCode: functionCode | 0x80, Data: []byte{ExceptionCodeIllegalDataAddress}}
+	}
+	response := &ProtocolDataUnit{FunctionCode: functionCode}
+	switch functionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs:
+		bits := d.coils[:]
+		if functionCode == FuncCodeReadDiscreteInputs {
+			bits = d.inputs[:]
+		}
+		response.Data = make([]byte, 1+(quantity+7)/8)
+		response.Data[0] = byte(len(response.Data) - 1)
+		for i := 0; i < quantity; i++ {
+			if bits[address+i] {
+				response.Data[1+i/8] |= 1 << uint(i%8)
+			}
+		}
+	case FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters:
+		registers := d.holding[:]
+		if functionCode == FuncCodeReadInputRegisters {
+			registers = d.input[:]
+		}
+		response.Data = make([]byte, 1+2*quantity)
+		response.Data[0] = byte(2 * quantity)
+		for i := 0; i < quantity; i++ {
+			binary.BigEndian.PutUint16(response.Data[1+2*i:], registers[address+i])
+		}
+	case FuncCodeWriteSingleCoil:
+		d.coils[address] = data[2] == 0xFF
+		response.Data = data
+	case FuncCodeWriteSingleRegister:
+		d.holding[address] = binary.BigEndian.Uint16(data[2:])
+		response.Data = data
+	case FuncCodeWriteMultipleCoils:
+		for i := 0; i < quantity; i++ {
+			d.coils[address+i] = data[5+i/8]&(1<<uint(i%8)) != 0
+		}
+		response.Data = data[:4]
+	case FuncCodeWriteMultipleRegisters:
+		for i := 0; i < quantity; i++ {
+			d.holding[address+i] = binary.BigEndian.Uint16(data[5+2*i:])
+		}
+		response.Data = data[:4]
+	case FuncCodeMaskWriteRegister:
+		and, or := binary.BigEndian.Uint16(data[2:]), binary.BigEndian.Uint16(data[4:])
+		d.holding[address] = d.holding[address]&and | or&^and
+		response.Data = data
+	}
+	return response
+}
+
+func TestPoller(t *testing.T) {
+	device := &testDevice{}
+	device.SlaveId = 1
+	device.setHolding(10, 0x4049, 0x0FDB) // 3.1415927
+	client := NewClient(device)
+
+	poller := NewPoller()
+	poller.Interval = 5 * time.Millisecond
+	voltage := poller.Add(Tag{Name: "voltage", Client: client, Space: SpaceHoldingRegisters, Address: 10, Type: TypeFloat32, Deadband: 0.5})
+	counter := poller.Add(Tag{Name: "counter", Client: client, Space: SpaceHoldingRegisters, Address: 20, Type: TypeUint16})
+
+	samples := map[*Tag]chan Sample{
+		voltage: make(chan Sample, 100),
+		counter: make(chan Sample, 100),
+	}
+	poller.OnSample = func(sample Sample) {
+		samples[sample.Tag] <- sample
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- poller.Run(ctx)
+	}()
+	expect := func(tag *Tag, value float64, quality Quality) {
+		t.Helper()
+		select {
+		case sample := <-samples[tag]:
+			if sample.Quality != quality || (quality == QualityGood && sample.Value != value) {
+				t.Fatalf("%v: unexpected sample %+v", tag.Name, sample)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v: no sample", tag.Name)
+		}
+	}
+	expect(voltage, float64(float32(3.1415927)), QualityGood)
+	expect(counter, 0, QualityGood)
+
+	// Changes within the deadband are not delivered
+	device.setHolding(10, 0x4050, 0x0000) // 3.25
+	device.setHolding(20, 1)
+	expect(counter, 1, QualityGood)
+	device.setHolding(10, 0x4080, 0x0000) // 4
+	expect(voltage, 4, QualityGood)
+
+	// Polling goes on through failures
+	device.fail(1000)
+	expect(counter, 0, QualityBad)
+	device.fail(0)
+	device.setHolding(20, 2)
+	expect(counter, 2, QualityGood)
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected canceled, actual %v", err)
+	}
+}
+
+func TestPollerZeroValue(t *testing.T) {
+	device := &testDevice{}
+	device.SlaveId = 1
+	device.setHolding(30, 0x0100)
+	client := NewClient(device)
+
+	samples := make(chan Sample, 100)
+	poller := &Poller{Interval: 5 * time.Millisecond, OnSample: func(sample Sample) {
+		samples <- sample
+	}}
+	poller.Add(Tag{Name: "alarm", Client: client, Space: SpaceHoldingRegisters, Address: 30, Type: TypeBool})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- poller.Run(ctx)
+	}()
+	select {
+	case sample := <-samples:
+		// Bits of the high byte are true too
+		if sample.Quality != QualityGood || sample.Value != 1 {
+			t.Fatalf("unexpected sample %+v", sample)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no sample")
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected canceled, actual %v", err)
+	}
+	if err := poller.Run(context.Background()); err == nil {
+		t.Fatal("second run is expected to fail")
+	}
+}
@@ -0,0 +1,557 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Access tells whether a point may be read and written.
+type Access int
+
+const (
+	AccessReadWrite Access = iota
+	AccessReadOnly
+	AccessWriteOnly
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessReadWrite:
+		return "rw"
+	case AccessReadOnly:
+		return "r"
+	case AccessWriteOnly:
+		return "w"
+	}
+	return fmt.Sprintf("Access(%d)", int(a))
+}
+
+// ParseAccess parses an access mode, "r", "w" or "rw" and their long
+// forms such as "read-only".
+func ParseAccess(name string) (Access, error) {
+	switch normalizeName(name) {
+	case "rw", "readwrite", "":
+		return AccessReadWrite, nil
+	case "r", "ro", "read", "readonly":
+		return AccessReadOnly, nil
+	case "w", "wo", "write", "writeonly":
+		return AccessWriteOnly, nil
+	}
+	return 0, fmt.Errorf("modbus: invalid access '%v'", name)
+}
+
+// defaultAccess returns the access of points in space when none is given,
+// read-only for discrete inputs and input registers.
+func defaultAccess(space AddressSpace) Access {
+	if space == SpaceDiscreteInputs || space == SpaceInputRegisters {
+		return AccessReadOnly
+	}
+	return AccessReadWrite
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (a Access) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler with ParseAccess.
+func (a *Access) UnmarshalText(text []byte) (err error) {
+	*a, err = ParseAccess(string(text))
+	return
+}
+
+// Point is a named value of a device profile. Its engineering value is
+// the raw value multiplied by Scale plus Offset. Bools held in a register
+// are true for any value but zero.
+type Point struct {
+	Name    string       `json:"name"`
+	Space   AddressSpace `json:"space"`
+	Address uint16       `json:"address"`
+	Type    DataType     `json:"type"`
+	Order   WordOrder    `json:"order"`
+	// Scale defaults to 1 when zero.
+	Scale  float64 `json:"scale,omitempty"`
+	Offset float64 `json:"offset,omitempty"`
+	Units  string  `json:"units,omitempty"`
+	// Access defaults to read-only in discrete inputs and input
+	// registers when not given.
+	Access      Access `json:"access"`
+	Description string `json:"description,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Access defaults to read-only
+// for points in discrete inputs and input registers.
+func (p *Point) UnmarshalJSON(data []byte) error {
+	type point Point
+	var fields struct {
+		*point
+		Access *Access `json:"access"`
+	}
+	fields.point = (*point)(p)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if fields.Access != nil {
+		p.Access = *fields.Access
+	} else {
+		p.Access = defaultAccess(p.Space)
+	}
+	return nil
+}
+
+// Range returns the bits or registers holding the point.
+func (p *Point) Range() AddressRange {
+	quantity := 1
+	if !p.Space.IsBit() {
+		quantity = p.Type.Registers()
+	}
+	return AddressRange{Space: p.Space, Address: p.Address, Quantity: uint16(quantity)}
+}
+
+func (p *Point) scale() float64 {
+	if p.Scale == 0 {
+		return 1
+	}
+	return p.Scale
+}
+
+// Profile is the register map of a device.
+type Profile struct {
+	Name   string  `json:"name,omitempty"`
+	Points []Point `json:"points"`
+}
+
+// Point returns the point with name, or nil if there is none.
+func (p *Profile) Point(name string) *Point {
+	for i := range p.Points {
+		if p.Points[i].Name == name {
+			return &p.Points[i]
+		}
+	}
+	return nil
+}
+
+// Validate checks that points have unique names, bit points are bools
+// and registers are writable when the point is.
+func (p *Profile) Validate() error {
+	names := make(map[string]bool, len(p.Points))
+	for i := range p.Points {
+		point := &p.Points[i]
+		if point.Name == "" {
+			return fmt.Errorf("modbus: point %v has no name", i+1)
+		}
+		if names[point.Name] {
+			return fmt.Errorf("modbus: point '%v' is defined twice", point.Name)
+		}
+		names[point.Name] = true
+		if point.Space.IsBit() && point.Type != TypeBool {
+			return fmt.Errorf("modbus: point '%v' in %v must be of type bool", point.Name, point.Space)
+		}
+		if point.Access != AccessReadOnly && (point.Space == SpaceDiscreteInputs || point.Space == SpaceInputRegisters) {
+			return fmt.Errorf("modbus: point '%v' in %v must be read-only", point.Name, point.Space)
+		}
+	}
+	return nil
+}
+
+// LoadProfile loads a profile from a file in the format of its extension:
+// .csv, .json, .yaml or .yml.
+func LoadProfile(path string) (*Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return LoadProfileCSV(f)
+	case ".json":
+		return LoadProfileJSON(f)
+	case ".yaml", ".yml":
+		return LoadProfileYAML(f)
+	}
+	return nil, fmt.Errorf("modbus: unknown profile format '%v'", filepath.Ext(path))
+}
+
+// LoadProfileJSON loads a profile from JSON:
+//
+//	{"name": "meter", "points": [{"name": "PhaseA.Voltage",
+//	 "space": "holding", "address": 0, "type": "float32", ...}]}
+//
+// Enumerations are written as accepted by ParseAddressSpace,
+// ParseDataType, ParseWordOrder and ParseAccess.
+func LoadProfileJSON(r io.Reader) (*Profile, error) {
+	profile := &Profile{}
+	if err := json.NewDecoder(r).Decode(profile); err != nil {
+		return nil, err
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// LoadProfileCSV loads a profile from CSV with a header row naming the
+// columns, in any order: name, space, address, type, order, scale, offset,
+// units, access and description. Only name, space and address are
+// required. Address is the offset of the point in its address space and
+// offset the offset of its value.
+func LoadProfileCSV(r io.Reader) (*Profile, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("modbus: profile has no header")
+	}
+	header := records[0]
+	for i := range header {
+		header[i] = normalizeName(header[i])
+	}
+	profile := &Profile{}
+	for line, record := range records[1:] {
+		fields := make(map[string]string, len(header))
+		for i, value := range record {
+			if i < len(header) {
+				fields[header[i]] = value
+			}
+		}
+		point, err := parsePoint(fields)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: line %v: %v", line+2, err)
+		}
+		profile.Points = append(profile.Points, point)
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// LoadProfileYAML loads a profile from YAML:
+//
+//	name: meter
+//	points:
+//	  - name: PhaseA.Voltage
+//	    space: holding
+//	    address: 0
+//	    type: float32
+//	    scale: 0.1
+//
+// Only this subset of YAML is supported: top-level scalars and a list of
+// points of scalar fields, with comments and quoted strings.
+func LoadProfileYAML(r io.Reader) (*Profile, error) {
+	document, err := parseYAML(r)
+	if err != nil {
+		return nil, err
+	}
+	profile := &Profile{Name: document.fields["name"]}
+	for i, fields := range document.points {
+		point, err := parsePoint(fields)
+		if err != nil {
+			return nil, fmt.Errorf("modbus: point %v: %v", i+1, err)
+		}
+		profile.Points = append(profile.Points, point)
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// parsePoint parses a point from its fields by normalized name.
+func parsePoint(fields map[string]string) (point Point, err error) {
+	field := func(name string) string {
+		return strings.TrimSpace(fields[name])
+	}
+	point.Name = field("name")
+	point.Units = field("units")
+	point.Description = field("description")
+	if point.Space, err = ParseAddressSpace(field("space")); err != nil {
+		return
+	}
+	var address uint64
+	if address, err = strconv.ParseUint(field("address"), 0, 16); err != nil {
+		err = fmt.Errorf("modbus: invalid address '%v'", field("address"))
+		return
+	}
+	point.Address = uint16(address)
+	if point.Space.IsBit() && field("type") == "" {
+		point.Type = TypeBool
+	} else if point.Type, err = ParseDataType(field("type")); err != nil {
+		return
+	}
+	if point.Order, err = ParseWordOrder(field("order")); err != nil {
+		return
+	}
+	if field("access") == "" {
+		point.Access = defaultAccess(point.Space)
+	} else if point.Access, err = ParseAccess(field("access")); err != nil {
+		return
+	}
+	for name, value := range map[string]*float64{"scale": &point.Scale, "offset": &point.Offset} {
+		if text := field(name); text != "" {
+			if *value, err = strconv.ParseFloat(text, 64); err != nil {
+				err = fmt.Errorf("modbus: invalid %v '%v'", name, text)
+				return
+			}
+		}
+	}
+	return
+}
+
+// yamlDocument is a profile parsed from YAML.
+type yamlDocument struct {
+	fields map[string]string
+	points []map[string]string
+}
+
+// parseYAML parses the subset of YAML of LoadProfileYAML.
+func parseYAML(r io.Reader) (document *yamlDocument, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	document = &yamlDocument{fields: make(map[string]string)}
+	var point map[string]string
+	inPoints := false
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		text := strings.TrimSpace(line)
+		if text == "" || text == "---" {
+			continue
+		}
+		indented := line[0] == ' ' || line[0] == '\t'
+		if !indented {
+			inPoints = false
+			key, value, ok := splitYAMLField(text)
+			if !ok {
+				return nil, fmt.Errorf("modbus: line %v: expected 'key: value'", i+1)
+			}
+			key = normalizeName(key)
+			if key == "points" && value == "" {
+				inPoints = true
+				continue
+			}
+			document.fields[key] = value
+			continue
+		}
+		if !inPoints {
+			return nil, fmt.Errorf("modbus: line %v: unexpected indentation", i+1)
+		}
+		if strings.HasPrefix(text, "-") {
+			point = make(map[string]string)
+			document.points = append(document.points, point)
+			if text = strings.TrimSpace(text[1:]); text == "" {
+				continue
+			}
+		}
+		key, value, ok := splitYAMLField(text)
+		if !ok || point == nil {
+			return nil, fmt.Errorf("modbus: line %v: expected '- key: value'", i+1)
+		}
+		point[normalizeName(key)] = value
+	}
+	return
+}
+
+// splitYAMLField splits "key: value" and unquotes value.
+func splitYAMLField(text string) (key, value string, ok bool) {
+	i := strings.Index(text, ":")
+	if i < 0 {
+		return
+	}
+	key = strings.TrimSpace(text[:i])
+	value = strings.TrimSpace(text[i+1:])
+	if n := len(value); n >= 2 {
+		switch {
+		case value[0] == '"' && value[n-1] == '"':
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+		case value[0] == '\'' && value[n-1] == '\'':
+			value = strings.Replace(value[1:n-1], "''", "'", -1)
+		}
+	}
+	return key, value, key != ""
+}
+
+// stripYAMLComment removes a comment starting with '#' outside quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Device reads and writes the points of a profile by name.
+type Device struct {
+	Client  Client
+	Profile *Profile
+	// Optimizer plans the reads of ReadAll.
+	Optimizer ReadOptimizer
+}
+
+// NewDevice allocates a Device reading the points of profile with client.
+func NewDevice(client Client, profile *Profile) *Device {
+	return &Device{Client: client, Profile: profile}
+}
+
+// Read reads the engineering value of the point with name.
+func (d *Device) Read(name string) (float64, error) {
+	return d.ReadContext(context.Background(), name)
+}
+
+// ReadContext is like Read but stops when ctx is done.
+func (d *Device) ReadContext(ctx context.Context, name string) (value float64, err error) {
+	point, err := d.point(name)
+	if err != nil {
+		return
+	}
+	if point.Access == AccessWriteOnly {
+		err = fmt.Errorf("modbus: point '%v' is write-only", name)
+		return
+	}
+	r := point.Range()
+	data, err := r.Space.read(ctx, d.Client, r.Address, r.Quantity)
+	if err != nil {
+		return
+	}
+	return point.decode(data)
+}
+
+// ReadAll reads the engineering values of all readable points, with as few
+// requests as planned by Optimizer.
+func (d *Device) ReadAll(ctx context.Context) (values map[string]float64, err error) {
+	var wanted []AddressRange
+	for i := range d.Profile.Points {
+		if d.Profile.Points[i].Access != AccessWriteOnly {
+			wanted = append(wanted, d.Profile.Points[i].Range())
+		}
+	}
+	read, err := d.Optimizer.Read(ctx, d.Client, wanted)
+	if err != nil {
+		return
+	}
+	values = make(map[string]float64, len(wanted))
+	for i := range d.Profile.Points {
+		point := &d.Profile.Points[i]
+		if point.Access == AccessWriteOnly {
+			continue
+		}
+		data, ok := read.Bytes(point.Range())
+		if !ok {
+			return nil, fmt.Errorf("modbus: point '%v' has not been read", point.Name)
+		}
+		if values[point.Name], err = point.decode(data); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Write writes the engineering value of the point with name.
+func (d *Device) Write(name string, value float64) error {
+	return d.WriteContext(context.Background(), name, value)
+}
+
+// WriteContext is like Write but stops when ctx is done.
+func (d *Device) WriteContext(ctx context.Context, name string, value float64) (err error) {
+	point, err := d.point(name)
+	if err != nil {
+		return
+	}
+	if point.Access == AccessReadOnly {
+		return fmt.Errorf("modbus: point '%v' is read-only", name)
+	}
+	raw := (value - point.Offset) / point.scale()
+	client := NewContextClient(d.Client)
+	switch point.Space {
+	case SpaceCoils:
+		if err = checkValue(raw, TypeBool); err != nil {
+			return
+		}
+		var state uint16
+		if raw != 0 {
+			state = 0xFF00
+		}
+		_, err = client.WriteSingleCoilContext(ctx, point.Address, state)
+	case SpaceHoldingRegisters:
+		dataType := point.Type
+		if dataType == TypeBool {
+			if err = checkValue(raw, TypeBool); err != nil {
+				return
+			}
+			if raw != 0 {
+				raw = 1
+			}
+			dataType = TypeUint16
+		}
+		var data []byte
+		if data, err = EncodeValue(raw, dataType, point.Order); err != nil {
+			return
+		}
+		if len(data) == 2 {
+			_, err = client.WriteSingleRegisterContext(ctx, point.Address, uint16(data[0])<<8|uint16(data[1]))
+		} else {
+			_, err = client.WriteMultipleRegistersContext(ctx, point.Address, uint16(len(data)/2), data)
+		}
+	default:
+		err = fmt.Errorf("modbus: point '%v' in %v is not writable", name, point.Space)
+	}
+	return
+}
+
+func (d *Device) point(name string) (*Point, error) {
+	point := d.Profile.Point(name)
+	if point == nil {
+		return nil, fmt.Errorf("modbus: unknown point '%v'", name)
+	}
+	return point, nil
+}
+
+// decode decodes the engineering value of the point from data read.
+func (p *Point) decode(data []byte) (value float64, err error) {
+	dataType := p.Type
+	switch {
+	case p.Space.IsBit():
+		dataType = TypeBool
+	case dataType == TypeBool:
+		// Any value but zero of the register is true
+		dataType = TypeUint16
+	}
+	if value, err = DecodeValue(data, dataType, p.Order); err != nil {
+		return
+	}
+	if dataType != p.Type && value != 0 {
+		value = 1
+	}
+	return value*p.scale() + p.Offset, nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testProfile = &Profile{
+	Name: "meter",
+	Points: []Point{
+		{Name: "PhaseA.Voltage", Space: SpaceHoldingRegisters, Address: 0, Type: TypeUint16, Scale: 0.5, Units: "V", Access: AccessReadOnly, Description: "Voltage, phase A"},
+		{Name: "Energy", Space: SpaceHoldingRegisters, Address: 2, Type: TypeUint32, Order: WordOrderCDAB, Units: "Wh", Access: AccessReadOnly},
+		{Name: "Setpoint", Space: SpaceHoldingRegisters, Address: 10, Type: TypeInt16, Offset: -40},
+		{Name: "Relay", Space: SpaceCoils, Address: 3, Type: TypeBool},
+	},
+}
+
+func TestLoadProfileCSV(t *testing.T) {
+	profile, err := LoadProfileCSV(strings.NewReader(`Name,Space,Address,Type,Order,Scale,Offset,Units,Access,Description
+# Measurements
+PhaseA.Voltage,holding,0,uint16,,0.5,,V,r,"Voltage, phase A"
+Energy,HR,2,uint32,CDAB,,,Wh,read-only,
+Setpoint,holding register,0x0A,int16,,,-40,,rw,
+Relay,coil,3,,,,,,,
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.Name = "meter"
+	if !reflect.DeepEqual(testProfile, profile) {
+		t.Fatalf("expected %+v, actual %+v", testProfile, profile)
+	}
+}
+
+func TestLoadProfileJSON(t *testing.T) {
+	profile, err := LoadProfileJSON(strings.NewReader(`{
+		"name": "meter",
+		"points": [
+			{"name": "PhaseA.Voltage", "space": "holding", "address": 0, "type": "uint16",
+			 "scale": 0.5, "units": "V", "access": "r", "description": "Voltage, phase A"},
+			{"name": "Energy", "space": "holding", "address": 2, "type": "uint32",
+			 "order": "CDAB", "units": "Wh", "access": "r"},
+			{"name": "Setpoint", "space": "holding", "address": 10, "type": "int16", "offset": -40},
+			{"name": "Relay", "space": "coils", "address": 3, "type": "bool"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(testProfile, profile) {
+		t.Fatalf("expected %+v, actual %+v", testProfile, profile)
+	}
+}
+
+func TestLoadProfileYAML(t *testing.T) {
+	profile, err := LoadProfileYAML(strings.NewReader(`# Energy meter
+name: meter
+points:
+  - name: PhaseA.Voltage
+    space: holding
+    address: 0
+    type: uint16
+    scale: 0.5
+    units: V
+    access: r
+    description: "Voltage, phase A" # quoted
+  - name: Energy
+    space: holding
+    address: 2
+    type: uint32
+    order: CDAB
+    units: Wh
+    access: read-only
+  -
+    name: 'Setpoint'
+    space: holding
+    address: 10
+    type: int16
+    offset: -40
+  - name: Relay
+    space: coils
+    address: 3
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(testProfile, profile) {
+		t.Fatalf("expected %+v, actual %+v", testProfile, profile)
+	}
+}
+
+func TestProfileValidate(t *testing.T) {
+	_, err := LoadProfileCSV(strings.NewReader("name,space,address,type,access\nTemperature,input,0,int16,rw\n"))
+	if err == nil {
+		t.Fatal("input registers must be read-only")
+	}
+	// Input registers and discrete inputs are read-only by default
+	profile, err := LoadProfileCSV(strings.NewReader("name,space,address,type\nTemperature,input,0,int16\nAlarm,discrete,1,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Points[0].Access != AccessReadOnly || profile.Points[1].Access != AccessReadOnly {
+		t.Fatalf("unexpected points: %+v", profile.Points)
+	}
+	profile, err = LoadProfileJSON(strings.NewReader(`{"points": [{"name": "Temperature", "space": "input", "address": 0, "type": "int16"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Points[0].Access != AccessReadOnly {
+		t.Fatalf("unexpected points: %+v", profile.Points)
+	}
+	_, err = LoadProfileCSV(strings.NewReader("name,space,address,type\nA,coil,0,\nA,coil,1,\n"))
+	if err == nil {
+		t.Fatal("names must be unique")
+	}
+}
+
+func TestDevice(t *testing.T) {
+	device := &testDevice{}
+	device.setHolding(0, 460, 0, 0x5678, 0x1234)
+	device.coils[3] = true
+	dev := NewDevice(NewClient(device), testProfile)
+
+	voltage, err := dev.Read("PhaseA.Voltage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if voltage != 230 {
+		t.Fatalf("voltage: expected %v, actual %v", 230, voltage)
+	}
+	if err = dev.Write("Setpoint", 21); err != nil {
+		t.Fatal(err)
+	}
+	if device.holding[10] != 61 {
+		t.Fatalf("setpoint: expected %v, actual %v", 61, device.holding[10])
+	}
+	var validationError *ValidationError
+	if err = dev.Write("Setpoint", 40000); !errors.As(err, &validationError) || device.holding[10] != 61 {
+		t.Fatalf("out of range value is written: %v", err)
+	}
+	if err = dev.Write("Energy", 1); err == nil {
+		t.Fatal("read-only point is written")
+	}
+	if _, err = dev.Read("Unknown"); err == nil {
+		t.Fatal("unknown point is read")
+	}
+
+	device.requests = 0
+	dev.Optimizer.MaxGap = 10
+	values, err := dev.ReadAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{"PhaseA.Voltage": 230, "Energy": 0x12345678, "Setpoint": 21, "Relay": 1}
+	if !reflect.DeepEqual(expected, values) {
+		t.Fatalf("expected %v, actual %v", expected, values)
+	}
+	if device.requests != 2 {
+		t.Fatalf("requests: expected %v, actual %v", 2, device.requests)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	// Default retry policy
+	retryMaxAttempts    = 3
+	retryInitialBackoff = 100 * time.Millisecond
+	retryMaxBackoff     = 5 * time.Second
+	retryMultiplier     = 2
+	retryJitter         = 0.2
+)
+
+// RetryHandler implements Packager and Transporter interface. It resends
+// requests of the wrapped handler which fail with a transient error,
+// waiting with exponential backoff between attempts.
+type RetryHandler struct {
+	ClientHandler
+
+	// Maximum number of attempts, including the first one
+	MaxAttempts int
+	// Wait before the second attempt, multiplied by Multiplier for each
+	// further attempt and limited to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Fraction of the backoff to randomize, from 0 to 1
+	Jitter float64
+	// Classifier reports whether a failed request may succeed when
+	// resent, defaults to IsTransient.
+	Classifier func(err error) bool
+	// Idempotent reports whether requests with the function code may be
+	// resent, defaults to all functions which do not write. Requests
+	// encoded by packagers of other packages are only resent when marked
+	// with WithIdempotent.
+	Idempotent func(functionCode byte) bool
+}
+
+// NewRetryHandler allocates a RetryHandler around handler with the
+// default retry policy.
+func NewRetryHandler(handler ClientHandler) *RetryHandler {
+	return &RetryHandler{
+		ClientHandler:  handler,
+		MaxAttempts:    retryMaxAttempts,
+		InitialBackoff: retryInitialBackoff,
+		MaxBackoff:     retryMaxBackoff,
+		Multiplier:     retryMultiplier,
+		Jitter:         retryJitter,
+	}
+}
+
+type idempotentKey struct{}
+
+// WithIdempotent returns a context which marks the request sent with it
+// as safe to resend, e.g. a write of an absolute value.
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// IsTransient reports whether err is a failure which may not occur again
+// when the request is resent: timeouts, connection errors, CRC and LRC
+// mismatches caused by line noise and the exceptions acknowledge and
+// server device busy. Other failures, such as the other exceptions, other
+// corrupted frames and invalid requests, and the requests whose context
+// is done are permanent.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var modbusError *ModbusError
+	if errors.As(err, &modbusError) {
+		return modbusError.ExceptionCode == ExceptionCodeAcknowledge ||
+			modbusError.ExceptionCode == ExceptionCodeServerDeviceBusy
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrCRCMismatch) || errors.Is(err, ErrLRCMismatch) {
+		return true
+	}
+	return isTimeout(err) || isConnectionError(err)
+}
+
+// isConnectionError reports whether err is a failure of the connection or
+// port, e.g. a connection refused, reset or closed by the device.
+func isConnectionError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var opError *net.OpError
+	return errors.As(err, &opError)
+}
+
+// Send sends the request, resending it on transient failures.
+func (mb *RetryHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but stops resending when ctx is done.
+func (mb *RetryHandler) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	attempts := mb.MaxAttempts
+	if !mb.idempotent(ctx, aduRequest) {
+		attempts = 1
+	}
+	classifier := mb.Classifier
+	if classifier == nil {
+		classifier = IsTransient
+	}
+	for attempt := 1; ; attempt++ {
+		aduResponse, err = sendContext(ctx, mb.ClientHandler, aduRequest)
+		failure := err
+		if failure == nil {
+			failure = checkResponse(mb.ClientHandler, aduRequest, aduResponse)
+		}
+		if failure == nil || attempt >= attempts || !classifier(failure) {
+			return
+		}
+		timer := time.NewTimer(mb.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			if err == nil {
+				// The client reports the error of the last response
+				return
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close closes the wrapped handler if it can be closed.
+func (mb *RetryHandler) Close() error {
+	if closer, ok := mb.ClientHandler.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (mb *RetryHandler) slaveIdOf(adu []byte) (byte, bool) {
+	return aduSlaveId(mb.ClientHandler, adu)
+}
+
+func (mb *RetryHandler) encodingSlaveId() (byte, bool) {
+	return packagerSlaveId(mb.ClientHandler)
+}
+
+func (mb *RetryHandler) functionCodeOf(adu []byte) (byte, bool) {
+	return aduFunctionCode(mb.ClientHandler, adu)
+}
+
+func (mb *RetryHandler) withSlaveId(slaveId byte) Packager {
+	packager, _ := packagerWithSlaveId(mb.ClientHandler, slaveId)
+	return packager
+}
+
+func (mb *RetryHandler) setTimeout(timeout time.Duration) (time.Duration, bool) {
+	return setHandlerTimeout(mb.ClientHandler, timeout)
+}
+
+// idempotent reports whether the request may be resent.
+func (mb *RetryHandler) idempotent(ctx context.Context, aduRequest []byte) bool {
+	if marked, _ := ctx.Value(idempotentKey{}).(bool); marked {
+		return true
+	}
+	functionCode, ok := aduFunctionCode(mb.ClientHandler, aduRequest)
+	if !ok {
+		return false
+	}
+	if mb.Idempotent != nil {
+		return mb.Idempotent(functionCode)
+	}
+	return !isWriteFunction(functionCode)
+}
+
+// backoff returns the wait after the given attempt.
+func (mb *RetryHandler) backoff(attempt int) time.Duration {
+	multiplier := mb.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(mb.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if mb.MaxBackoff > 0 && backoff > float64(mb.MaxBackoff) {
+		backoff = float64(mb.MaxBackoff)
+	}
+	if mb.Jitter > 0 {
+		backoff += backoff * mb.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
@@ -0,0 +1,112 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// scriptedHandler answers TCP requests with the scripted PDUs in order.
+type scriptedHandler struct {
+	tcpPackager
+	responses []*ProtocolDataUnit
+	requests  int
+}
+
+func (mb *scriptedHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	pdu := mb.responses[mb.requests]
+	mb.requests++
+	if pdu == nil {
+		return nil, tcpTimeoutError{}
+	}
+	aduResponse = make([]byte, tcpHeaderSize+1+len(pdu.Data))
+	copy(aduResponse, aduRequest[:tcpHeaderSize])
+	binary.BigEndian.PutUint16(aduResponse[4:], uint16(2+len(pdu.Data)))
+	aduResponse[tcpHeaderSize] = pdu.FunctionCode
+	copy(aduResponse[tcpHeaderSize+1:], pdu.Data)
+	return
+}
+
+func TestRetryHandler(t *testing.T) {
+	handler := &scriptedHandler{
+		responses: []*ProtocolDataUnit{
+			nil,
+			{FunctionCode: 0x83, Data: []byte{ExceptionCodeServerDeviceBusy}},
+			{FunctionCode: 0x03, Data: []byte{2, 0x12, 0x34}},
+		},
+	}
+	retry := NewRetryHandler(handler)
+	retry.InitialBackoff = time.Millisecond
+
+	results, err := NewClient(retry).ReadHoldingRegisters(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x12, 0x34}; !bytes.Equal(expected, results) {
+		t.Fatalf("results: expected % x, actual % x", expected, results)
+	}
+	if handler.requests != 3 {
+		t.Fatalf("requests: expected %v, actual %v", 3, handler.requests)
+	}
+}
+
+func TestRetryHandlerPermanent(t *testing.T) {
+	handler := &scriptedHandler{
+		responses: []*ProtocolDataUnit{
+			{FunctionCode: 0x83, Data: []byte{ExceptionCodeIllegalDataAddress}},
+			{FunctionCode: 0x86, Data: []byte{ExceptionCodeServerDeviceBusy}},
+		},
+	}
+	retry := NewRetryHandler(handler)
+	retry.InitialBackoff = time.Millisecond
+	client := NewClient(retry)
+
+	if _, err := client.ReadHoldingRegisters(1, 1); err == nil {
+		t.Fatal("illegal data address is expected to fail")
+	}
+	// Writes are not resent unless idempotent
+	if _, err := client.WriteSingleRegister(1, 1); err == nil {
+		t.Fatal("server device busy is expected to fail")
+	}
+	if handler.requests != 2 {
+		t.Fatalf("requests: expected %v, actual %v", 2, handler.requests)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{tcpTimeoutError{}, true},
+		{&timeoutError{serial.ErrTimeout}, true},
+		{io.EOF, true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{&ModbusError{FunctionCode: 0x83, ExceptionCode: ExceptionCodeAcknowledge}, true},
+		{&ModbusError{FunctionCode: 0x83, ExceptionCode: ExceptionCodeServerDeviceBusy}, true},
+		{&ModbusError{FunctionCode: 0x83, ExceptionCode: ExceptionCodeGatewayTargetDeviceFailedToRespond}, false},
+		{&ModbusError{FunctionCode: 0x83, ExceptionCode: ExceptionCodeIllegalDataAddress}, false},
+		{newFrameError(ErrCRCMismatch, nil, nil, "crc"), true},
+		{newFrameError(ErrLRCMismatch, nil, nil, "lrc"), true},
+		{newFrameError(ErrLengthMismatch, nil, nil, "length"), false},
+		{errors.New("unknown"), false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, test := range tests {
+		if transient := IsTransient(test.err); transient != test.transient {
+			t.Errorf("%v: expected transient %v, actual %v", test.err, test.transient, transient)
+		}
+	}
+}
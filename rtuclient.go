@@ -5,9 +5,9 @@
 package modbus
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
-	"io"
 	"time"
 )
 
@@ -33,6 +33,16 @@ func NewRTUClientHandler(address string) *RTUClientHandler {
 	return handler
 }
 
+// NewRTUClientHandlerWithPort allocates and initializes a RTUClientHandler whose
+// port is opened by factory instead of a serial device.
+func NewRTUClientHandlerWithPort(factory PortFactory) *RTUClientHandler {
+	handler := &RTUClientHandler{}
+	handler.PortFactory = factory
+	handler.Timeout = serialTimeout
+	handler.IdleTimeout = serialIdleTimeout
+	return handler
+}
+
 // RTUClient creates RTU client with default handler and given connect string.
 func RTUClient(address string) Client {
 	handler := NewRTUClientHandler(address)
@@ -51,9 +61,17 @@ type rtuPackager struct {
 //	Data            : 0 up to 252 bytes
 //	CRC             : 2 byte
 func (mb *rtuPackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
+	if err = checkBroadcast(mb.SlaveId, pdu.FunctionCode); err != nil {
+		return
+	}
 	length := len(pdu.Data) + 4
 	if length > rtuMaxSize {
-		err = fmt.Errorf("modbus: length of data '%v' must not be bigger than '%v'", length, rtuMaxSize)
+		err = &ValidationError{
+			Field: "length",
+			Value: length,
+			Max:   rtuMaxSize,
+			Msg:   fmt.Sprintf("modbus: length of data '%v' must not be bigger than '%v'", length, rtuMaxSize),
+		}
 		return
 	}
 	adu = make([]byte, length)
@@ -77,17 +95,39 @@ func (mb *rtuPackager) Verify(aduRequest []byte, aduResponse []byte) (err error)
 	length := len(aduResponse)
 	// Minimum size (including address, function and CRC)
 	if length < rtuMinSize {
-		err = fmt.Errorf("modbus: response length '%v' does not meet minimum '%v'", length, rtuMinSize)
+		err = newFrameError(ErrLengthMismatch, aduRequest, aduResponse, "modbus: response length '%v' does not meet minimum '%v'", length, rtuMinSize)
 		return
 	}
 	// Slave address must match
 	if aduResponse[0] != aduRequest[0] {
-		err = fmt.Errorf("modbus: response slave id '%v' does not match request '%v'", aduResponse[0], aduRequest[0])
+		err = newFrameError(ErrSlaveIdMismatch, aduRequest, aduResponse, "modbus: response slave id '%v' does not match request '%v'", aduResponse[0], aduRequest[0])
 		return
 	}
 	return
 }
 
+func (mb *rtuPackager) withSlaveId(slaveId byte) Packager {
+	return &rtuPackager{SlaveId: slaveId}
+}
+
+func (mb *rtuPackager) slaveIdOf(adu []byte) (byte, bool) {
+	if len(adu) < rtuMinSize {
+		return 0, false
+	}
+	return adu[0], true
+}
+
+func (mb *rtuPackager) encodingSlaveId() (byte, bool) {
+	return mb.SlaveId, true
+}
+
+func (mb *rtuPackager) functionCodeOf(adu []byte) (byte, bool) {
+	if len(adu) < rtuMinSize {
+		return 0, false
+	}
+	return adu[1], true
+}
+
 // Decode extracts PDU from RTU frame and verify CRC.
 func (mb *rtuPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
 	length := len(adu)
@@ -96,7 +136,7 @@ func (mb *rtuPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
 	crc.reset().pushBytes(adu[0 : length-2])
 	checksum := uint16(adu[length-1])<<8 | uint16(adu[length-2])
 	if checksum != crc.value() {
-		err = fmt.Errorf("modbus: response crc '%v' does not match expected '%v'", checksum, crc.value())
+		err = newFrameError(ErrCRCMismatch, nil, adu, "modbus: response crc '%v' does not match expected '%v'", checksum, crc.value())
 		return
 	}
 	// Function code & data
@@ -109,61 +149,289 @@ func (mb *rtuPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
 // rtuSerialTransporter implements Transporter interface.
 type rtuSerialTransporter struct {
 	serialPort
+
+	// SilentIntervalFraming delimits responses by the silent interval of
+	// 3.5 characters (t3.5) instead of their expected length, and rejects
+	// responses with gaps longer than 1.5 characters (t1.5) between
+	// characters. The timings are derived from BaudRate, fixed to 750µs and
+	// 1750µs above 19200 baud. It requires a serial device, which is read
+	// with a timeout of t3.5, or a port opened by PortFactory which supports
+	// read deadlines.
+	SilentIntervalFraming bool
+	// FramingSlack is added to t1.5 and t3.5 to absorb the latency of the
+	// operating system and adapter, e.g. the latency timer of USB adapters.
+	FramingSlack time.Duration
 }
 
 func (mb *rtuSerialTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but abandons the request when ctx is done.
+func (mb *rtuSerialTransporter) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	mb.serialPort.mu.Lock()
+	defer mb.serialPort.mu.Unlock()
+
+	if tx := TransactionFromContext(ctx); tx != nil {
+		tx.Transport = "rtu"
+		tx.Address = mb.Address
+		defer func(connects int) {
+			tx.Reconnects = reconnects(connects, mb.serialPort.connects)
+		}(mb.serialPort.connects)
+	}
+
 	// Make sure port is connected
 	if err = mb.serialPort.connect(); err != nil {
 		return
 	}
+	if err = mb.serialPort.flush(); err != nil {
+		return
+	}
+	if err = mb.serialPort.setReadDeadline(); err != nil {
+		return
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	defer mb.serialPort.watch(ctx)(&aduResponse, &err)
 	// Start the timer to close when idle
 	mb.serialPort.lastActivity = time.Now()
 	mb.serialPort.startCloseTimer()
 
 	// Send the request
-	mb.serialPort.logf("modbus: sending % x\n", aduRequest)
-	if _, err = mb.port.Write(aduRequest); err != nil {
+	mb.serialPort.log(ctx, LogLevelDebug, "modbus: sending", LogField{"adu", hexBytes(aduRequest)})
+	if err = mb.serialPort.write(aduRequest); err != nil {
 		return
 	}
-	function := aduRequest[1]
-	functionFail := aduRequest[1] & 0x80
-	bytesToRead := calculateResponseLength(aduRequest)
-	time.Sleep(mb.calculateDelay(len(aduRequest) + bytesToRead))
-
-	var n int
-	var n1 int
-	var data [rtuMaxSize]byte
-	//We first read the minimum length and then read either the full package
-	//or the error package, depending on the error status (byte 2 of the response)
-	n, err = io.ReadAtLeast(mb.port, data[:], rtuMinSize)
+	// Broadcast requests are not answered
+	if aduRequest[0] == 0 {
+		mb.serialPort.turnaround()
+		return
+	}
+	if mb.SilentIntervalFraming {
+		aduResponse, err = mb.receiveFrame(aduRequest)
+	} else {
+		bytesToRead := calculateResponseLength(aduRequest)
+		time.Sleep(mb.calculateDelay(len(aduRequest) + bytesToRead))
+		aduResponse, err = mb.receive(aduRequest)
+	}
 	if err != nil {
 		return
 	}
-	//if the function is correct
-	if data[1] == function {
-		//we read the rest of the bytes
-		if n < bytesToRead {
-			if bytesToRead > rtuMinSize && bytesToRead <= rtuMaxSize {
-				if bytesToRead > n {
-					n1, err = io.ReadFull(mb.port, data[n:bytesToRead])
-					n += n1
+	mb.serialPort.log(ctx, LogLevelDebug, "modbus: received", LogField{"adu", hexBytes(aduResponse)})
+	return
+}
+
+// receive reads until it finds the response to aduRequest: a frame with
+// the slave id and function code of the request and a valid CRC. Leading
+// junk such as line noise, late responses to previous requests or frames
+// of other masters is discarded. If no valid frame is received, the last
+// frame with an invalid CRC, if any, is returned to be reported by Decode.
+func (mb *rtuSerialTransporter) receive(aduRequest []byte) (aduResponse []byte, err error) {
+	var data [2 * rtuMaxSize]byte
+	var n, start, n1 int
+	var corrupted []byte
+	for {
+		if n == len(data) {
+			// Keep the data which may still be the start of the response
+			if start == 0 {
+				start = 1
+			}
+			mb.serialPort.log(context.Background(), LogLevelWarn, "modbus: discarded", LogField{"adu", hexBytes(data[:start])})
+			n = copy(data[:], data[start:n])
+			start = 0
+		}
+		n1, err = mb.port.Read(data[n:])
+		n += n1
+		// Junk is the data which cannot be the start of the response
+		// whatever is received next.
+		junk := true
+		for i := start; i < n; i++ {
+			if !rtuResponseStart(aduRequest, data[i:n]) {
+				if junk {
+					start = i + 1
+				}
+				continue
+			}
+			length := rtuResponseLength(aduRequest, data[i:n])
+			switch {
+			case length > rtuMaxSize:
+				length = 0
+			case length < 0 || length > n-i:
+				// More data is needed
+				length = 0
+				junk = false
+			case length == 0:
+				// The length is determined by the CRC only
+				for end := i + rtuMinSize; end <= n; end++ {
+					if rtuChecksumValid(data[i:end]) {
+						length = end - i
+						break
+					}
+				}
+				if length == 0 {
+					junk = false
+				}
+			case !rtuChecksumValid(data[i : i+length]):
+				corrupted = append([]byte(nil), data[i:i+length]...)
+				length = 0
+			}
+			if length > 0 {
+				if i > 0 {
+					mb.serialPort.log(context.Background(), LogLevelWarn, "modbus: discarded", LogField{"adu", hexBytes(data[:i])})
 				}
+				aduResponse = append([]byte(nil), data[i:i+length]...)
+				return
+			}
+			if junk {
+				start = i + 1
 			}
 		}
-	} else if data[1] == functionFail {
-		//for error we need to read 5 bytes
-		if n < rtuExceptionSize {
-			n1, err = io.ReadFull(mb.port, data[n:rtuExceptionSize])
+		if err != nil {
+			if n > 0 {
+				mb.serialPort.log(context.Background(), LogLevelWarn, "modbus: discarded", LogField{"adu", hexBytes(data[:n])})
+			}
+			if corrupted != nil {
+				aduResponse, err = corrupted, nil
+			}
+			return
 		}
-		n += n1
 	}
+}
 
-	if err != nil {
+// receiveFrame reads frames delimited by silent intervals until it finds
+// one which starts with the slave id and function code of aduRequest.
+// Frames of other slaves or masters are discarded.
+func (mb *rtuSerialTransporter) receiveFrame(aduRequest []byte) (aduResponse []byte, err error) {
+	port, ok := mb.port.(deadlineSetter)
+	if !ok {
+		err = fmt.Errorf("modbus: silent interval framing requires a port with read deadlines")
 		return
 	}
-	aduResponse = data[:n]
-	mb.serialPort.logf("modbus: received % x\n", aduResponse)
-	return
+	var deadline time.Time
+	if mb.Timeout > 0 {
+		deadline = time.Now().Add(mb.Timeout)
+	}
+	t15, t35 := rtuTimings(mb.BaudRate)
+	t15 += mb.FramingSlack
+	t35 += mb.FramingSlack
+	characterTime := characterTime(mb.BaudRate)
+
+	var data [rtuMaxSize]byte
+	for {
+		// Wait for the first character until Timeout
+		if err = port.SetReadDeadline(deadline); err != nil {
+			return
+		}
+		var n, n1 int
+		if n, err = mb.port.Read(data[:]); err != nil {
+			return
+		}
+		last := time.Now()
+		var gap time.Duration
+		// Read until the silent interval
+		for n < len(data) {
+			if err = port.SetReadDeadline(time.Now().Add(t35)); err != nil {
+				return
+			}
+			n1, err = mb.port.Read(data[n:])
+			if n1 > 0 {
+				now := time.Now()
+				// The last character received arrived just before now
+				if d := now.Sub(last) - time.Duration(n1)*characterTime; d > gap {
+					gap = d
+				}
+				last = now
+				n += n1
+			}
+			if err != nil {
+				if !isTimeout(err) {
+					return
+				}
+				err = nil
+				break
+			}
+		}
+		if n < 2 || !rtuResponseStart(aduRequest, data[:n]) {
+			mb.serialPort.log(context.Background(), LogLevelWarn, "modbus: discarded", LogField{"adu", hexBytes(data[:n])})
+			continue
+		}
+		aduResponse = append([]byte(nil), data[:n]...)
+		if gap > t15 {
+			err = newFrameError(ErrInvalidFrame, aduRequest, aduResponse,
+				"modbus: gap '%v' between characters exceeds '%v'", gap, t15)
+		}
+		return
+	}
+}
+
+// rtuTimings returns the maximum gap between the characters of a frame
+// (t1.5) and the silent interval between frames (t3.5) at baudRate. See
+// MODBUS over Serial Line - Specification and Implementation Guide (2.5.1.1).
+func rtuTimings(baudRate int) (t15, t35 time.Duration) {
+	if baudRate > 19200 {
+		return 750 * time.Microsecond, 1750 * time.Microsecond
+	}
+	characterTime := characterTime(baudRate)
+	return characterTime * 3 / 2, characterTime * 7 / 2
+}
+
+// rtuResponseStart reports whether the response to aduRequest may start
+// at the beginning of adu.
+func rtuResponseStart(aduRequest, adu []byte) bool {
+	if adu[0] != aduRequest[0] {
+		return false
+	}
+	return len(adu) < 2 || adu[1] == aduRequest[1] || adu[1] == aduRequest[1]|0x80
+}
+
+// rtuResponseLength returns the length of the response to aduRequest
+// starting at the beginning of adu, 0 if the length is only determined
+// by the CRC and -1 if more data is needed.
+func rtuResponseLength(aduRequest, adu []byte) int {
+	if len(adu) < 2 {
+		return -1
+	}
+	if adu[1]&0x80 != 0 {
+		return rtuExceptionSize
+	}
+	switch aduRequest[1] {
+	case FuncCodeReadDiscreteInputs,
+		FuncCodeReadCoils,
+		FuncCodeReadInputRegisters,
+		FuncCodeReadHoldingRegisters,
+		FuncCodeReadWriteMultipleRegisters,
+		FuncCodeWriteSingleCoil,
+		FuncCodeWriteMultipleCoils,
+		FuncCodeWriteSingleRegister,
+		FuncCodeWriteMultipleRegisters,
+		FuncCodeMaskWriteRegister:
+		return calculateResponseLength(aduRequest)
+	case FuncCodeReadFIFOQueue:
+		if len(adu) < 4 {
+			return -1
+		}
+		// Slave id, function code, byte count, data and CRC
+		return 6 + int(binary.BigEndian.Uint16(adu[2:]))
+	case FuncCodeReportSlaveId:
+		if len(adu) < 3 {
+			return -1
+		}
+		// Slave id, function code, byte count, data and CRC
+		return 5 + int(adu[2])
+	}
+	return 0
+}
+
+// rtuChecksumValid reports whether adu ends with its CRC.
+func rtuChecksumValid(adu []byte) bool {
+	length := len(adu)
+	if length < rtuMinSize {
+		return false
+	}
+	var crc crc
+	crc.reset().pushBytes(adu[0 : length-2])
+	return uint16(adu[length-1])<<8|uint16(adu[length-2]) == crc.value()
 }
 
 // calculateDelay roughly calculates time needed for the next frame.
@@ -6,7 +6,13 @@ package modbus
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"net"
 	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
 )
 
 func TestRTUEncoding(t *testing.T) {
@@ -27,6 +33,25 @@ func TestRTUEncoding(t *testing.T) {
 	}
 }
 
+func TestRTUBroadcastRead(t *testing.T) {
+	encoder := rtuPackager{}
+	_, err := encoder.Encode(&ProtocolDataUnit{FunctionCode: FuncCodeReadHoldingRegisters, Data: []byte{0, 0, 0, 1}})
+	var validationError *ValidationError
+	if !errors.As(err, &validationError) {
+		t.Fatalf("expected validation error, actual %v", err)
+	}
+	if _, err = encoder.Encode(&ProtocolDataUnit{FunctionCode: FuncCodeWriteSingleRegister, Data: []byte{0, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = encoder.Encode(&ProtocolDataUnit{FunctionCode: FuncCodeReadWriteMultipleRegisters, Data: []byte{0, 0, 0, 1, 0, 0, 0, 1, 2, 0, 1}}); !errors.As(err, &validationError) {
+		t.Fatalf("expected validation error, actual %v", err)
+	}
+	asciiEncoder := asciiPackager{}
+	if _, err = asciiEncoder.Encode(&ProtocolDataUnit{FunctionCode: FuncCodeReadCoils, Data: []byte{0, 0, 0, 1}}); !errors.As(err, &validationError) {
+		t.Fatalf("expected validation error, actual %v", err)
+	}
+}
+
 func TestRTUDecoding(t *testing.T) {
 	decoder := rtuPackager{}
 	adu := []byte{0x01, 0x10, 0x8A, 0x00, 0x00, 0x03, 0xAA, 0x10}
@@ -96,3 +121,203 @@ func BenchmarkRTUDecoder(b *testing.B) {
 		}
 	}
 }
+
+// busPort is a serial port whose input is queued by the test. The
+// response is queued once the request is written.
+type busPort struct {
+	input    bytes.Buffer
+	response []byte
+	flushed  []byte
+}
+
+func (p *busPort) Read(b []byte) (int, error) {
+	if p.input.Len() == 0 {
+		return 0, serial.ErrTimeout
+	}
+	return p.input.Read(b)
+}
+
+func (p *busPort) Write(b []byte) (int, error) {
+	p.input.Write(p.response)
+	return len(b), nil
+}
+
+func (p *busPort) ResetInputBuffer() error {
+	p.flushed = append(p.flushed, p.input.Bytes()...)
+	p.input.Reset()
+	return nil
+}
+
+func (p *busPort) Close() error {
+	return nil
+}
+
+func TestRTUResynchronization(t *testing.T) {
+	port := &busPort{}
+	// Late response to a previous request, flushed before sending
+	port.input.Write([]byte{0x11, 0x03, 0x02, 0x00, 0x01})
+	// Noise, a frame of another slave, a corrupted frame and the response
+	port.response = []byte{
+		0x00, 0xFF, 0x11,
+		0x12, 0x03, 0x02, 0x00, 0x00, 0xB9, 0x84,
+		0x11, 0x03, 0x02, 0x02, 0x2B, 0x38, 0xF9,
+		0x11, 0x03, 0x02, 0x02, 0x2B, 0x38, 0xF8,
+	}
+	handler := NewRTUClientHandlerWithPort(func() (io.ReadWriteCloser, error) {
+		return port, nil
+	})
+	handler.SlaveId = 0x11
+	defer handler.Close()
+
+	results, err := NewClient(handler).ReadHoldingRegisters(0x006B, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x02, 0x2B}; !bytes.Equal(expected, results) {
+		t.Fatalf("results: expected % x, actual % x", expected, results)
+	}
+	if expected := []byte{0x11, 0x03, 0x02, 0x00, 0x01}; !bytes.Equal(expected, port.flushed) {
+		t.Fatalf("flushed: expected % x, actual % x", expected, port.flushed)
+	}
+}
+
+func TestRTUResynchronizationCRCMismatch(t *testing.T) {
+	port := &busPort{
+		response: []byte{0xFF, 0x11, 0x03, 0x02, 0x02, 0x2B, 0x38, 0xF9},
+	}
+	handler := NewRTUClientHandlerWithPort(func() (io.ReadWriteCloser, error) {
+		return port, nil
+	})
+	handler.SlaveId = 0x11
+	defer handler.Close()
+
+	_, err := NewClient(handler).ReadHoldingRegisters(0x006B, 1)
+	if !errors.Is(err, ErrCRCMismatch) {
+		t.Fatalf("expected crc mismatch, actual %v", err)
+	}
+}
+
+func TestRTUTimings(t *testing.T) {
+	if t15, t35 := rtuTimings(9600); t15 != 1717500*time.Nanosecond || t35 != 4007500*time.Nanosecond {
+		t.Fatalf("9600: unexpected timings %v, %v", t15, t35)
+	}
+	if t15, t35 := rtuTimings(115200); t15 != 750*time.Microsecond || t35 != 1750*time.Microsecond {
+		t.Fatalf("115200: unexpected timings %v, %v", t15, t35)
+	}
+}
+
+func TestRTUSilentIntervalFraming(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		var request [8]byte
+		if _, err := io.ReadFull(server, request[:]); err != nil {
+			t.Error(err)
+			return
+		}
+		// A frame of another slave, then the response in two parts
+		server.Write([]byte{0x12, 0x03, 0x02, 0x00, 0x00})
+		time.Sleep(200 * time.Millisecond)
+		server.Write([]byte{0x11, 0x03, 0x02})
+		time.Sleep(time.Millisecond)
+		server.Write([]byte{0x02, 0x2B, 0x38, 0xF8})
+	}()
+
+	handler := NewRTUClientHandlerWithPort(func() (io.ReadWriteCloser, error) {
+		return client, nil
+	})
+	handler.SlaveId = 0x11
+	handler.BaudRate = 300
+	handler.Timeout = time.Second
+	handler.SilentIntervalFraming = true
+	defer handler.Close()
+
+	results, err := NewClient(handler).ReadHoldingRegisters(0x006B, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x02, 0x2B}; !bytes.Equal(expected, results) {
+		t.Fatalf("results: expected % x, actual % x", expected, results)
+	}
+}
+
+func TestRTUSilentIntervalFramingGap(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		var request [8]byte
+		if _, err := io.ReadFull(server, request[:]); err != nil {
+			t.Error(err)
+			return
+		}
+		// A gap longer than t1.5 (55ms) but shorter than t3.5 (128ms)
+		server.Write([]byte{0x11, 0x03, 0x02})
+		time.Sleep(105 * time.Millisecond)
+		server.Write([]byte{0x02})
+		server.Write([]byte{0x2B, 0x38, 0xF8})
+	}()
+
+	handler := NewRTUClientHandlerWithPort(func() (io.ReadWriteCloser, error) {
+		return client, nil
+	})
+	handler.SlaveId = 0x11
+	handler.BaudRate = 300
+	handler.Timeout = time.Second
+	handler.SilentIntervalFraming = true
+	defer handler.Close()
+
+	_, err := NewClient(handler).ReadHoldingRegisters(0x006B, 1)
+	if !errors.Is(err, ErrInvalidFrame) {
+		t.Fatalf("expected invalid frame, actual %v", err)
+	}
+}
+
+// emptyReadConn is a net.Conn whose second read, the first one after
+// the flush, returns no data.
+type emptyReadConn struct {
+	net.Conn
+
+	reads int
+}
+
+func (c *emptyReadConn) Read(b []byte) (int, error) {
+	if c.reads++; c.reads == 2 {
+		return 0, nil
+	}
+	return c.Conn.Read(b)
+}
+
+func TestRTUSilentIntervalFramingEmptyRead(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		var request [8]byte
+		if _, err := io.ReadFull(server, request[:]); err != nil {
+			t.Error(err)
+			return
+		}
+		// Answer after the silent interval following the empty read
+		time.Sleep(200 * time.Millisecond)
+		server.Write([]byte{0x11, 0x03, 0x02, 0x02, 0x2B, 0x38, 0xF8})
+	}()
+
+	handler := NewRTUClientHandlerWithPort(func() (io.ReadWriteCloser, error) {
+		return &emptyReadConn{Conn: client}, nil
+	})
+	handler.SlaveId = 0x11
+	handler.BaudRate = 300
+	handler.Timeout = time.Second
+	handler.SilentIntervalFraming = true
+	defer handler.Close()
+
+	results, err := NewClient(handler).ReadHoldingRegisters(0x006B, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x02, 0x2B}; !bytes.Equal(expected, results) {
+		t.Fatalf("results: expected % x, actual % x", expected, results)
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// Default scan range and probe timeout
+	scanFirstSlaveId = 1
+	scanLastSlaveId  = 247
+	scanTimeout      = 250 * time.Millisecond
+)
+
+// scanProbes are the requests probing the support of functions. They do
+// not change the state of the device: reads, diagnostics echoing the
+// request and writes of no data, which a device supporting the function
+// rejects with an illegal data value. Functions 5, 6 and 22 cannot be
+// probed without writing.
+var scanProbes = map[byte][]byte{
+	FuncCodeReadCoils:                  {0, 0, 0, 1},
+	FuncCodeReadDiscreteInputs:         {0, 0, 0, 1},
+	FuncCodeReadHoldingRegisters:       {0, 0, 0, 1},
+	FuncCodeReadInputRegisters:         {0, 0, 0, 1},
+	FuncCodeReadExceptionStatus:        {},
+	FuncCodeDiagnostics:                {0, 0, 0xA5, 0x5A}, // Return query data
+	FuncCodeGetCommEventCounter:        {},
+	FuncCodeGetCommEventLog:            {},
+	FuncCodeWriteMultipleCoils:         {0, 0, 0, 0, 0},
+	FuncCodeWriteMultipleRegisters:     {0, 0, 0, 0, 0},
+	FuncCodeReportSlaveId:              {},
+	FuncCodeReadWriteMultipleRegisters: {0, 0, 0, 0, 0, 0, 0, 0, 0},
+	FuncCodeReadFIFOQueue:              {0, 0},
+	FuncCodeReadDeviceIdentification:   {0x0E, 0x01, 0x00},
+}
+
+// ScanResult describes a slave which answered a scan.
+type ScanResult struct {
+	SlaveId byte
+	// DeviceId is the basic device identification, nil if the slave does
+	// not support function 43.
+	DeviceId *BasicDeviceID
+	// ReportSlaveId is the response to function 17 following the byte
+	// count, nil if the slave does not support it.
+	ReportSlaveId []byte
+	// Functions tells for the probed function codes whether the slave
+	// supports them, that is does not answer with an illegal function
+	// exception. Functions without a conclusive answer are missing.
+	Functions map[byte]bool
+}
+
+// Unsupported returns the function codes answered with an illegal
+// function exception, in ascending order.
+func (r *ScanResult) Unsupported() (functionCodes []byte) {
+	for functionCode, supported := range r.Functions {
+		if !supported {
+			functionCodes = append(functionCodes, functionCode)
+		}
+	}
+	sort.Slice(functionCodes, func(i, j int) bool { return functionCodes[i] < functionCodes[j] })
+	return
+}
+
+// Scanner discovers the slaves behind a serial or TCP handler and the
+// functions they support.
+//
+// Each slave id is probed with a read device identification (function
+// 43), a report slave id (function 17) and, if neither is answered, a read
+// of holding register 0. A slave answering none of them within Timeout is
+// considered absent, so that scanning an empty id takes three times
+// Timeout. Each slave found is then probed with the requests of
+// Functions which it has not answered yet.
+//
+// Timeout replaces the Timeout of the handlers of this package during the
+// scan, so that probes time out without closing their port or
+// connection. Late responses to probes are discarded before the next
+// probe. The probes of other handlers are canceled after Timeout.
+type Scanner struct {
+	// Handler sends the probes, its slave id is ignored. The handlers of
+	// this package and the handlers wrapping them are supported.
+	Handler ClientHandler
+	// Timeout of each probe.
+	Timeout time.Duration
+	// FirstSlaveId and LastSlaveId limit the ids scanned, default to 1
+	// and 247.
+	FirstSlaveId byte
+	LastSlaveId  byte
+	// Functions probed on every slave found, default to all functions
+	// which can be probed without changing the state of the device.
+	Functions []byte
+	// OnResult, if set, is called with each slave found during the scan.
+	OnResult func(ScanResult)
+}
+
+// NewScanner allocates a Scanner over handler with the default timeout
+// and range.
+func NewScanner(handler ClientHandler) *Scanner {
+	return &Scanner{
+		Handler:      handler,
+		Timeout:      scanTimeout,
+		FirstSlaveId: scanFirstSlaveId,
+		LastSlaveId:  scanLastSlaveId,
+	}
+}
+
+// Scan scans the slaves behind handler with the defaults of NewScanner.
+func Scan(ctx context.Context, handler ClientHandler) ([]ScanResult, error) {
+	return NewScanner(handler).Scan(ctx)
+}
+
+// Scan probes the slave ids in order and returns the slaves found. It
+// stops with ctx.Err() when ctx is done.
+func (s *Scanner) Scan(ctx context.Context) (results []ScanResult, err error) {
+	first, last := s.FirstSlaveId, s.LastSlaveId
+	if first == 0 {
+		first = scanFirstSlaveId
+	}
+	if last == 0 {
+		last = scanLastSlaveId
+	}
+	timeout := s.Timeout
+	if timeout > 0 {
+		if previous, ok := setHandlerTimeout(s.Handler, timeout); ok {
+			defer setHandlerTimeout(s.Handler, previous)
+			timeout = 0
+		}
+	}
+	for id := int(first); id <= int(last); id++ {
+		packager, ok := packagerWithSlaveId(s.Handler, byte(id))
+		if !ok {
+			err = fmt.Errorf("modbus: handler '%T' does not support scanning", s.Handler)
+			return
+		}
+		mb := &client{packager: packager, transporter: s.Handler}
+		mb.roundTripper = RoundTripperFunc(mb.roundTrip)
+
+		var result *ScanResult
+		if result, err = s.probe(ctx, mb, byte(id), timeout); err != nil {
+			return
+		}
+		if result == nil {
+			continue
+		}
+		results = append(results, *result)
+		if s.OnResult != nil {
+			s.OnResult(*result)
+		}
+	}
+	return
+}
+
+// probe probes one slave id and returns nil if no slave answers. Probes
+// are canceled after timeout, if not zero.
+func (s *Scanner) probe(ctx context.Context, mb *client, slaveId byte, timeout time.Duration) (result *ScanResult, err error) {
+	found := &ScanResult{SlaveId: slaveId, Functions: make(map[byte]bool)}
+	// send sends one probe and records its answer
+	send := func(functionCode byte, request func(ctx context.Context) error) (answered bool, err error) {
+		probeCtx, cancel := probeContext(ctx, timeout)
+		e := request(probeCtx)
+		cancel()
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		var supported bool
+		if supported, answered = scanAnswer(e); answered {
+			found.Functions[functionCode] = supported
+		}
+		return
+	}
+
+	identified, err := send(FuncCodeReadDeviceIdentification, func(ctx context.Context) (e error) {
+		var deviceId BasicDeviceID
+		if deviceId, e = mb.ReadDeviceIdentificationBasicContext(ctx); e == nil {
+			found.DeviceId = &deviceId
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	reported, err := send(FuncCodeReportSlaveId, func(ctx context.Context) (e error) {
+		var report []byte
+		if report, e = mb.reportSlaveId(ctx); e == nil {
+			found.ReportSlaveId = report
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	if !identified && !reported {
+		var read bool
+		read, err = send(FuncCodeReadHoldingRegisters, func(ctx context.Context) (e error) {
+			_, e = mb.ReadHoldingRegistersContext(ctx, 0, 1)
+			return
+		})
+		if err != nil || !read {
+			return
+		}
+	}
+
+	for _, functionCode := range s.functions() {
+		if _, probed := found.Functions[functionCode]; probed {
+			continue
+		}
+		data, ok := scanProbes[functionCode]
+		if !ok {
+			continue
+		}
+		request := &ProtocolDataUnit{FunctionCode: functionCode, Data: data}
+		if _, err = send(functionCode, func(ctx context.Context) (e error) {
+			_, e = mb.roundTrip(ctx, request)
+			return
+		}); err != nil {
+			return
+		}
+	}
+	result = found
+	return
+}
+
+// probeContext returns the context of one probe.
+func probeContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// functions returns Functions or all functions which can be probed.
+func (s *Scanner) functions() []byte {
+	if s.Functions != nil {
+		return s.Functions
+	}
+	functionCodes := make([]byte, 0, len(scanProbes))
+	for functionCode := range scanProbes {
+		functionCodes = append(functionCodes, functionCode)
+	}
+	sort.Slice(functionCodes, func(i, j int) bool { return functionCodes[i] < functionCodes[j] })
+	return functionCodes
+}
+
+// scanAnswer tells from the result of a probe whether the slave answered
+// and supports the function. Exceptions of a gateway report a missing
+// slave, not an answer.
+func scanAnswer(err error) (supported bool, answered bool) {
+	if err == nil {
+		return true, true
+	}
+	var modbusError *ModbusError
+	if !errors.As(err, &modbusError) {
+		return false, false
+	}
+	switch modbusError.ExceptionCode {
+	case ExceptionCodeGatewayPathUnavailable, ExceptionCodeGatewayTargetDeviceFailedToRespond:
+		return false, false
+	case ExceptionCodeIllegalFunction:
+		return false, true
+	}
+	return true, true
+}
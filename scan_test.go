@@ -0,0 +1,216 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testNetwork dispatches TCP requests to its devices by unit id. Requests
+// to missing devices time out.
+type testNetwork struct {
+	tcpPackager
+
+	devices map[byte]*testDevice
+	// reports are the answers to report slave id
+	reports map[byte][]byte
+}
+
+func (n *testNetwork) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	device, ok := n.devices[aduRequest[6]]
+	if !ok {
+		return nil, tcpTimeoutError{}
+	}
+	switch functionCode := aduRequest[tcpHeaderSize]; functionCode {
+	case FuncCodeReadCoils, FuncCodeReadDiscreteInputs,
+		FuncCodeReadHoldingRegisters, FuncCodeReadInputRegisters,
+		FuncCodeWriteMultipleCoils, FuncCodeWriteMultipleRegisters:
+		return device.Send(aduRequest)
+	case FuncCodeReportSlaveId:
+		if report, ok := n.reports[aduRequest[6]]; ok {
+			aduResponse = append(aduResponse, aduRequest[:tcpHeaderSize]...)
+			aduResponse[5] = byte(3 + len(report))
+			aduResponse = append(aduResponse, functionCode, byte(len(report)))
+			return append(aduResponse, report...), nil
+		}
+		fallthrough
+	default:
+		aduResponse = append(aduResponse, aduRequest[:tcpHeaderSize]...)
+		aduResponse[5] = 3
+		return append(aduResponse, functionCode|0x80, ExceptionCodeIllegalFunction), nil
+	}
+}
+
+func TestScan(t *testing.T) {
+	network := &testNetwork{
+		devices: map[byte]*testDevice{3: {}, 7: {}},
+		reports: map[byte][]byte{7: {0x2A, 0xFF, 'v', '1'}},
+	}
+	scanner := NewScanner(network)
+	scanner.LastSlaveId = 10
+	var found []byte
+	scanner.OnResult = func(result ScanResult) {
+		found = append(found, result.SlaveId)
+	}
+	results, err := scanner.Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]byte{3, 7}, found) || len(results) != 2 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	expected := map[byte]bool{
+		FuncCodeReadCoils: true, FuncCodeReadDiscreteInputs: true,
+		FuncCodeReadHoldingRegisters: true, FuncCodeReadInputRegisters: true,
+		FuncCodeReadExceptionStatus: false, FuncCodeDiagnostics: false,
+		FuncCodeGetCommEventCounter: false, FuncCodeGetCommEventLog: false,
+		FuncCodeWriteMultipleCoils: true, FuncCodeWriteMultipleRegisters: true,
+		FuncCodeReportSlaveId: false, FuncCodeReadWriteMultipleRegisters: false,
+		FuncCodeReadFIFOQueue: false, FuncCodeReadDeviceIdentification: false,
+	}
+	if !reflect.DeepEqual(expected, results[0].Functions) {
+		t.Fatalf("functions: expected %v, actual %v", expected, results[0].Functions)
+	}
+	if results[0].DeviceId != nil || results[0].ReportSlaveId != nil {
+		t.Fatalf("unexpected identification %+v", results[0])
+	}
+	expected[FuncCodeReportSlaveId] = true
+	if !reflect.DeepEqual(expected, results[1].Functions) {
+		t.Fatalf("functions: expected %v, actual %v", expected, results[1].Functions)
+	}
+	if !reflect.DeepEqual([]byte{0x2A, 0xFF, 'v', '1'}, results[1].ReportSlaveId) {
+		t.Fatalf("report slave id: unexpected % x", results[1].ReportSlaveId)
+	}
+	unsupported := []byte{7, 8, 11, 12, 23, 24, 43}
+	if !reflect.DeepEqual(unsupported, results[1].Unsupported()) {
+		t.Fatalf("unsupported: expected %v, actual %v", unsupported, results[1].Unsupported())
+	}
+	if network.devices[3].requests == 0 {
+		t.Fatal("device 3 was not probed")
+	}
+}
+
+func TestScanWrappedHandler(t *testing.T) {
+	network := &testNetwork{devices: map[byte]*testDevice{2: {}}}
+	scanner := NewScanner(NewRetryHandler(network))
+	scanner.FirstSlaveId, scanner.LastSlaveId = 2, 2
+	results, err := scanner.Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].SlaveId != 2 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	scanner.Handler = struct{ ClientHandler }{network}
+	if _, err = scanner.Scan(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestScanCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Scan(ctx, &testNetwork{})
+	if err != context.Canceled {
+		t.Fatalf("expected canceled, actual %v", err)
+	}
+}
+
+func TestScanSerialTimeout(t *testing.T) {
+	var opens int
+	handler := NewRTUClientHandlerWithPort(func() (io.ReadWriteCloser, error) {
+		opens++
+		client, server := net.Pipe()
+		go io.Copy(ioutil.Discard, server)
+		return client, nil
+	})
+	defer handler.Close()
+	scanner := NewScanner(handler)
+	scanner.Timeout = 20 * time.Millisecond
+	scanner.LastSlaveId = 3
+
+	start := time.Now()
+	results, err := scanner.Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 || time.Since(start) > time.Second {
+		t.Fatalf("unexpected results %+v after %v", results, time.Since(start))
+	}
+	// Probes time out without closing the port
+	if opens != 1 || handler.Timeout != serialTimeout {
+		t.Fatalf("port opened %v times, timeout %v", opens, handler.Timeout)
+	}
+}
+
+func TestScanTCPLateResponse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var mu sync.Mutex
+		for {
+			var header [tcpHeaderSize]byte
+			if _, err := io.ReadFull(conn, header[:]); err != nil {
+				return
+			}
+			pdu := make([]byte, binary.BigEndian.Uint16(header[4:])-1)
+			if _, err := io.ReadFull(conn, pdu); err != nil {
+				return
+			}
+			response := append(header[:], pdu[0]|0x80, ExceptionCodeIllegalFunction)
+			if pdu[0] == FuncCodeReadHoldingRegisters {
+				response = append(header[:], pdu[0], 2, 0, 0)
+			}
+			binary.BigEndian.PutUint16(response[4:], uint16(len(response)-tcpHeaderSize+1))
+			delay := time.Duration(0)
+			if header[6] == 1 {
+				// Slave 1 answers after the probes time out
+				delay = 30 * time.Millisecond
+			}
+			go func() {
+				time.Sleep(delay)
+				mu.Lock()
+				defer mu.Unlock()
+				conn.Write(response)
+			}()
+		}
+	}()
+
+	handler := NewTCPClientHandler(ln.Addr().String())
+	defer handler.Close()
+	scanner := NewScanner(handler)
+	scanner.Timeout = 20 * time.Millisecond
+	scanner.LastSlaveId = 2
+	scanner.Functions = []byte{FuncCodeReadCoils}
+
+	results, err := scanner.Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].SlaveId != 2 || len(results[0].Functions) != 3 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if handler.Timeout != tcpTimeout {
+		t.Fatalf("timeout: expected %v, actual %v", tcpTimeout, handler.Timeout)
+	}
+}
@@ -5,8 +5,11 @@
 package modbus
 
 import (
+	"bytes"
+	"context"
 	"io"
 	"log"
+	"os"
 	"sync"
 	"time"
 
@@ -19,19 +22,47 @@ const (
 	serialIdleTimeout = 60 * time.Second
 )
 
+// PortFactory opens the byte stream of a serial-like link, e.g. a PTY,
+// a USB CDC device opened by another library, a Bluetooth RFCOMM socket
+// or an in-memory pipe.
+type PortFactory func() (io.ReadWriteCloser, error)
+
 // serialPort has configuration and I/O controller.
 type serialPort struct {
 	// Serial port configuration.
 	serial.Config
 
-	Logger      *log.Logger
-	IdleTimeout time.Duration
+	// Transmission logger
+	Logger *log.Logger
+	// LeveledLogger, if set, receives the transmission log entries with
+	// structured fields instead of Logger
+	LeveledLogger LeveledLogger
+	IdleTimeout   time.Duration
+	// PortFactory, if set, is used instead of opening Address as a serial
+	// device. Timeout is only enforced, and requests only interrupted when
+	// their context is done, if the returned port implements
+	// SetReadDeadline.
+	PortFactory PortFactory
+
+	// RS-485 half-duplex options. The kernel RS485 mode is configured with
+	// Config.RS485 and applied when the serial device is opened. Ports
+	// returned by PortFactory which implement SetRTS have RTS toggled in
+	// software using the same settings instead.
+
+	// LocalEcho discards the copy of the request that adapters without
+	// receiver disable read back from the bus.
+	LocalEcho bool
+	// TurnaroundDelay is the time to wait after a broadcast request
+	// before the bus is used again.
+	TurnaroundDelay time.Duration
 
 	mu sync.Mutex
 	// port is platform-dependent data structure for serial port.
 	port         io.ReadWriteCloser
 	lastActivity time.Time
 	closeTimer   *time.Timer
+	// Number of times the port has been opened
+	connects int
 }
 
 func (mb *serialPort) Connect() (err error) {
@@ -44,15 +75,264 @@ func (mb *serialPort) Connect() (err error) {
 // connect connects to the serial port if it is not connected. Caller must hold the mutex.
 func (mb *serialPort) connect() error {
 	if mb.port == nil {
-		port, err := serial.Open(&mb.Config)
+		port, err := mb.open()
 		if err != nil {
 			return err
 		}
 		mb.port = port
+		mb.connects++
+	}
+	return nil
+}
+
+// open opens the port using PortFactory or, by default, the serial device.
+func (mb *serialPort) open() (io.ReadWriteCloser, error) {
+	if mb.PortFactory != nil {
+		return mb.PortFactory()
+	}
+	// The device times out after t3.5, Timeout is enforced with deadlines
+	config := mb.Config
+	_, config.Timeout = rtuTimings(mb.BaudRate)
+	port, err := serial.Open(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &serialDevice{Port: port}, nil
+}
+
+// serialDevice is a serial device opened by serial.Open with a timeout
+// of t3.5. Reads are repeated until data is received or the read
+// deadline has passed, so that they can be interrupted by setting the
+// deadline without closing the device while it is read.
+type serialDevice struct {
+	serial.Port
+
+	mu       sync.Mutex
+	deadline time.Time
+	// pending is the data received after the deadline, returned by the
+	// next read.
+	pending []byte
+}
+
+// SetReadDeadline sets the deadline of the reads, the zero value for
+// none. It may be called while the device is read.
+func (p *serialDevice) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deadline = t
+	return nil
+}
+
+// expired reports whether the read deadline has passed.
+func (p *serialDevice) expired() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.deadline.IsZero() && !time.Now().Before(p.deadline)
+}
+
+// Read reads the data received until the read deadline and fails with
+// serial.ErrTimeout once it has passed.
+func (p *serialDevice) Read(b []byte) (n int, err error) {
+	if len(p.pending) > 0 {
+		n = copy(b, p.pending)
+		p.pending = p.pending[n:]
+		return
+	}
+	for {
+		n, err = p.Port.Read(b)
+		expired := p.expired()
+		if n > 0 && expired {
+			p.pending = append(p.pending[:0], b[:n]...)
+			return 0, serial.ErrTimeout
+		}
+		if err != serial.ErrTimeout || expired {
+			return
+		}
+	}
+}
+
+// ResetInputBuffer discards the data received, reading the device until
+// it has been silent for t3.5.
+func (p *serialDevice) ResetInputBuffer() error {
+	p.pending = nil
+	var data [rtuMaxSize]byte
+	for {
+		if _, err := p.Port.Read(data[:]); err != nil {
+			if err == serial.ErrTimeout {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Write writes all of b. The write timeout of the device, if any, may
+// interrupt a write before all the data is sent.
+func (p *serialDevice) Write(b []byte) (n int, err error) {
+	for n < len(b) {
+		var n1 int
+		n1, err = p.Port.Write(b[n:])
+		n += n1
+		if err != nil {
+			return
+		}
+		if n1 == 0 {
+			err = io.ErrShortWrite
+			return
+		}
+	}
+	return
+}
+
+// rtsSetter is implemented by ports which allow controlling the RTS line.
+type rtsSetter interface {
+	SetRTS(high bool) error
+}
+
+// write sends the request, drives RTS when toggled in software and
+// removes the local echo if configured. Caller must hold the mutex.
+func (mb *serialPort) write(aduRequest []byte) (err error) {
+	rts, ok := mb.port.(rtsSetter)
+	if !ok || !mb.RS485.Enabled {
+		rts = nil
+	}
+	if rts != nil {
+		if err = rts.SetRTS(mb.RS485.RtsHighDuringSend); err != nil {
+			return
+		}
+		time.Sleep(mb.RS485.DelayRtsBeforeSend)
+	}
+	if _, err = mb.port.Write(aduRequest); err != nil {
+		return
+	}
+	if rts != nil {
+		// Wait until the last character has left the transmitter
+		time.Sleep(characterTime(mb.BaudRate)*time.Duration(len(aduRequest)) + mb.RS485.DelayRtsAfterSend)
+		if err = rts.SetRTS(mb.RS485.RtsHighAfterSend); err != nil {
+			return
+		}
+	}
+	if mb.LocalEcho {
+		echo := make([]byte, len(aduRequest))
+		if _, err = io.ReadFull(mb.port, echo); err != nil {
+			return
+		}
+		if !bytes.Equal(echo, aduRequest) {
+			err = newFrameError(ErrEchoMismatch, aduRequest, echo, "modbus: local echo '% x' does not match request '% x'", echo, aduRequest)
+			return
+		}
+	}
+	return
+}
+
+// watch interrupts a pending read on the port when ctx is done by
+// setting its read deadline. Ports opened by PortFactory without read
+// deadlines are not interrupted, ctx is only noticed once their read
+// returns. The returned function must be deferred by the caller with its
+// results: the port is closed and the error replaced by ctx.Err() if the
+// transaction was interrupted, so that the next request starts on a fresh
+// port.
+func (mb *serialPort) watch(ctx context.Context) func(aduResponse *[]byte, err *error) {
+	port, ok := mb.port.(deadlineSetter)
+	stop := watchContext(ctx, func() {
+		if ok {
+			port.SetReadDeadline(time.Now())
+		}
+	})
+	return func(aduResponse *[]byte, err *error) {
+		if interrupted := stop(); interrupted || (*err != nil && ctx.Err() != nil) {
+			mb.log(ctx, LogLevelInfo, "modbus: closing port", LogField{"error", ctx.Err().Error()})
+			mb.close()
+			if *err != nil {
+				*aduResponse, *err = nil, ctx.Err()
+			}
+		}
+	}
+}
+
+// turnaround waits TurnaroundDelay after a broadcast request.
+func (mb *serialPort) turnaround() {
+	mb.log(context.Background(), LogLevelDebug, "modbus: broadcast sent", LogField{"turnaround_delay", mb.TurnaroundDelay})
+	time.Sleep(mb.TurnaroundDelay)
+}
+
+// characterTime returns the time needed to transmit one character of 11 bits.
+func characterTime(baudRate int) time.Duration {
+	if baudRate <= 0 {
+		baudRate = 19200
+	}
+	return time.Duration(11*1000000/baudRate) * time.Microsecond
+}
+
+// deadlineSetter is implemented by ports which support read timeouts,
+// such as net.Conn and os.File.
+type deadlineSetter interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// setReadDeadline applies Timeout to the ports supporting read deadlines,
+// including the serial devices.
+func (mb *serialPort) setReadDeadline() error {
+	port, ok := mb.port.(deadlineSetter)
+	if !ok {
+		return nil
+	}
+	var deadline time.Time
+	if mb.Timeout > 0 {
+		deadline = time.Now().Add(mb.Timeout)
+	}
+	if err := port.SetReadDeadline(deadline); err != os.ErrNoDeadline {
+		return err
 	}
 	return nil
 }
 
+// inputResetter is implemented by ports which can discard the data
+// received but not read yet, such as the ports of go.bug.st/serial.
+type inputResetter interface {
+	ResetInputBuffer() error
+}
+
+// flush discards the data received before a request is sent, e.g. a late
+// response to a request which timed out. Serial devices and the ports
+// implementing ResetInputBuffer are flushed with it, the other ports
+// opened by PortFactory, when they support read deadlines, by reading
+// until no data is left; setReadDeadline must be called next. Caller must
+// hold the mutex.
+func (mb *serialPort) flush() error {
+	if port, ok := mb.port.(inputResetter); ok {
+		return port.ResetInputBuffer()
+	}
+	port, ok := mb.port.(deadlineSetter)
+	if !ok {
+		return nil
+	}
+	if err := port.SetReadDeadline(time.Now()); err != nil {
+		if err == os.ErrNoDeadline {
+			return nil
+		}
+		return err
+	}
+	var data [rtuMaxSize]byte
+	for {
+		n, err := mb.port.Read(data[:])
+		if n > 0 {
+			mb.log(context.Background(), LogLevelWarn, "modbus: discarded", LogField{"adu", hexBytes(data[:n])})
+		}
+		if n == 0 || err != nil {
+			return nil
+		}
+	}
+}
+
+func (mb *serialPort) setTimeout(timeout time.Duration) (previous time.Duration, ok bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	previous, mb.Timeout = mb.Timeout, timeout
+	return previous, true
+}
+
 func (mb *serialPort) Close() (err error) {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
@@ -69,10 +349,14 @@ func (mb *serialPort) close() (err error) {
 	return
 }
 
-func (mb *serialPort) logf(format string, v ...interface{}) {
-	if mb.Logger != nil {
-		mb.Logger.Printf(format, v...)
+// log writes an entry with the address of the port to LeveledLogger or
+// Logger.
+func (mb *serialPort) log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	if mb.LeveledLogger == nil && mb.Logger == nil {
+		return
 	}
+	fields = append([]LogField{{"address", mb.Address}}, fields...)
+	transportLog(mb.LeveledLogger, mb.Logger, ctx, level, msg, fields...)
 }
 
 func (mb *serialPort) startCloseTimer() {
@@ -96,7 +380,7 @@ func (mb *serialPort) closeIdle() {
 	}
 	idle := time.Now().Sub(mb.lastActivity)
 	if idle >= mb.IdleTimeout {
-		mb.logf("modbus: closing connection due to idle timeout: %v", idle)
+		mb.log(context.Background(), LogLevelInfo, "modbus: closing idle port", LogField{"idle", idle})
 		mb.close()
 	}
 }
@@ -0,0 +1,126 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+//go:build linux
+// +build linux
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPTY opens a pseudo terminal, returning its master side and the
+// name of its slave side to be opened as a serial device.
+func openPTY(t *testing.T) (master *os.File, name string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		master.Close()
+		t.Skip(errno)
+	}
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		t.Skip(errno)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestSerialDeviceContext(t *testing.T) {
+	master, name := openPTY(t)
+	defer master.Close()
+
+	handler := NewRTUClientHandler(name)
+	handler.SlaveId = 0x11
+	handler.Timeout = 10 * time.Second
+	defer handler.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewContextClient(NewClient(handler)).ReadHoldingRegistersContext(ctx, 0x006B, 1)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("request interrupted after %v", elapsed)
+	}
+}
+
+func TestSerialDeviceFlush(t *testing.T) {
+	master, name := openPTY(t)
+	defer master.Close()
+
+	handler := NewRTUClientHandler(name)
+	handler.SlaveId = 0x11
+	handler.Timeout = time.Second
+	if err := handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	// Late response to a previous request, flushed before sending
+	if _, err := master.Write([]byte{0x11, 0x03, 0x02, 0x00, 0x01, 0xB8, 0x47}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	go func() {
+		var request [8]byte
+		if _, err := io.ReadFull(master, request[:]); err != nil {
+			return
+		}
+		master.Write([]byte{0x11, 0x03, 0x02, 0x02, 0x2B, 0x38, 0xF8})
+	}()
+	results, err := NewClient(handler).ReadHoldingRegisters(0x006B, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x02, 0x2B}; !bytes.Equal(expected, results) {
+		t.Fatalf("results: expected % x, actual % x", expected, results)
+	}
+}
+
+func TestSerialDeviceSilentIntervalFraming(t *testing.T) {
+	master, name := openPTY(t)
+	defer master.Close()
+
+	go func() {
+		var request [8]byte
+		if _, err := io.ReadFull(master, request[:]); err != nil {
+			return
+		}
+		// A frame of another slave, then the response in two parts
+		master.Write([]byte{0x12, 0x03, 0x02, 0x00, 0x00})
+		time.Sleep(200 * time.Millisecond)
+		master.Write([]byte{0x11, 0x03, 0x02})
+		time.Sleep(time.Millisecond)
+		master.Write([]byte{0x02, 0x2B, 0x38, 0xF8})
+	}()
+
+	handler := NewRTUClientHandler(name)
+	handler.SlaveId = 0x11
+	handler.BaudRate = 300
+	handler.Timeout = time.Second
+	handler.SilentIntervalFraming = true
+	defer handler.Close()
+
+	results, err := NewClient(handler).ReadHoldingRegisters(0x006B, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x02, 0x2B}; !bytes.Equal(expected, results) {
+		t.Fatalf("results: expected % x, actual % x", expected, results)
+	}
+}
@@ -2,7 +2,10 @@ package modbus
 
 import (
 	"bytes"
+	"context"
 	"io"
+	"io/ioutil"
+	"net"
 	"testing"
 	"time"
 )
@@ -34,3 +37,109 @@ func TestSerialCloseIdle(t *testing.T) {
 		t.Fatalf("serial port is not closed when inactivity: %+v", port)
 	}
 }
+
+func TestSerialPortFactory(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		var request [8]byte
+		if _, err := io.ReadFull(server, request[:]); err != nil {
+			t.Error(err)
+			return
+		}
+		// Read holding register 0x006B of slave 0x11 returns 0x022B.
+		server.Write([]byte{0x11, 0x03, 0x02, 0x02, 0x2B, 0x38, 0xF8})
+	}()
+
+	handler := NewRTUClientHandlerWithPort(func() (io.ReadWriteCloser, error) {
+		return client, nil
+	})
+	handler.SlaveId = 0x11
+	handler.Timeout = time.Second
+	defer handler.Close()
+
+	results, err := NewClient(handler).ReadHoldingRegisters(0x006B, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x02, 0x2B}; !bytes.Equal(expected, results) {
+		t.Fatalf("results: expected % x, actual % x", expected, results)
+	}
+}
+
+func TestSerialLocalEcho(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		var request [8]byte
+		if _, err := io.ReadFull(server, request[:]); err != nil {
+			t.Error(err)
+			return
+		}
+		// The adapter echoes the request before the slave answers.
+		server.Write(request[:])
+		server.Write([]byte{0x11, 0x03, 0x02, 0x02, 0x2B, 0x38, 0xF8})
+	}()
+
+	handler := NewRTUClientHandlerWithPort(func() (io.ReadWriteCloser, error) {
+		return client, nil
+	})
+	handler.SlaveId = 0x11
+	handler.Timeout = time.Second
+	handler.LocalEcho = true
+	defer handler.Close()
+
+	results, err := NewClient(handler).ReadHoldingRegisters(0x006B, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x02, 0x2B}; !bytes.Equal(expected, results) {
+		t.Fatalf("results: expected % x, actual % x", expected, results)
+	}
+}
+
+func TestSerialBroadcast(t *testing.T) {
+	port := &nopCloser{
+		ReadWriter: &bytes.Buffer{},
+	}
+	handler := NewRTUClientHandlerWithPort(func() (io.ReadWriteCloser, error) {
+		return port, nil
+	})
+	handler.TurnaroundDelay = 10 * time.Millisecond
+
+	client := NewClient(handler)
+	if _, err := client.WriteSingleRegister(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ReadHoldingRegisters(1, 2); err == nil {
+		t.Fatal("broadcast read is expected to fail")
+	}
+}
+
+func TestSerialContext(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		// Never answer
+		io.Copy(ioutil.Discard, server)
+	}()
+
+	handler := NewRTUClientHandlerWithPort(func() (io.ReadWriteCloser, error) {
+		return client, nil
+	})
+	handler.SlaveId = 0x11
+	handler.Timeout = 10 * time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := NewContextClient(NewClient(handler)).ReadHoldingRegistersContext(ctx, 0x006B, 1)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if handler.port != nil {
+		t.Fatalf("port is not closed after cancellation: %+v", handler.port)
+	}
+}
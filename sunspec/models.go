@@ -0,0 +1,534 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package sunspec
+
+// PointType is the SunSpec type of a point.
+type PointType int
+
+const (
+	TypeUint16 PointType = iota
+	TypeInt16
+	TypeAcc16
+	TypeEnum16
+	TypeBitfield16
+	TypeUint32
+	TypeInt32
+	TypeAcc32
+	TypeEnum32
+	TypeBitfield32
+	TypeUint64
+	TypeInt64
+	TypeAcc64
+	TypeFloat32
+	TypeSunssf
+	TypeString
+	TypePad
+)
+
+// size returns the number of registers of the type, strings excepted.
+func (t PointType) size() uint16 {
+	switch t {
+	case TypeUint32, TypeInt32, TypeAcc32, TypeEnum32, TypeBitfield32, TypeFloat32:
+		return 2
+	case TypeUint64, TypeInt64, TypeAcc64:
+		return 4
+	}
+	return 1
+}
+
+// pointDef defines a point of a model at its offset from the start of
+// its block.
+type pointDef struct {
+	name   string
+	offset uint16
+	typ    PointType
+	size   uint16
+	sf     string
+	units  string
+}
+
+// modelDef defines a model with a fixed block followed by repeating
+// blocks, if any.
+type modelDef struct {
+	name         string
+	fixed        []pointDef
+	repeating    []pointDef
+	repeatLength uint16
+}
+
+// fixedLength returns the length of the fixed block.
+func (m *modelDef) fixedLength() (length uint16) {
+	for _, p := range m.fixed {
+		if end := p.offset + p.size; end > length {
+			length = end
+		}
+	}
+	return
+}
+
+// layout lays out the points of a block one after another.
+type layout []pointDef
+
+// add appends a point of the given type, scaled by the scale factor
+// point sf if not empty.
+func (l layout) add(name string, typ PointType, sf, units string) layout {
+	return l.addSized(name, typ, typ.size(), sf, units)
+}
+
+func (l layout) addSized(name string, typ PointType, size uint16, sf, units string) layout {
+	var offset uint16
+	if n := len(l); n > 0 {
+		offset = l[n-1].offset + l[n-1].size
+	}
+	return append(l, pointDef{name: name, offset: offset, typ: typ, size: size, sf: sf, units: units})
+}
+
+func (l layout) str(name string, size uint16) layout {
+	return l.addSized(name, TypeString, size, "", "")
+}
+
+func (l layout) sf(name string) layout {
+	return l.add(name, TypeSunssf, "", "")
+}
+
+// models are the models decoded by ReadModel.
+var models = map[uint16]*modelDef{}
+
+func init() {
+	common := &modelDef{name: "common", fixed: layout{}.
+		str("Mn", 16).
+		str("Md", 16).
+		str("Opt", 8).
+		str("Vr", 8).
+		str("SN", 16).
+		add("DA", TypeUint16, "", "").
+		add("Pad", TypePad, "", "")}
+	models[1] = common
+
+	inverter := layout{}.
+		add("A", TypeUint16, "A_SF", "A").
+		add("AphA", TypeUint16, "A_SF", "A").
+		add("AphB", TypeUint16, "A_SF", "A").
+		add("AphC", TypeUint16, "A_SF", "A").
+		sf("A_SF").
+		add("PPVphAB", TypeUint16, "V_SF", "V").
+		add("PPVphBC", TypeUint16, "V_SF", "V").
+		add("PPVphCA", TypeUint16, "V_SF", "V").
+		add("PhVphA", TypeUint16, "V_SF", "V").
+		add("PhVphB", TypeUint16, "V_SF", "V").
+		add("PhVphC", TypeUint16, "V_SF", "V").
+		sf("V_SF").
+		add("W", TypeInt16, "W_SF", "W").
+		sf("W_SF").
+		add("Hz", TypeUint16, "Hz_SF", "Hz").
+		sf("Hz_SF").
+		add("VA", TypeInt16, "VA_SF", "VA").
+		sf("VA_SF").
+		add("VAr", TypeInt16, "VAr_SF", "var").
+		sf("VAr_SF").
+		add("PF", TypeInt16, "PF_SF", "Pct").
+		sf("PF_SF").
+		add("WH", TypeAcc32, "WH_SF", "Wh").
+		sf("WH_SF").
+		add("DCA", TypeUint16, "DCA_SF", "A").
+		sf("DCA_SF").
+		add("DCV", TypeUint16, "DCV_SF", "V").
+		sf("DCV_SF").
+		add("DCW", TypeInt16, "DCW_SF", "W").
+		sf("DCW_SF").
+		add("TmpCab", TypeInt16, "Tmp_SF", "C").
+		add("TmpSnk", TypeInt16, "Tmp_SF", "C").
+		add("TmpTrns", TypeInt16, "Tmp_SF", "C").
+		add("TmpOt", TypeInt16, "Tmp_SF", "C").
+		sf("Tmp_SF").
+		add("St", TypeEnum16, "", "").
+		add("StVnd", TypeEnum16, "", "").
+		add("Evt1", TypeBitfield32, "", "").
+		add("Evt2", TypeBitfield32, "", "").
+		add("EvtVnd1", TypeBitfield32, "", "").
+		add("EvtVnd2", TypeBitfield32, "", "").
+		add("EvtVnd3", TypeBitfield32, "", "").
+		add("EvtVnd4", TypeBitfield32, "", "")
+	models[101] = &modelDef{name: "inverter (single phase)", fixed: inverter}
+	models[102] = &modelDef{name: "inverter (split phase)", fixed: inverter}
+	models[103] = &modelDef{name: "inverter (three phase)", fixed: inverter}
+
+	models[120] = &modelDef{name: "nameplate", fixed: layout{}.
+		add("DERTyp", TypeEnum16, "", "").
+		add("WRtg", TypeUint16, "WRtg_SF", "W").
+		sf("WRtg_SF").
+		add("VARtg", TypeUint16, "VARtg_SF", "VA").
+		sf("VARtg_SF").
+		add("VArRtgQ1", TypeInt16, "VArRtg_SF", "var").
+		add("VArRtgQ2", TypeInt16, "VArRtg_SF", "var").
+		add("VArRtgQ3", TypeInt16, "VArRtg_SF", "var").
+		add("VArRtgQ4", TypeInt16, "VArRtg_SF", "var").
+		sf("VArRtg_SF").
+		add("ARtg", TypeUint16, "ARtg_SF", "A").
+		sf("ARtg_SF").
+		add("PFRtgQ1", TypeInt16, "PFRtg_SF", "cos()").
+		add("PFRtgQ2", TypeInt16, "PFRtg_SF", "cos()").
+		add("PFRtgQ3", TypeInt16, "PFRtg_SF", "cos()").
+		add("PFRtgQ4", TypeInt16, "PFRtg_SF", "cos()").
+		sf("PFRtg_SF").
+		add("WHRtg", TypeUint16, "WHRtg_SF", "Wh").
+		sf("WHRtg_SF").
+		add("AhrRtg", TypeUint16, "AhrRtg_SF", "AH").
+		sf("AhrRtg_SF").
+		add("MaxChaRte", TypeUint16, "MaxChaRte_SF", "W").
+		sf("MaxChaRte_SF").
+		add("MaxDisChaRte", TypeUint16, "MaxDisChaRte_SF", "W").
+		sf("MaxDisChaRte_SF").
+		add("Pad", TypePad, "", "")}
+
+	models[121] = &modelDef{name: "basic settings", fixed: layout{}.
+		add("WMax", TypeUint16, "WMax_SF", "W").
+		add("VRef", TypeUint16, "VRef_SF", "V").
+		add("VRefOfs", TypeInt16, "VRefOfs_SF", "V").
+		add("VMax", TypeUint16, "VMinMax_SF", "V").
+		add("VMin", TypeUint16, "VMinMax_SF", "V").
+		add("VAMax", TypeUint16, "VAMax_SF", "VA").
+		add("VArMaxQ1", TypeInt16, "VArMax_SF", "var").
+		add("VArMaxQ2", TypeInt16, "VArMax_SF", "var").
+		add("VArMaxQ3", TypeInt16, "VArMax_SF", "var").
+		add("VArMaxQ4", TypeInt16, "VArMax_SF", "var").
+		add("WGra", TypeUint16, "WGra_SF", "% WMax/sec").
+		add("PFMinQ1", TypeInt16, "PFMin_SF", "cos()").
+		add("PFMinQ2", TypeInt16, "PFMin_SF", "cos()").
+		add("PFMinQ3", TypeInt16, "PFMin_SF", "cos()").
+		add("PFMinQ4", TypeInt16, "PFMin_SF", "cos()").
+		add("VArAct", TypeEnum16, "", "").
+		add("ClcTotVA", TypeEnum16, "", "").
+		add("MaxRmpRte", TypeUint16, "MaxRmpRte_SF", "% WGra").
+		add("ECPNomHz", TypeUint16, "ECPNomHz_SF", "Hz").
+		add("ConnPh", TypeEnum16, "", "").
+		sf("WMax_SF").
+		sf("VRef_SF").
+		sf("VRefOfs_SF").
+		sf("VMinMax_SF").
+		sf("VAMax_SF").
+		sf("VArMax_SF").
+		sf("WGra_SF").
+		sf("PFMin_SF").
+		sf("MaxRmpRte_SF").
+		sf("ECPNomHz_SF")}
+
+	models[122] = &modelDef{name: "measurements status", fixed: layout{}.
+		add("PVConn", TypeBitfield16, "", "").
+		add("StorConn", TypeBitfield16, "", "").
+		add("ECPConn", TypeBitfield16, "", "").
+		add("ActWh", TypeAcc64, "", "Wh").
+		add("ActVAh", TypeAcc64, "", "VAh").
+		add("ActVArhQ1", TypeAcc64, "", "varh").
+		add("ActVArhQ2", TypeAcc64, "", "varh").
+		add("ActVArhQ3", TypeAcc64, "", "varh").
+		add("ActVArhQ4", TypeAcc64, "", "varh").
+		add("VArAval", TypeInt16, "VArAval_SF", "var").
+		sf("VArAval_SF").
+		add("WAval", TypeUint16, "WAval_SF", "var").
+		sf("WAval_SF").
+		add("StSetLimMsk", TypeBitfield32, "", "").
+		add("StActCtl", TypeBitfield32, "", "").
+		str("TmSrc", 4).
+		add("Tms", TypeUint32, "", "Secs").
+		add("RtSt", TypeBitfield16, "", "").
+		add("Ris", TypeUint16, "Ris_SF", "ohms").
+		sf("Ris_SF")}
+
+	models[123] = &modelDef{name: "immediate controls", fixed: layout{}.
+		add("Conn_WinTms", TypeUint16, "", "Secs").
+		add("Conn_RvrtTms", TypeUint16, "", "Secs").
+		add("Conn", TypeEnum16, "", "").
+		add("WMaxLimPct", TypeUint16, "WMaxLimPct_SF", "% WMax").
+		add("WMaxLimPct_WinTms", TypeUint16, "", "Secs").
+		add("WMaxLimPct_RvrtTms", TypeUint16, "", "Secs").
+		add("WMaxLimPct_RmpTms", TypeUint16, "", "Secs").
+		add("WMaxLim_Ena", TypeEnum16, "", "").
+		add("OutPFSet", TypeInt16, "OutPFSet_SF", "cos()").
+		add("OutPFSet_WinTms", TypeUint16, "", "Secs").
+		add("OutPFSet_RvrtTms", TypeUint16, "", "Secs").
+		add("OutPFSet_RmpTms", TypeUint16, "", "Secs").
+		add("OutPFSet_Ena", TypeEnum16, "", "").
+		add("VArWMaxPct", TypeInt16, "VArPct_SF", "% WMax").
+		add("VArMaxPct", TypeInt16, "VArPct_SF", "% VArMax").
+		add("VArAvalPct", TypeInt16, "VArPct_SF", "% VArAval").
+		add("VArPct_WinTms", TypeUint16, "", "Secs").
+		add("VArPct_RvrtTms", TypeUint16, "", "Secs").
+		add("VArPct_RmpTms", TypeUint16, "", "Secs").
+		add("VArPct_Mod", TypeEnum16, "", "").
+		add("VArPct_Ena", TypeEnum16, "", "").
+		sf("WMaxLimPct_SF").
+		sf("OutPFSet_SF").
+		sf("VArPct_SF")}
+
+	models[124] = &modelDef{name: "storage", fixed: layout{}.
+		add("WChaMax", TypeUint16, "WChaMax_SF", "W").
+		add("WChaGra", TypeUint16, "WChaDisChaGra_SF", "% WChaMax/sec").
+		add("WDisChaGra", TypeUint16, "WChaDisChaGra_SF", "% WChaMax/sec").
+		add("StorCtl_Mod", TypeBitfield16, "", "").
+		add("VAChaMax", TypeUint16, "VAChaMax_SF", "VA").
+		add("MinRsvPct", TypeUint16, "MinRsvPct_SF", "% WChaMax").
+		add("ChaState", TypeUint16, "ChaState_SF", "% AhrRtg").
+		add("StorAval", TypeUint16, "StorAval_SF", "AH").
+		add("InBatV", TypeUint16, "InBatV_SF", "V").
+		add("ChaSt", TypeEnum16, "", "").
+		add("OutWRte", TypeInt16, "InOutWRte_SF", "% WDisChaMax").
+		add("InWRte", TypeInt16, "InOutWRte_SF", "% WChaMax").
+		add("InOutWRte_WinTms", TypeUint16, "", "Secs").
+		add("InOutWRte_RvrtTms", TypeUint16, "", "Secs").
+		add("InOutWRte_RmpTms", TypeUint16, "", "Secs").
+		add("ChaGriSet", TypeEnum16, "", "").
+		sf("WChaMax_SF").
+		sf("WChaDisChaGra_SF").
+		sf("VAChaMax_SF").
+		sf("MinRsvPct_SF").
+		sf("ChaState_SF").
+		sf("StorAval_SF").
+		sf("InBatV_SF").
+		sf("InOutWRte_SF")}
+
+	models[160] = &modelDef{name: "multiple MPPT inverter extension",
+		fixed: layout{}.
+			sf("DCA_SF").
+			sf("DCV_SF").
+			sf("DCW_SF").
+			sf("DCWH_SF").
+			add("Evt", TypeBitfield32, "", "").
+			add("N", TypeUint16, "", "").
+			add("TmsPer", TypeUint16, "", ""),
+		repeating: layout{}.
+			add("ID", TypeUint16, "", "").
+			str("IDStr", 8).
+			add("DCA", TypeUint16, "DCA_SF", "A").
+			add("DCV", TypeUint16, "DCV_SF", "V").
+			add("DCW", TypeUint16, "DCW_SF", "W").
+			add("DCWH", TypeAcc32, "DCWH_SF", "Wh").
+			add("Tms", TypeUint32, "", "Secs").
+			add("Tmp", TypeInt16, "", "C").
+			add("DCSt", TypeEnum16, "", "").
+			add("DCEvt", TypeBitfield32, "", ""),
+		repeatLength: 20,
+	}
+
+	meter := layout{}.
+		add("A", TypeInt16, "A_SF", "A").
+		add("AphA", TypeInt16, "A_SF", "A").
+		add("AphB", TypeInt16, "A_SF", "A").
+		add("AphC", TypeInt16, "A_SF", "A").
+		sf("A_SF").
+		add("PhV", TypeInt16, "V_SF", "V").
+		add("PhVphA", TypeInt16, "V_SF", "V").
+		add("PhVphB", TypeInt16, "V_SF", "V").
+		add("PhVphC", TypeInt16, "V_SF", "V").
+		add("PPV", TypeInt16, "V_SF", "V").
+		add("PPVphAB", TypeInt16, "V_SF", "V").
+		add("PPVphBC", TypeInt16, "V_SF", "V").
+		add("PPVphCA", TypeInt16, "V_SF", "V").
+		sf("V_SF").
+		add("Hz", TypeInt16, "Hz_SF", "Hz").
+		sf("Hz_SF")
+	for _, quantity := range []struct{ name, units string }{{"W", "W"}, {"VA", "VA"}, {"VAR", "var"}, {"PF", "Pct"}} {
+		meter = meter.
+			add(quantity.name, TypeInt16, quantity.name+"_SF", quantity.units).
+			add(quantity.name+"phA", TypeInt16, quantity.name+"_SF", quantity.units).
+			add(quantity.name+"phB", TypeInt16, quantity.name+"_SF", quantity.units).
+			add(quantity.name+"phC", TypeInt16, quantity.name+"_SF", quantity.units).
+			sf(quantity.name + "_SF")
+	}
+	for _, energy := range []struct {
+		names []string
+		sf    string
+		units string
+	}{
+		{[]string{"TotWhExp", "TotWhImp"}, "TotWh_SF", "Wh"},
+		{[]string{"TotVAhExp", "TotVAhImp"}, "TotVAh_SF", "VAh"},
+		{[]string{"TotVArhImpQ1", "TotVArhImpQ2", "TotVArhExpQ3", "TotVArhExpQ4"}, "TotVArh_SF", "varh"},
+	} {
+		for _, name := range energy.names {
+			meter = meter.
+				add(name, TypeAcc32, energy.sf, energy.units).
+				add(name+"PhA", TypeAcc32, energy.sf, energy.units).
+				add(name+"PhB", TypeAcc32, energy.sf, energy.units).
+				add(name+"PhC", TypeAcc32, energy.sf, energy.units)
+		}
+		meter = meter.sf(energy.sf)
+	}
+	meter = meter.add("Evt", TypeBitfield32, "", "")
+	models[201] = &modelDef{name: "meter (single phase)", fixed: meter}
+	models[202] = &modelDef{name: "meter (split phase)", fixed: meter}
+	models[203] = &modelDef{name: "meter (wye three phase)", fixed: meter}
+	models[204] = &modelDef{name: "meter (delta three phase)", fixed: meter}
+
+	der := layout{}.
+		add("ACType", TypeEnum16, "", "").
+		add("St", TypeEnum16, "", "").
+		add("InvSt", TypeEnum16, "", "").
+		add("ConnSt", TypeEnum16, "", "").
+		add("Alrm", TypeBitfield32, "", "").
+		add("DERMode", TypeBitfield32, "", "").
+		add("W", TypeInt16, "W_SF", "W").
+		add("VA", TypeInt16, "VA_SF", "VA").
+		add("Var", TypeInt16, "Var_SF", "var").
+		add("PF", TypeInt16, "PF_SF", "").
+		add("A", TypeInt16, "A_SF", "A").
+		add("LLV", TypeUint16, "V_SF", "V").
+		add("LNV", TypeUint16, "V_SF", "V").
+		add("Hz", TypeUint32, "Hz_SF", "Hz").
+		add("TotWhInj", TypeAcc64, "TotWh_SF", "Wh").
+		add("TotWhAbs", TypeAcc64, "TotWh_SF", "Wh").
+		add("TotVarhInj", TypeAcc64, "TotVarh_SF", "varh").
+		add("TotVarhAbs", TypeAcc64, "TotVarh_SF", "varh").
+		add("TmpAmb", TypeInt16, "Tmp_SF", "C").
+		add("TmpCab", TypeInt16, "Tmp_SF", "C").
+		add("TmpSnk", TypeInt16, "Tmp_SF", "C").
+		add("TmpTrns", TypeInt16, "Tmp_SF", "C").
+		add("TmpSw", TypeInt16, "Tmp_SF", "C").
+		add("TmpOt", TypeInt16, "Tmp_SF", "C")
+	for i, phase := range []string{"L1", "L2", "L3"} {
+		next := []string{"L2", "L3", "L1"}[i]
+		der = der.
+			add("W"+phase, TypeInt16, "W_SF", "W").
+			add("VA"+phase, TypeInt16, "VA_SF", "VA").
+			add("Var"+phase, TypeInt16, "Var_SF", "var").
+			add("PF"+phase, TypeInt16, "PF_SF", "").
+			add("A"+phase, TypeInt16, "A_SF", "A").
+			add("V"+phase+next, TypeUint16, "V_SF", "V").
+			add("V"+phase, TypeUint16, "V_SF", "V").
+			add("TotWhInj"+phase, TypeAcc64, "TotWh_SF", "Wh").
+			add("TotWhAbs"+phase, TypeAcc64, "TotWh_SF", "Wh").
+			add("TotVarhInj"+phase, TypeAcc64, "TotVarh_SF", "varh").
+			add("TotVarhAbs"+phase, TypeAcc64, "TotVarh_SF", "varh")
+	}
+	models[701] = &modelDef{name: "DER AC measurement", fixed: der.
+		add("ThrotPct", TypeUint16, "", "Pct").
+		add("ThrotSrc", TypeBitfield32, "", "").
+		sf("W_SF").
+		sf("VA_SF").
+		sf("Var_SF").
+		sf("PF_SF").
+		sf("A_SF").
+		sf("V_SF").
+		sf("Hz_SF").
+		sf("TotWh_SF").
+		sf("TotVarh_SF").
+		sf("Tmp_SF").
+		str("MnAlrmInfo", 32)}
+
+	models[702] = &modelDef{name: "DER capacity", fixed: layout{}.
+		add("WMaxRtg", TypeUint16, "W_SF", "W").
+		add("WOvrExtRtg", TypeUint16, "W_SF", "W").
+		add("WOvrExtRtgPF", TypeUint16, "PF_SF", "").
+		add("WUndExtRtg", TypeUint16, "W_SF", "W").
+		add("WUndExtRtgPF", TypeUint16, "PF_SF", "").
+		add("VAMaxRtg", TypeUint16, "VA_SF", "VA").
+		add("VarMaxInjRtg", TypeUint16, "Var_SF", "var").
+		add("VarMaxAbsRtg", TypeUint16, "Var_SF", "var").
+		add("WChaRteMaxRtg", TypeUint16, "W_SF", "W").
+		add("WDisChaRteMaxRtg", TypeUint16, "W_SF", "W").
+		add("VAChaRteMaxRtg", TypeUint16, "VA_SF", "VA").
+		add("VADisChaRteMaxRtg", TypeUint16, "VA_SF", "VA").
+		add("VNomRtg", TypeUint16, "V_SF", "V").
+		add("VMaxRtg", TypeUint16, "V_SF", "V").
+		add("VMinRtg", TypeUint16, "V_SF", "V").
+		add("AMaxRtg", TypeUint16, "A_SF", "A").
+		add("PFOvrExtRtg", TypeUint16, "PF_SF", "").
+		add("PFUndExtRtg", TypeUint16, "PF_SF", "").
+		add("ReactSusceptRtg", TypeUint16, "S_SF", "S").
+		add("NorOpCatRtg", TypeEnum16, "", "").
+		add("AbnOpCatRtg", TypeEnum16, "", "").
+		add("CtrlModes", TypeBitfield32, "", "").
+		add("IntIslandCatRtg", TypeBitfield16, "", "").
+		add("WMax", TypeUint16, "W_SF", "W").
+		add("WMaxOvrExt", TypeUint16, "W_SF", "W").
+		add("WOvrExtPF", TypeUint16, "PF_SF", "").
+		add("WMaxUndExt", TypeUint16, "W_SF", "W").
+		add("WUndExtPF", TypeUint16, "PF_SF", "").
+		add("VAMax", TypeUint16, "VA_SF", "VA").
+		add("VarMaxInj", TypeUint16, "Var_SF", "var").
+		add("VarMaxAbs", TypeUint16, "Var_SF", "var").
+		add("WChaRteMax", TypeUint16, "W_SF", "W").
+		add("WDisChaRteMax", TypeUint16, "W_SF", "W").
+		add("VAChaRteMax", TypeUint16, "VA_SF", "VA").
+		add("VADisChaRteMax", TypeUint16, "VA_SF", "VA").
+		add("VNom", TypeUint16, "V_SF", "V").
+		add("VMax", TypeUint16, "V_SF", "V").
+		add("VMin", TypeUint16, "V_SF", "V").
+		add("AMax", TypeUint16, "A_SF", "A").
+		add("PFOvrExt", TypeUint16, "PF_SF", "").
+		add("PFUndExt", TypeUint16, "PF_SF", "").
+		add("IntIslandCat", TypeBitfield16, "", "").
+		sf("W_SF").
+		sf("PF_SF").
+		sf("VA_SF").
+		sf("Var_SF").
+		sf("V_SF").
+		sf("A_SF").
+		sf("S_SF")}
+
+	models[703] = &modelDef{name: "enter service", fixed: layout{}.
+		add("ES", TypeEnum16, "", "").
+		add("ESVHi", TypeUint16, "V_SF", "Pct").
+		add("ESVLo", TypeUint16, "V_SF", "Pct").
+		add("ESHzHi", TypeUint32, "Hz_SF", "Hz").
+		add("ESHzLo", TypeUint32, "Hz_SF", "Hz").
+		add("ESDlyTms", TypeUint32, "", "Secs").
+		add("ESRndTms", TypeUint32, "", "Secs").
+		add("ESRmpTms", TypeUint32, "", "Secs").
+		add("ESDlyRemTms", TypeUint32, "", "Secs").
+		sf("V_SF").
+		sf("Hz_SF")}
+
+	controls := layout{}
+	for _, pf := range []string{"PFWInj", "PFWAbs"} {
+		controls = controls.
+			add(pf+"Ena", TypeEnum16, "", "").
+			add(pf+"EnaRvrt", TypeEnum16, "", "").
+			add(pf+"RvrtTms", TypeUint32, "", "Secs").
+			add(pf+"RvrtRem", TypeUint32, "", "Secs")
+	}
+	controls = controls.
+		add("WMaxLimPctEna", TypeEnum16, "", "").
+		add("WMaxLimPct", TypeUint16, "WMaxLimPct_SF", "Pct").
+		add("WMaxLimPctRvrt", TypeUint16, "WMaxLimPct_SF", "Pct").
+		add("WMaxLimPctEnaRvrt", TypeEnum16, "", "").
+		add("WMaxLimPctRvrtTms", TypeUint32, "", "Secs").
+		add("WMaxLimPctRvrtRem", TypeUint32, "", "Secs").
+		add("WSetEna", TypeEnum16, "", "").
+		add("WSetMod", TypeEnum16, "", "").
+		add("WSet", TypeInt32, "WSet_SF", "W").
+		add("WSetRvrt", TypeInt32, "WSet_SF", "W").
+		add("WSetPct", TypeInt16, "WSetPct_SF", "Pct").
+		add("WSetPctRvrt", TypeInt16, "WSetPct_SF", "Pct").
+		add("WSetEnaRvrt", TypeEnum16, "", "").
+		add("WSetRvrtTms", TypeUint32, "", "Secs").
+		add("WSetRvrtRem", TypeUint32, "", "Secs").
+		add("VarSetEna", TypeEnum16, "", "").
+		add("VarSetMod", TypeEnum16, "", "").
+		add("VarSetPri", TypeEnum16, "", "").
+		add("VarSet", TypeInt32, "VarSet_SF", "var").
+		add("VarSetRvrt", TypeInt32, "VarSet_SF", "var").
+		add("VarSetPct", TypeInt16, "VarSetPct_SF", "Pct").
+		add("VarSetPctRvrt", TypeInt16, "VarSetPct_SF", "Pct").
+		add("VarSetEnaRvrt", TypeEnum16, "", "").
+		add("VarSetRvrtTms", TypeUint32, "", "Secs").
+		add("VarSetRvrtRem", TypeUint32, "", "Secs").
+		add("WRmp", TypeUint16, "", "").
+		add("WRmpRef", TypeEnum16, "", "").
+		add("VarRmp", TypeUint16, "", "").
+		add("AntiIslEna", TypeEnum16, "", "").
+		sf("PF_SF").
+		sf("WMaxLimPct_SF").
+		sf("WSet_SF").
+		sf("WSetPct_SF").
+		sf("VarSet_SF").
+		sf("VarSetPct_SF")
+	// The power factor groups, named group.point
+	for _, pf := range []string{"PFWInj", "PFWInjRvrt", "PFWAbs", "PFWAbsRvrt"} {
+		controls = controls.
+			add(pf+".PF", TypeUint16, "PF_SF", "").
+			add(pf+".Ext", TypeEnum16, "", "")
+	}
+	models[704] = &modelDef{name: "DER AC controls", fixed: controls}
+}
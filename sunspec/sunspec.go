@@ -0,0 +1,293 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+// Package sunspec discovers and decodes the SunSpec models of solar
+// inverters, meters and storage devices.
+//
+// Models 1, 101 to 103, 120 to 124, 160, 201 to 204 and 701 to 704 are
+// decoded; other models, including the DER curve models from 705 on, are
+// returned as raw registers.
+package sunspec
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"actshad.dev/modbus"
+)
+
+// BaseAddresses are the addresses probed for the SunSpec marker.
+var BaseAddresses = []uint16{0, 40000, 50000}
+
+const (
+	// marker is "SunS" in two registers.
+	marker uint32 = 0x53756E53
+	// endModelId terminates the model chain.
+	endModelId = 0xFFFF
+	// maxQuantity is the number of registers read at once.
+	maxQuantity = 125
+)
+
+// ErrNotFound is returned by Discover when no base address holds the
+// SunSpec marker.
+var ErrNotFound = errors.New("sunspec: marker not found")
+
+// ModelHeader locates a model. Address is the address of the first
+// register following the model id and length.
+type ModelHeader struct {
+	Id      uint16
+	Address uint16
+	Length  uint16
+}
+
+// Device is a SunSpec device found by Discover.
+type Device struct {
+	Client modbus.Client
+	// Base is the address of the marker.
+	Base   uint16
+	Models []ModelHeader
+}
+
+// Discover probes BaseAddresses for the marker and walks the model chain.
+func Discover(ctx context.Context, client modbus.Client) (device *Device, err error) {
+	c := modbus.NewContextClient(client)
+	for _, base := range BaseAddresses {
+		var results []byte
+		results, err = c.ReadHoldingRegistersContext(ctx, base, 2)
+		if err != nil {
+			var modbusError *modbus.ModbusError
+			if ctx.Err() != nil || !errors.As(err, &modbusError) {
+				return
+			}
+			// The device has no register at this base
+			continue
+		}
+		if len(results) != 4 || binary.BigEndian.Uint32(results) != marker {
+			continue
+		}
+		device = &Device{Client: client, Base: base}
+		if err = device.walk(ctx); err != nil {
+			device = nil
+		}
+		return
+	}
+	err = ErrNotFound
+	return
+}
+
+// walk reads the model headers from the marker on.
+func (d *Device) walk(ctx context.Context) error {
+	address := uint32(d.Base) + 2
+	for address+2 <= math.MaxUint16+1 {
+		results, err := modbus.NewContextClient(d.Client).ReadHoldingRegistersContext(ctx, uint16(address), 2)
+		if err != nil {
+			return err
+		}
+		if len(results) != 4 {
+			return fmt.Errorf("sunspec: response size '%v' does not match expected '%v'", len(results), 4)
+		}
+		id, length := binary.BigEndian.Uint16(results), binary.BigEndian.Uint16(results[2:])
+		if id == endModelId {
+			return nil
+		}
+		if address+2+uint32(length) > math.MaxUint16+1 {
+			return fmt.Errorf("sunspec: model %v at %v exceeds the address space", id, address)
+		}
+		d.Models = append(d.Models, ModelHeader{Id: id, Address: uint16(address + 2), Length: length})
+		address += 2 + uint32(length)
+	}
+	return fmt.Errorf("sunspec: end model not found")
+}
+
+// Model returns the header of the first model with the id and whether
+// there is one.
+func (d *Device) Model(id uint16) (header ModelHeader, ok bool) {
+	for _, header = range d.Models {
+		if header.Id == id {
+			ok = true
+			return
+		}
+	}
+	return
+}
+
+// ReadModel reads and decodes the model.
+func (d *Device) ReadModel(ctx context.Context, header ModelHeader) (model *Model, err error) {
+	data := make([]byte, 0, 2*int(header.Length))
+	for offset := uint16(0); offset < header.Length; offset += maxQuantity {
+		quantity := header.Length - offset
+		if quantity > maxQuantity {
+			quantity = maxQuantity
+		}
+		var results []byte
+		if results, err = modbus.NewContextClient(d.Client).ReadHoldingRegistersContext(ctx, header.Address+offset, quantity); err != nil {
+			return
+		}
+		if len(results) != 2*int(quantity) {
+			err = fmt.Errorf("sunspec: response size '%v' does not match expected '%v'", len(results), 2*quantity)
+			return
+		}
+		data = append(data, results...)
+	}
+	model = Decode(header.Id, data)
+	model.Address = header.Address
+	return
+}
+
+// ReadModels reads and decodes all models of the device.
+func (d *Device) ReadModels(ctx context.Context) (models []*Model, err error) {
+	models = make([]*Model, 0, len(d.Models))
+	for _, header := range d.Models {
+		var model *Model
+		if model, err = d.ReadModel(ctx, header); err != nil {
+			return
+		}
+		models = append(models, model)
+	}
+	return
+}
+
+// Value is a decoded point.
+type Value struct {
+	Type PointType
+	// Value is the value scaled by its scale factor.
+	Value float64
+	// Raw is the value as read, in two's complement for signed types
+	// and IEEE 754 bits for float32.
+	Raw uint64
+	// Text is the value of string points.
+	Text  string
+	Units string
+	// Implemented is false when the device returns the unimplemented
+	// value of the type or of the scale factor.
+	Implemented bool
+}
+
+// Model is a decoded model. Points of unknown models are nil.
+type Model struct {
+	Id      uint16
+	Name    string
+	Address uint16
+	Length  uint16
+	// Points are the points of the fixed block by name.
+	Points map[string]Value
+	// Repeats are the points of each repeating block.
+	Repeats []map[string]Value
+	// Data are the registers of the model.
+	Data []byte
+}
+
+// Decode decodes the registers of model id, excluding its id and length.
+func Decode(id uint16, data []byte) *Model {
+	model := &Model{Id: id, Length: uint16(len(data) / 2), Data: data}
+	def, ok := models[id]
+	if !ok {
+		return model
+	}
+	model.Name = def.name
+	fixedLength := int(def.fixedLength())
+	if fixedLength > len(data)/2 {
+		fixedLength = len(data) / 2
+	}
+	model.Points = decodeBlock(def.fixed, data[:2*fixedLength], nil)
+	if def.repeatLength > 0 {
+		size := 2 * int(def.repeatLength)
+		for block := data[2*fixedLength:]; len(block) >= size; block = block[size:] {
+			model.Repeats = append(model.Repeats, decodeBlock(def.repeating, block[:size], model.Points))
+		}
+	}
+	return model
+}
+
+// decodeBlock decodes the points of a block. Scale factors are looked up
+// in the block first, then in outer.
+func decodeBlock(defs []pointDef, data []byte, outer map[string]Value) map[string]Value {
+	points := make(map[string]Value, len(defs))
+	// Scale factors first
+	for _, def := range defs {
+		if def.typ == TypeSunssf && 2*int(def.offset+def.size) <= len(data) {
+			points[def.name] = decodePoint(def, data[2*def.offset:])
+		}
+	}
+	for _, def := range defs {
+		if def.typ == TypeSunssf || def.typ == TypePad || 2*int(def.offset+def.size) > len(data) {
+			continue
+		}
+		value := decodePoint(def, data[2*def.offset:])
+		if def.sf != "" && value.Implemented {
+			sf, ok := points[def.sf]
+			if !ok {
+				sf, ok = outer[def.sf]
+			}
+			if ok && sf.Implemented {
+				value.Value *= math.Pow10(int(sf.Value))
+			} else {
+				value.Implemented = false
+			}
+		}
+		points[def.name] = value
+	}
+	return points
+}
+
+// decodePoint decodes an unscaled point at the start of data.
+func decodePoint(def pointDef, data []byte) (value Value) {
+	value.Type = def.typ
+	value.Units = def.units
+	value.Implemented = true
+	switch def.typ {
+	case TypeInt16, TypeSunssf:
+		value.Raw = uint64(binary.BigEndian.Uint16(data))
+		value.Value = float64(int16(value.Raw))
+		value.Implemented = value.Raw != 0x8000
+	case TypeUint16, TypeEnum16, TypeBitfield16:
+		value.Raw = uint64(binary.BigEndian.Uint16(data))
+		value.Value = float64(value.Raw)
+		value.Implemented = value.Raw != 0xFFFF
+	case TypeAcc16:
+		value.Raw = uint64(binary.BigEndian.Uint16(data))
+		value.Value = float64(value.Raw)
+		value.Implemented = value.Raw != 0
+	case TypeInt32:
+		value.Raw = uint64(binary.BigEndian.Uint32(data))
+		value.Value = float64(int32(value.Raw))
+		value.Implemented = value.Raw != 0x80000000
+	case TypeUint32, TypeEnum32, TypeBitfield32:
+		value.Raw = uint64(binary.BigEndian.Uint32(data))
+		value.Value = float64(value.Raw)
+		value.Implemented = value.Raw != 0xFFFFFFFF
+	case TypeAcc32:
+		value.Raw = uint64(binary.BigEndian.Uint32(data))
+		value.Value = float64(value.Raw)
+		value.Implemented = value.Raw != 0
+	case TypeInt64:
+		value.Raw = binary.BigEndian.Uint64(data)
+		value.Value = float64(int64(value.Raw))
+		value.Implemented = value.Raw != 0x8000000000000000
+	case TypeUint64:
+		value.Raw = binary.BigEndian.Uint64(data)
+		value.Value = float64(value.Raw)
+		value.Implemented = value.Raw != 0xFFFFFFFFFFFFFFFF
+	case TypeAcc64:
+		value.Raw = binary.BigEndian.Uint64(data)
+		value.Value = float64(value.Raw)
+		value.Implemented = value.Raw != 0
+	case TypeFloat32:
+		value.Raw = uint64(binary.BigEndian.Uint32(data))
+		value.Value = float64(math.Float32frombits(uint32(value.Raw)))
+		value.Implemented = !math.IsNaN(value.Value)
+	case TypeString:
+		text := data[:2*def.size]
+		if i := strings.IndexByte(string(text), 0); i >= 0 {
+			text = text[:i]
+		}
+		value.Text = strings.TrimRight(string(text), " ")
+		value.Implemented = len(text) > 0
+	}
+	return
+}
@@ -0,0 +1,207 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package sunspec
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"actshad.dev/modbus"
+)
+
+// registerClient answers holding register reads from registers. Other
+// addresses are illegal.
+type registerClient struct {
+	modbus.Client
+	registers map[uint16]uint16
+}
+
+func (c *registerClient) set(address uint16, values ...uint16) {
+	for i, value := range values {
+		c.registers[address+uint16(i)] = value
+	}
+}
+
+func (c *registerClient) setString(address uint16, size int, text string) {
+	data := make([]byte, 2*size)
+	copy(data, text)
+	for i := 0; i < size; i++ {
+		c.registers[address+uint16(i)] = binary.BigEndian.Uint16(data[2*i:])
+	}
+}
+
+func (c *registerClient) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	if quantity > 125 {
+		return nil, &modbus.ModbusError{FunctionCode: modbus.FuncCodeReadHoldingRegisters, ExceptionCode: modbus.ExceptionCodeIllegalDataValue}
+	}
+	results = make([]byte, 2*int(quantity))
+	for i := 0; i < int(quantity); i++ {
+		value, ok := c.registers[address+uint16(i)]
+		if !ok {
+			return nil, &modbus.ModbusError{FunctionCode: modbus.FuncCodeReadHoldingRegisters, ExceptionCode: modbus.ExceptionCodeIllegalDataAddress}
+		}
+		binary.BigEndian.PutUint16(results[2*i:], value)
+	}
+	return
+}
+
+// newInverter returns a device with models 1, 103 and 160 at 40000.
+func newInverter() *registerClient {
+	c := &registerClient{registers: make(map[uint16]uint16)}
+	c.set(40000, 0x5375, 0x6E53)
+	c.set(40002, 1, 66)
+	for i := uint16(0); i < 66; i++ {
+		c.set(40004+i, 0)
+	}
+	c.setString(40004, 16, "Acme")
+	c.setString(40020, 16, "Sun 5000")
+	c.setString(40052, 16, "SN-42  ")
+	c.set(40068, 1)
+
+	c.set(40070, 103, 50)
+	for i := uint16(0); i < 50; i++ {
+		c.set(40072+i, 0xFFFF)
+	}
+	c.set(40072, 1234, 411, 412, 411, 0xFFFE) // A, AphA..C, A_SF
+	c.set(40084, 5000, 0)                     // W, W_SF
+	c.set(40086, 5002, 0xFFFE)                // Hz, Hz_SF
+	c.set(40088, 0x8000, 0)                   // VA not implemented
+	c.set(40090, 100, 0x8000)                 // VAr, VAr_SF not implemented
+	c.set(40094, 0x0001, 0x86A0, 3)           // WH, WH_SF
+	c.set(40103, 0xFF9C, 0x8000, 0x8000, 0x8000, 0xFFFF)
+	c.set(40108, 4)
+
+	// Two modules
+	c.set(40122, 160, 48)
+	c.set(40124, 0x8000, 0, 0, 0, 0, 0, 2, 0)
+	c.set(40132, 1)
+	c.setString(40133, 8, "string 1")
+	c.set(40141, 52, 3801, 1977, 0, 1000, 0, 0, 25, 4, 0, 0)
+	c.set(40152, 2)
+	c.setString(40153, 8, "string 2")
+	c.set(40161, 0xFFFF, 3790, 0xFFFF, 0, 0, 0, 0, 0x8000, 0xFFFF, 0xFFFF, 0xFFFF)
+	c.set(40172, 0xFFFF, 0)
+	return c
+}
+
+func TestDiscover(t *testing.T) {
+	client := newInverter()
+	device, err := Discover(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.Base != 40000 {
+		t.Fatalf("base: expected %v, actual %v", 40000, device.Base)
+	}
+	expected := []ModelHeader{{1, 40004, 66}, {103, 40072, 50}, {160, 40124, 48}}
+	if len(device.Models) != len(expected) {
+		t.Fatalf("models: expected %v, actual %v", expected, device.Models)
+	}
+	for i := range expected {
+		if device.Models[i] != expected[i] {
+			t.Fatalf("models: expected %v, actual %v", expected, device.Models)
+		}
+	}
+
+	_, err = Discover(context.Background(), &registerClient{registers: map[uint16]uint16{0: 1, 1: 2}})
+	if err != ErrNotFound {
+		t.Fatalf("expected %v, actual %v", ErrNotFound, err)
+	}
+}
+
+func TestReadModel(t *testing.T) {
+	device, err := Discover(context.Background(), newInverter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	models, err := device.ReadModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	common := models[0].Points
+	if common["Mn"].Text != "Acme" || common["Md"].Text != "Sun 5000" || common["SN"].Text != "SN-42" {
+		t.Fatalf("unexpected common model %+v", common)
+	}
+	if common["Opt"].Implemented || common["DA"].Value != 1 {
+		t.Fatalf("unexpected common model %+v", common)
+	}
+
+	inverter := models[1]
+	if inverter.Name != "inverter (three phase)" || inverter.Address != 40072 {
+		t.Fatalf("unexpected model %+v", inverter)
+	}
+	values := map[string]float64{"A": 12.34, "AphB": 4.12, "W": 5000, "Hz": 50.02, "WH": 100000000, "TmpCab": -10}
+	for name, expected := range values {
+		value := inverter.Points[name]
+		if !value.Implemented || math.Abs(value.Value-expected) > 1e-9 {
+			t.Fatalf("%v: expected %v, actual %+v", name, expected, value)
+		}
+	}
+	for _, name := range []string{"VA", "VAr", "DCA", "TmpSnk", "Evt1"} {
+		if inverter.Points[name].Implemented {
+			t.Fatalf("%v: unexpected value %+v", name, inverter.Points[name])
+		}
+	}
+	if _, ok := inverter.Points["W_SF"]; !ok {
+		t.Fatal("scale factors are missing")
+	}
+	if inverter.Points["St"].Value != 4 || inverter.Points["Hz"].Units != "Hz" {
+		t.Fatalf("unexpected points %+v", inverter.Points)
+	}
+
+	mppt := models[2]
+	if len(mppt.Repeats) != 2 {
+		t.Fatalf("repeats: expected %v, actual %v", 2, len(mppt.Repeats))
+	}
+	module := mppt.Repeats[0]
+	if module["IDStr"].Text != "string 1" || module["DCV"].Value != 3801 || module["DCWH"].Value != 1000 {
+		t.Fatalf("unexpected module %+v", module)
+	}
+	// DCA_SF is not implemented
+	if module["DCA"].Implemented || mppt.Repeats[1]["DCV"].Value != 3790 || mppt.Repeats[1]["Tmp"].Implemented {
+		t.Fatalf("unexpected modules %+v", mppt.Repeats)
+	}
+}
+
+func TestDecodeUnknownModel(t *testing.T) {
+	model := Decode(64001, []byte{1, 2, 3, 4})
+	if model.Points != nil || model.Length != 2 || len(model.Data) != 4 {
+		t.Fatalf("unexpected model %+v", model)
+	}
+}
+
+func TestDecodeAccumulators(t *testing.T) {
+	data := make([]byte, 2*models[701].fixedLength())
+	offsets := make(map[string]int)
+	for _, p := range models[701].fixed {
+		offsets[p.name] = 2 * int(p.offset)
+	}
+	binary.BigEndian.PutUint64(data[offsets["TotWhInj"]:], 12345)
+	binary.BigEndian.PutUint64(data[offsets["TotWhAbs"]:], 0xFFFFFFFFFFFFFFFF)
+	points := Decode(701, data).Points
+	// Accumulators of zero are not implemented, the maximum is a value
+	if w := points["TotWhInj"]; !w.Implemented || w.Value != 12345 || w.Type != TypeAcc64 {
+		t.Fatalf("unexpected point %+v", w)
+	}
+	if !points["TotWhAbs"].Implemented || points["TotVarhInj"].Implemented {
+		t.Fatalf("unexpected points %+v", points)
+	}
+}
+
+func TestModelLengths(t *testing.T) {
+	lengths := map[uint16]uint16{1: 66, 101: 50, 103: 50, 120: 26, 121: 30, 122: 44, 123: 24, 124: 24, 160: 8, 201: 105, 204: 105, 701: 153,
+		702: 50, 703: 17, 704: 65}
+	for id, length := range lengths {
+		if actual := models[id].fixedLength(); actual != length {
+			t.Errorf("model %v: expected %v, actual %v", id, length, actual)
+		}
+	}
+	if actual := models[160].repeating; actual[len(actual)-1].offset+2 != models[160].repeatLength {
+		t.Errorf("model 160: repeating block length mismatch")
+	}
+}
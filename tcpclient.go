@@ -5,6 +5,7 @@
 package modbus
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -24,6 +25,8 @@ const (
 	// Default TCP timeout is not set
 	tcpTimeout     = 10 * time.Second
 	tcpIdleTimeout = 60 * time.Second
+	// Maximum number of abandoned transaction ids remembered
+	tcpMaxAbandoned = 256
 )
 
 // TCPClientHandler implements Packager and Transporter interface.
@@ -88,24 +91,70 @@ func (mb *tcpPackager) Verify(aduRequest []byte, aduResponse []byte) (err error)
 	responseVal := binary.BigEndian.Uint16(aduResponse)
 	requestVal := binary.BigEndian.Uint16(aduRequest)
 	if responseVal != requestVal {
-		err = fmt.Errorf("modbus: response transaction id '%v' does not match request '%v'", responseVal, requestVal)
+		err = newFrameError(ErrTransactionIdMismatch, aduRequest, aduResponse, "modbus: response transaction id '%v' does not match request '%v'", responseVal, requestVal)
 		return
 	}
 	// Protocol id
 	responseVal = binary.BigEndian.Uint16(aduResponse[2:])
 	requestVal = binary.BigEndian.Uint16(aduRequest[2:])
 	if responseVal != requestVal {
-		err = fmt.Errorf("modbus: response protocol id '%v' does not match request '%v'", responseVal, requestVal)
+		err = newFrameError(ErrProtocolIdMismatch, aduRequest, aduResponse, "modbus: response protocol id '%v' does not match request '%v'", responseVal, requestVal)
 		return
 	}
 	// Unit id (1 byte)
 	if aduResponse[6] != aduRequest[6] {
-		err = fmt.Errorf("modbus: response unit id '%v' does not match request '%v'", aduResponse[6], aduRequest[6])
+		err = newFrameError(ErrSlaveIdMismatch, aduRequest, aduResponse, "modbus: response unit id '%v' does not match request '%v'", aduResponse[6], aduRequest[6])
 		return
 	}
 	return
 }
 
+func (mb *tcpPackager) slaveIdOf(adu []byte) (byte, bool) {
+	if len(adu) < tcpHeaderSize {
+		return 0, false
+	}
+	return adu[6], true
+}
+
+func (mb *tcpPackager) encodingSlaveId() (byte, bool) {
+	return mb.SlaveId, true
+}
+
+func (mb *tcpPackager) functionCodeOf(adu []byte) (byte, bool) {
+	if len(adu) <= tcpHeaderSize {
+		return 0, false
+	}
+	return adu[tcpHeaderSize], true
+}
+
+// withSlaveId returns a packager sharing the transaction identifiers of
+// mb, so that its requests are told apart on the same connection.
+func (mb *tcpPackager) withSlaveId(slaveId byte) Packager {
+	return &tcpSlavePackager{tcpPackager: mb, slaveId: slaveId}
+}
+
+// tcpSlavePackager encodes frames for slaveId with the transaction
+// identifiers of tcpPackager.
+type tcpSlavePackager struct {
+	*tcpPackager
+	slaveId byte
+}
+
+func (mb *tcpSlavePackager) Encode(pdu *ProtocolDataUnit) (adu []byte, err error) {
+	if adu, err = mb.tcpPackager.Encode(pdu); err == nil {
+		adu[6] = mb.slaveId
+	}
+	return
+}
+
+func (mb *tcpSlavePackager) encodingSlaveId() (byte, bool) {
+	return mb.slaveId, true
+}
+
+func (mb *tcpSlavePackager) withSlaveId(slaveId byte) Packager {
+	return mb.tcpPackager.withSlaveId(slaveId)
+}
+
 // Decode extracts PDU from TCP frame:
 //  Transaction identifier: 2 bytes
 //  Protocol identifier: 2 bytes
@@ -116,7 +165,7 @@ func (mb *tcpPackager) Decode(adu []byte) (pdu *ProtocolDataUnit, err error) {
 	length := binary.BigEndian.Uint16(adu[4:])
 	pduLength := len(adu) - tcpHeaderSize
 	if pduLength <= 0 || pduLength != int(length-1) {
-		err = fmt.Errorf("modbus: length in response '%v' does not match pdu data length '%v'", length-1, pduLength)
+		err = newFrameError(ErrLengthMismatch, nil, adu, "modbus: length in response '%v' does not match pdu data length '%v'", length-1, pduLength)
 		return
 	}
 	pdu = &ProtocolDataUnit{}
@@ -134,78 +183,259 @@ type tcpTransporter struct {
 	Timeout time.Duration
 	// Idle timeout to close the connection
 	IdleTimeout time.Duration
+	// Maximum number of requests in flight on the connection. Responses
+	// are matched to requests by transaction id. Requests are sent one at
+	// a time when it is not greater than 1. It must be set before the
+	// first request is sent.
+	MaxOutstanding int
 	// Transmission logger
 	Logger *log.Logger
+	// LeveledLogger, if set, receives the transmission log entries with
+	// structured fields instead of Logger
+	LeveledLogger LeveledLogger
 
 	// TCP connection
 	mu           sync.Mutex
 	conn         net.Conn
 	closeTimer   *time.Timer
 	lastActivity time.Time
+	// Requests waiting for a response, by transaction id
+	pending map[uint16]chan tcpResponse
+	// Transaction ids of the requests abandoned without a response, whose
+	// late responses are discarded
+	abandoned map[uint16]struct{}
+	// Limits the number of requests in flight
+	slots     chan struct{}
+	slotsOnce sync.Once
+	// Serializes writes to the connection
+	writeMu sync.Mutex
+	// Number of connections established
+	connects int
 }
 
-// Send sends data to server and ensures response length is greater than header length.
+// tcpResponse is delivered by the reader goroutine to a pending request.
+type tcpResponse struct {
+	adu []byte
+	err error
+}
+
+// tcpTimeoutError is returned when no response arrives within Timeout.
+type tcpTimeoutError struct{}
+
+func (tcpTimeoutError) Error() string   { return "modbus: i/o timeout" }
+func (tcpTimeoutError) Timeout() bool   { return true }
+func (tcpTimeoutError) Temporary() bool { return true }
+
+func (tcpTimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// Send sends data to server and waits for the response with the same
+// transaction id, which is read by the connection reader goroutine.
 func (mb *tcpTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
-	mb.mu.Lock()
-	defer mb.mu.Unlock()
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but abandons the request when ctx is done.
+// A response arriving later is discarded by the reader goroutine.
+func (mb *tcpTransporter) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	tx := TransactionFromContext(ctx)
+	if tx != nil {
+		tx.Transport = "tcp"
+		tx.Address = mb.Address
+		tx.TransactionId = binary.BigEndian.Uint16(aduRequest)
+	}
+	mb.slotsOnce.Do(func() {
+		n := mb.MaxOutstanding
+		if n < 1 {
+			n = 1
+		}
+		mb.slots = make(chan struct{}, n)
+	})
+	select {
+	case mb.slots <- struct{}{}:
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+	defer func() { <-mb.slots }()
+
+	transactionId := binary.BigEndian.Uint16(aduRequest)
+	response := make(chan tcpResponse, 1)
 
+	mb.mu.Lock()
+	connects := mb.connects
 	// Establish a new connection if not connected
 	if err = mb.connect(); err != nil {
+		mb.mu.Unlock()
 		return
 	}
+	if _, ok := mb.pending[transactionId]; ok {
+		mb.mu.Unlock()
+		err = &ValidationError{
+			Field: "transaction id",
+			Value: int(transactionId),
+			Msg:   fmt.Sprintf("modbus: transaction id '%v' is already in flight", transactionId),
+		}
+		return
+	}
+	mb.pending[transactionId] = response
+	delete(mb.abandoned, transactionId)
 	// Set timer to close when idle
 	mb.lastActivity = time.Now()
 	mb.startCloseTimer()
-	// Set write and read timeout
-	var timeout time.Time
-	if mb.Timeout > 0 {
-		timeout = mb.lastActivity.Add(mb.Timeout)
-	}
-	if err = mb.conn.SetDeadline(timeout); err != nil {
-		return
-	}
+	conn := mb.conn
+	timeout := mb.Timeout
+	mb.mu.Unlock()
+
+	defer func() {
+		mb.mu.Lock()
+		if mb.pending[transactionId] == response {
+			delete(mb.pending, transactionId)
+			if len(mb.abandoned) >= tcpMaxAbandoned {
+				mb.abandoned = make(map[uint16]struct{})
+			}
+			mb.abandoned[transactionId] = struct{}{}
+		}
+		if tx != nil {
+			tx.Reconnects = reconnects(connects, mb.connects)
+		}
+		mb.mu.Unlock()
+	}()
+
 	// Send data
-	mb.logf("modbus: sending % x", aduRequest)
-	if _, err = mb.conn.Write(aduRequest); err != nil {
-		mb.logf("modbus: reconnecting")
+	mb.log(ctx, LogLevelDebug, "modbus: sending", LogField{"adu", hexBytes(aduRequest)})
+	if err = mb.write(ctx, conn, aduRequest, timeout); err != nil {
+		mb.log(ctx, LogLevelInfo, "modbus: reconnecting", LogField{"error", err.Error()})
 
-		if err = mb.close(); err != nil {
+		mb.mu.Lock()
+		if mb.conn == conn {
+			mb.close()
+		}
+		if err = mb.connect(); err != nil {
+			mb.mu.Unlock()
 			return
 		}
+		// The failed connection delivered its error to the old channel
+		response = make(chan tcpResponse, 1)
+		mb.pending[transactionId] = response
+		conn = mb.conn
+		mb.mu.Unlock()
 
-		if err = mb.connect(); err != nil {
+		mb.log(ctx, LogLevelDebug, "modbus: resending", LogField{"adu", hexBytes(aduRequest)})
+		if err = mb.write(ctx, conn, aduRequest, timeout); err != nil {
 			return
 		}
+	}
+	// Wait for the response
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case r := <-response:
+		aduResponse, err = r.adu, r.err
+	case <-expired:
+		err = tcpTimeoutError{}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// write writes the request to the connection with timeout, the Timeout
+// copied when the request started, or the context deadline, whichever is
+// earlier, as deadline.
+func (mb *tcpTransporter) write(ctx context.Context, conn net.Conn, aduRequest []byte, timeout time.Duration) (err error) {
+	mb.writeMu.Lock()
+	defer mb.writeMu.Unlock()
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	if err = conn.SetWriteDeadline(deadline); err != nil {
+		return
+	}
+	_, err = conn.Write(aduRequest)
+	return
+}
 
-		mb.logf("modbus: resending % x", aduRequest)
-		if _, err = mb.conn.Write(aduRequest); err != nil {
+// readLoop reads responses from the connection and delivers them to the
+// pending requests until the connection fails or is closed.
+func (mb *tcpTransporter) readLoop(conn net.Conn) {
+	for {
+		aduResponse, err := mb.readResponse(conn)
+		mb.mu.Lock()
+		if mb.conn != conn {
+			// Connection has been closed or replaced
+			mb.mu.Unlock()
+			return
+		}
+		if err != nil {
+			mb.fail(err)
+			mb.close()
+			mb.mu.Unlock()
 			return
 		}
+		mb.log(context.Background(), LogLevelDebug, "modbus: received", LogField{"adu", hexBytes(aduResponse)})
+		transactionId := binary.BigEndian.Uint16(aduResponse)
+		if _, late := mb.abandoned[transactionId]; late {
+			delete(mb.abandoned, transactionId)
+			mb.log(context.Background(), LogLevelWarn, "modbus: discarding late response",
+				LogField{"transaction_id", transactionId}, LogField{"adu", hexBytes(aduResponse)})
+			mb.mu.Unlock()
+			continue
+		}
+		response, ok := mb.pending[transactionId]
+		if !ok && mb.MaxOutstanding <= 1 {
+			// Let the only request in flight verify the transaction id
+			for _, response = range mb.pending {
+				ok = true
+			}
+		}
+		if ok {
+			response <- tcpResponse{adu: aduResponse}
+			delete(mb.pending, transactionId)
+			for id, r := range mb.pending {
+				if r == response {
+					delete(mb.pending, id)
+				}
+			}
+		} else {
+			mb.log(context.Background(), LogLevelWarn, "modbus: discarding response with unknown transaction id",
+				LogField{"transaction_id", transactionId}, LogField{"adu", hexBytes(aduResponse)})
+		}
+		mb.mu.Unlock()
 	}
-	// Read header first
-	var data [tcpMaxLength]byte
-	if _, err = io.ReadFull(mb.conn, data[:tcpHeaderSize]); err != nil {
+}
+
+// readResponse reads one response frame, header first.
+func (mb *tcpTransporter) readResponse(conn net.Conn) (aduResponse []byte, err error) {
+	var header [tcpHeaderSize]byte
+	if _, err = io.ReadFull(conn, header[:]); err != nil {
 		return
 	}
 	// Read length, ignore transaction & protocol id (4 bytes)
-	length := int(binary.BigEndian.Uint16(data[4:]))
+	length := int(binary.BigEndian.Uint16(header[4:]))
 	if length <= 0 {
-		mb.flush(data[:])
-		err = fmt.Errorf("modbus: length in response header '%v' must not be zero", length)
+		err = newFrameError(ErrInvalidFrame, nil, header[:], "modbus: length in response header '%v' must not be zero", length)
 		return
 	}
 	if length > (tcpMaxLength - (tcpHeaderSize - 1)) {
-		mb.flush(data[:])
-		err = fmt.Errorf("modbus: length in response header '%v' must not greater than '%v'", length, tcpMaxLength-tcpHeaderSize+1)
+		err = newFrameError(ErrInvalidFrame, nil, header[:], "modbus: length in response header '%v' must not greater than '%v'", length, tcpMaxLength-tcpHeaderSize+1)
 		return
 	}
 	// Skip unit id
 	length += tcpHeaderSize - 1
-	if _, err = io.ReadFull(mb.conn, data[tcpHeaderSize:length]); err != nil {
-		return
-	}
-	aduResponse = data[:length]
-	mb.logf("modbus: received % x\n", aduResponse)
+	aduResponse = make([]byte, length)
+	copy(aduResponse, header[:])
+	_, err = io.ReadFull(conn, aduResponse[tcpHeaderSize:])
 	return
 }
 
@@ -218,6 +448,8 @@ func (mb *tcpTransporter) Connect() error {
 	return mb.connect()
 }
 
+// connect dials Address and starts the reader goroutine if not connected.
+// Caller must hold the mutex.
 func (mb *tcpTransporter) connect() error {
 	if mb.conn == nil {
 		dialer := net.Dialer{Timeout: mb.Timeout}
@@ -226,6 +458,10 @@ func (mb *tcpTransporter) connect() error {
 			return err
 		}
 		mb.conn = conn
+		mb.connects++
+		mb.pending = make(map[uint16]chan tcpResponse)
+		mb.abandoned = make(map[uint16]struct{})
+		go mb.readLoop(conn)
 	}
 	return nil
 }
@@ -241,6 +477,14 @@ func (mb *tcpTransporter) startCloseTimer() {
 	}
 }
 
+func (mb *tcpTransporter) setTimeout(timeout time.Duration) (previous time.Duration, ok bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	previous, mb.Timeout = mb.Timeout, timeout
+	return previous, true
+}
+
 // Close closes current connection.
 func (mb *tcpTransporter) Close() error {
 	mb.mu.Lock()
@@ -249,38 +493,36 @@ func (mb *tcpTransporter) Close() error {
 	return mb.close()
 }
 
-// flush flushes pending data in the connection,
-// returns io.EOF if connection is closed.
-func (mb *tcpTransporter) flush(b []byte) (err error) {
-	if err = mb.conn.SetReadDeadline(time.Now()); err != nil {
+// log writes an entry with the address of the connection to
+// LeveledLogger or Logger.
+func (mb *tcpTransporter) log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	if mb.LeveledLogger == nil && mb.Logger == nil {
 		return
 	}
-	// Timeout setting will be reset when reading
-	if _, err = mb.conn.Read(b); err != nil {
-		// Ignore timeout error
-		if netError, ok := err.(net.Error); ok && netError.Timeout() {
-			err = nil
-		}
-	}
-	return
+	fields = append([]LogField{{"address", mb.Address}}, fields...)
+	transportLog(mb.LeveledLogger, mb.Logger, ctx, level, msg, fields...)
 }
 
-func (mb *tcpTransporter) logf(format string, v ...interface{}) {
-	if mb.Logger != nil {
-		mb.Logger.Printf(format, v...)
+// fail delivers err to all pending requests. Caller must hold the mutex.
+func (mb *tcpTransporter) fail(err error) {
+	for transactionId, response := range mb.pending {
+		response <- tcpResponse{err: err}
+		delete(mb.pending, transactionId)
 	}
 }
 
-// closeLocked closes current connection. Caller must hold the mutex before calling this method.
+// close closes current connection. Caller must hold the mutex before calling this method.
 func (mb *tcpTransporter) close() (err error) {
 	if mb.conn != nil {
+		mb.fail(ErrConnectionClosed)
 		err = mb.conn.Close()
 		mb.conn = nil
 	}
 	return
 }
 
-// closeIdle closes the connection if last activity is passed behind IdleTimeout.
+// closeIdle closes the connection if last activity is passed behind IdleTimeout
+// and no request is in flight.
 func (mb *tcpTransporter) closeIdle() {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
@@ -289,8 +531,8 @@ func (mb *tcpTransporter) closeIdle() {
 		return
 	}
 	idle := time.Now().Sub(mb.lastActivity)
-	if idle >= mb.IdleTimeout {
-		mb.logf("modbus: closing connection due to idle timeout: %v", idle)
+	if idle >= mb.IdleTimeout && len(mb.pending) == 0 {
+		mb.log(context.Background(), LogLevelInfo, "modbus: closing idle connection", LogField{"idle", idle})
 		mb.close()
 	}
 }
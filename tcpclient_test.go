@@ -6,8 +6,11 @@ package modbus
 
 import (
 	"bytes"
+	"context"
 	"io"
+	"io/ioutil"
 	"net"
+	"sync"
 	"testing"
 	"time"
 )
@@ -88,6 +91,88 @@ func TestTCPTransporter(t *testing.T) {
 	}
 }
 
+func TestTCPTransporterPipelining(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		// Answer both requests in reverse order
+		var requests [2][8]byte
+		for i := range requests {
+			if _, err = io.ReadFull(conn, requests[i][:]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		conn.Write(requests[1][:])
+		conn.Write(requests[0][:])
+	}()
+	client := &tcpTransporter{
+		Address:        ln.Addr().String(),
+		Timeout:        1 * time.Second,
+		MaxOutstanding: 2,
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 2; i++ {
+		req := []byte{0, byte(i), 0, 0, 0, 2, 1, byte(i)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp, err := client.Send(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(req, rsp) {
+				t.Errorf("unexpected response: %x", rsp)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTCPTransporterContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		// Never answer
+		io.Copy(ioutil.Discard, conn)
+	}()
+	client := &tcpTransporter{
+		Address: ln.Addr().String(),
+		Timeout: 10 * time.Second,
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.SendContext(ctx, []byte{0, 1, 0, 0, 0, 2, 1, 2})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func BenchmarkTCPEncoder(b *testing.B) {
 	encoder := tcpPackager{
 		SlaveId: 10,
@@ -0,0 +1,251 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// Default number of connections in a pool
+	tcpPoolSize = 4
+	// Default interval between health checks of idle connections
+	tcpPoolHealthCheckInterval = 30 * time.Second
+)
+
+// PooledTCPClientHandler implements Packager and Transporter interface
+// over several connections to one device which accepts simultaneous
+// connections. Each request is sent on a free connection.
+type PooledTCPClientHandler struct {
+	tcpPackager
+	tcpPool
+}
+
+// NewPooledTCPClientHandler allocates a new PooledTCPClientHandler with
+// size connections.
+func NewPooledTCPClientHandler(address string, size int) *PooledTCPClientHandler {
+	h := &PooledTCPClientHandler{}
+	h.Address = address
+	h.Timeout = tcpTimeout
+	h.IdleTimeout = tcpIdleTimeout
+	h.Size = size
+	h.HealthCheckInterval = tcpPoolHealthCheckInterval
+	h.packager = &h.tcpPackager
+	return h
+}
+
+// PooledTCPClient creates TCP client with a pool of size connections to
+// the given connect string.
+func PooledTCPClient(address string, size int) Client {
+	handler := NewPooledTCPClientHandler(address, size)
+	return NewClient(handler)
+}
+
+// tcpPool implements Transporter interface.
+type tcpPool struct {
+	// Connect string
+	Address string
+	// Connect & Read timeout
+	Timeout time.Duration
+	// Idle timeout to close a connection
+	IdleTimeout time.Duration
+	// Number of connections, defaults to 4
+	Size int
+	// HealthCheck is run with every idle connection each
+	// HealthCheckInterval, zero disabling the checks. The connection is
+	// closed if it fails and will be reopened on next use. It defaults to
+	// reading holding register 0, an exception response passing the check
+	// as the device answered.
+	HealthCheck         func(client Client) error
+	HealthCheckInterval time.Duration
+	// Transmission logger
+	Logger *log.Logger
+	// LeveledLogger, if set, receives the transmission log entries with
+	// structured fields instead of Logger
+	LeveledLogger LeveledLogger
+
+	packager Packager
+
+	mu      sync.Mutex
+	members []*tcpTransporter
+	free    chan *tcpTransporter
+	done    chan struct{}
+}
+
+// Send sends data to server on a free connection.
+func (mb *tcpPool) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but abandons the request when ctx is done.
+func (mb *tcpPool) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
+	free := mb.init()
+	var member *tcpTransporter
+	select {
+	case member = <-free:
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+	defer func() { free <- member }()
+
+	return member.SendContext(ctx, aduRequest)
+}
+
+// Connect establishes all connections of the pool.
+func (mb *tcpPool) Connect() (err error) {
+	mb.init()
+
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	for _, member := range mb.members {
+		if err = member.Connect(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Close closes all connections and stops health checks.
+func (mb *tcpPool) Close() (err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.members == nil {
+		return
+	}
+	close(mb.done)
+	for _, member := range mb.members {
+		if e := member.Close(); e != nil {
+			err = e
+		}
+	}
+	mb.members = nil
+	return
+}
+
+func (mb *tcpPool) setTimeout(timeout time.Duration) (previous time.Duration, ok bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	previous, mb.Timeout = mb.Timeout, timeout
+	for _, member := range mb.members {
+		member.setTimeout(timeout)
+	}
+	return previous, true
+}
+
+// init creates the members of the pool if needed and returns the channel
+// of free members.
+func (mb *tcpPool) init() chan *tcpTransporter {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if mb.members != nil {
+		return mb.free
+	}
+	size := mb.Size
+	if size <= 0 {
+		size = tcpPoolSize
+	}
+	mb.members = make([]*tcpTransporter, size)
+	mb.free = make(chan *tcpTransporter, size)
+	mb.done = make(chan struct{})
+	for i := range mb.members {
+		member := &tcpTransporter{
+			Address:       mb.Address,
+			Timeout:       mb.Timeout,
+			IdleTimeout:   mb.IdleTimeout,
+			Logger:        mb.Logger,
+			LeveledLogger: mb.LeveledLogger,
+		}
+		mb.members[i] = member
+		mb.free <- member
+	}
+	if mb.HealthCheckInterval > 0 {
+		go mb.healthCheckLoop(mb.free, mb.done)
+	}
+	return mb.free
+}
+
+// healthCheckLoop checks idle connections until done is closed.
+func (mb *tcpPool) healthCheckLoop(free chan *tcpTransporter, done chan struct{}) {
+	ticker := time.NewTicker(mb.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			mb.healthCheck(free)
+		case <-done:
+			return
+		}
+	}
+}
+
+// healthCheck runs HealthCheck on the connected members which are
+// currently free. Members in use are skipped, members idle for
+// IdleTimeout are closed instead. The checks are not activity: the idle
+// time of a member is restored after its check.
+func (mb *tcpPool) healthCheck(free chan *tcpTransporter) {
+	n := len(free)
+	for i := 0; i < n; i++ {
+		var member *tcpTransporter
+		select {
+		case member = <-free:
+		default:
+			return
+		}
+		member.mu.Lock()
+		connected := member.conn != nil
+		lastActivity := member.lastActivity
+		if idle := time.Since(lastActivity); connected && member.IdleTimeout > 0 && !lastActivity.IsZero() && idle >= member.IdleTimeout {
+			mb.log(LogLevelInfo, "modbus: closing idle pooled connection", LogField{"idle", idle})
+			member.close()
+			connected = false
+		}
+		member.mu.Unlock()
+		if connected {
+			check := mb.HealthCheck
+			if check == nil {
+				check = tcpPoolHealthCheck
+			}
+			if err := check(NewClient2(mb.packager, member)); err != nil {
+				mb.log(LogLevelWarn, "modbus: closing pooled connection after failed health check", LogField{"error", err.Error()})
+				member.Close()
+			}
+			member.mu.Lock()
+			member.lastActivity = lastActivity
+			member.mu.Unlock()
+		}
+		free <- member
+	}
+}
+
+// tcpPoolHealthCheck reads holding register 0. Exception responses pass
+// the check.
+func tcpPoolHealthCheck(client Client) error {
+	_, err := client.ReadHoldingRegisters(0, 1)
+	var modbusError *ModbusError
+	if errors.As(err, &modbusError) {
+		return nil
+	}
+	return err
+}
+
+// log writes an entry with the address of the pool to LeveledLogger or
+// Logger.
+func (mb *tcpPool) log(level LogLevel, msg string, fields ...LogField) {
+	if mb.LeveledLogger == nil && mb.Logger == nil {
+		return
+	}
+	fields = append([]LogField{{"address", mb.Address}}, fields...)
+	transportLog(mb.LeveledLogger, mb.Logger, context.Background(), level, msg, fields...)
+}
@@ -0,0 +1,164 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPooledTCPClientHandler(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var connections int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&connections, 1)
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	handler := NewPooledTCPClientHandler(ln.Addr().String(), 2)
+	handler.Timeout = time.Second
+	defer handler.Close()
+	if err = handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 8; i++ {
+		req := []byte{0, byte(i), 0, 0, 0, 2, 1, byte(i)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp, err := handler.Send(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(req, rsp) {
+				t.Errorf("unexpected response: %x", rsp)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&connections); n != 2 {
+		t.Fatalf("connections: expected %v, actual %v", 2, n)
+	}
+}
+
+func TestPooledTCPClientHandlerHealthCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var checks int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var request [12]byte
+				for {
+					if _, err := io.ReadFull(conn, request[:]); err != nil {
+						return
+					}
+					// Answer the first check with illegal data address,
+					// then stop answering
+					if atomic.AddInt32(&checks, 1) > 1 {
+						continue
+					}
+					conn.Write([]byte{request[0], request[1], 0, 0, 0, 3, request[6], 0x83, 0x02})
+				}
+			}()
+		}
+	}()
+
+	handler := NewPooledTCPClientHandler(ln.Addr().String(), 1)
+	handler.Timeout = 50 * time.Millisecond
+	handler.HealthCheckInterval = 100 * time.Millisecond
+	defer handler.Close()
+	if err = handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	member := handler.members[0]
+	connected := func() bool {
+		member.mu.Lock()
+		defer member.mu.Unlock()
+		return member.conn != nil
+	}
+	time.Sleep(150 * time.Millisecond)
+	if n := atomic.LoadInt32(&checks); n != 1 || !connected() {
+		t.Fatalf("connection closed after %v checks", n)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if connected() {
+		t.Fatal("connection not closed after a failed check")
+	}
+}
+
+func TestPooledTCPClientHandlerHealthCheckIdle(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	handler := NewPooledTCPClientHandler(ln.Addr().String(), 1)
+	handler.Timeout = time.Second
+	handler.IdleTimeout = 100 * time.Millisecond
+	handler.HealthCheckInterval = 30 * time.Millisecond
+	handler.HealthCheck = func(client Client) error {
+		// The echoed request is not a valid response but is received
+		client.ReadHoldingRegisters(0, 1)
+		return nil
+	}
+	defer handler.Close()
+	if _, err = handler.Send([]byte{0, 1, 0, 0, 0, 2, 1, 1}); err != nil {
+		t.Fatal(err)
+	}
+	member := handler.members[0]
+	// The checks do not keep the connection open
+	time.Sleep(300 * time.Millisecond)
+	member.mu.Lock()
+	defer member.mu.Unlock()
+	if member.conn != nil {
+		t.Fatal("idle connection not closed")
+	}
+}
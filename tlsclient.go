@@ -5,9 +5,9 @@
 package modbus
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/binary"
-	"fmt"
 	"io"
 	"log"
 	"net"
@@ -52,6 +52,9 @@ type tlsTransporter struct {
 	IdleTimeout time.Duration
 	// Transmission logger
 	Logger *log.Logger
+	// LeveledLogger, if set, receives the transmission log entries with
+	// structured fields instead of Logger
+	LeveledLogger LeveledLogger
 
 	// TCP connection
 	mu           sync.Mutex
@@ -61,13 +64,34 @@ type tlsTransporter struct {
 
 	key, crt string
 	insecure bool
+
+	// Number of connections established
+	connects int
 }
 
 // Send sends data to server and ensures response length is greater than header length.
 func (mb *tlsTransporter) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	return mb.SendContext(context.Background(), aduRequest)
+}
+
+// SendContext is like Send but abandons the request when ctx is done.
+// The connection is closed in that case as the response may still arrive.
+func (mb *tlsTransporter) SendContext(ctx context.Context, aduRequest []byte) (aduResponse []byte, err error) {
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
 
+	if tx := TransactionFromContext(ctx); tx != nil {
+		tx.Transport = "tls"
+		tx.Address = mb.Address
+		tx.TransactionId = binary.BigEndian.Uint16(aduRequest)
+		defer func(connects int) {
+			tx.Reconnects = reconnects(connects, mb.connects)
+		}(mb.connects)
+	}
+
+	if err = ctx.Err(); err != nil {
+		return
+	}
 	// Establish a new connection if not connected
 	if err = mb.connect(); err != nil {
 		return
@@ -80,11 +104,24 @@ func (mb *tlsTransporter) Send(aduRequest []byte) (aduResponse []byte, err error
 	if mb.Timeout > 0 {
 		timeout = mb.lastActivity.Add(mb.Timeout)
 	}
+	if deadline, ok := ctx.Deadline(); ok && (timeout.IsZero() || deadline.Before(timeout)) {
+		timeout = deadline
+	}
 	if err = mb.conn.SetDeadline(timeout); err != nil {
 		return
 	}
+	conn := mb.conn
+	stop := watchContext(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer func() {
+		if interrupted := stop(); err != nil && (interrupted || ctx.Err() != nil) {
+			mb.close()
+			aduResponse, err = nil, ctx.Err()
+		}
+	}()
 	// Send data
-	mb.logf("modbus: sending % x", aduRequest)
+	mb.log(ctx, LogLevelDebug, "modbus: sending", LogField{"adu", hexBytes(aduRequest)})
 	if _, err = mb.conn.Write(aduRequest); err != nil {
 		return
 	}
@@ -97,12 +134,12 @@ func (mb *tlsTransporter) Send(aduRequest []byte) (aduResponse []byte, err error
 	length := int(binary.BigEndian.Uint16(data[4:]))
 	if length <= 0 {
 		mb.flush(data[:])
-		err = fmt.Errorf("modbus: length in response header '%v' must not be zero", length)
+		err = newFrameError(ErrInvalidFrame, nil, data[:tcpHeaderSize], "modbus: length in response header '%v' must not be zero", length)
 		return
 	}
 	if length > (tcpMaxLength - (tcpHeaderSize - 1)) {
 		mb.flush(data[:])
-		err = fmt.Errorf("modbus: length in response header '%v' must not greater than '%v'", length, tcpMaxLength-tcpHeaderSize+1)
+		err = newFrameError(ErrInvalidFrame, nil, data[:tcpHeaderSize], "modbus: length in response header '%v' must not greater than '%v'", length, tcpMaxLength-tcpHeaderSize+1)
 		return
 	}
 	// Skip unit id
@@ -111,7 +148,7 @@ func (mb *tlsTransporter) Send(aduRequest []byte) (aduResponse []byte, err error
 		return
 	}
 	aduResponse = data[:length]
-	mb.logf("modbus: received % x\n", aduResponse)
+	mb.log(ctx, LogLevelDebug, "modbus: received", LogField{"adu", hexBytes(aduResponse)})
 	return
 }
 
@@ -144,6 +181,7 @@ func (mb *tlsTransporter) connect() error {
 		}
 
 		mb.conn = conn
+		mb.connects++
 	}
 
 	return nil
@@ -160,6 +198,14 @@ func (mb *tlsTransporter) startCloseTimer() {
 	}
 }
 
+func (mb *tlsTransporter) setTimeout(timeout time.Duration) (previous time.Duration, ok bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	previous, mb.Timeout = mb.Timeout, timeout
+	return previous, true
+}
+
 // Close closes current connection.
 func (mb *tlsTransporter) Close() error {
 	mb.mu.Lock()
@@ -184,10 +230,14 @@ func (mb *tlsTransporter) flush(b []byte) (err error) {
 	return
 }
 
-func (mb *tlsTransporter) logf(format string, v ...interface{}) {
-	if mb.Logger != nil {
-		mb.Logger.Printf(format, v...)
+// log writes an entry with the address of the connection to
+// LeveledLogger or Logger.
+func (mb *tlsTransporter) log(ctx context.Context, level LogLevel, msg string, fields ...LogField) {
+	if mb.LeveledLogger == nil && mb.Logger == nil {
+		return
 	}
+	fields = append([]LogField{{"address", mb.Address}}, fields...)
+	transportLog(mb.LeveledLogger, mb.Logger, ctx, level, msg, fields...)
 }
 
 // closeLocked closes current connection. Caller must hold the mutex before calling this method.
@@ -209,7 +259,7 @@ func (mb *tlsTransporter) closeIdle() {
 	}
 	idle := time.Now().Sub(mb.lastActivity)
 	if idle >= mb.IdleTimeout {
-		mb.logf("modbus: closing connection due to idle timeout: %v", idle)
+		mb.log(context.Background(), LogLevelInfo, "modbus: closing idle connection", LogField{"idle", idle})
 		mb.close()
 	}
 }
@@ -0,0 +1,83 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"time"
+)
+
+// Transaction describes one request sent by a Client. It is filled in
+// while the request passes through the interceptors and the transport,
+// and can be retrieved by interceptors with TransactionFromContext once
+// the next RoundTripper has returned. When a request is sent several
+// times, e.g. by a retrying interceptor, it describes the last attempt.
+type Transaction struct {
+	// Transport is "tcp", "tls", "rtu" or "ascii".
+	Transport string
+	// Address of the remote device or serial port.
+	Address      string
+	SlaveId      byte
+	FunctionCode byte
+	// TransactionId is the MBAP transaction id, TCP and TLS only.
+	TransactionId uint16
+	// Encoded request and response frames.
+	Request  []byte
+	Response []byte
+	// Start of the transaction and time spent in the transport.
+	Start   time.Time
+	Latency time.Duration
+	// Connections established by the transport while sending, not
+	// counting the first connection of the transport.
+	Reconnects int
+	// Err is the error of the last attempt, including exceptions, and
+	// ErrorClass its origin, one of the ErrorClass constants.
+	Err        error
+	ErrorClass string
+}
+
+// Origins of the error of a transaction.
+const (
+	// ErrorClassTimeout is a response not received in time.
+	ErrorClassTimeout = "timeout"
+	// ErrorClassTransport is any other failure to send or receive.
+	ErrorClassTransport = "transport"
+	// ErrorClassFrame is a response not matching the request, e.g. with
+	// a different transaction or slave id.
+	ErrorClassFrame = "frame"
+	// ErrorClassChecksum is a corrupted response, e.g. with a CRC or LRC
+	// mismatch.
+	ErrorClassChecksum = "checksum"
+	// ErrorClassException is an exception response of the device.
+	ErrorClassException = "exception"
+	// ErrorClassGateway is a gateway path unavailable or gateway target
+	// device failed to respond exception, a routing fault rather than a
+	// fault of the device.
+	ErrorClassGateway = "gateway"
+)
+
+type transactionKey struct{}
+
+// TransactionFromContext returns the transaction of the request being
+// sent with ctx, or nil if there is none.
+func TransactionFromContext(ctx context.Context) *Transaction {
+	tx, _ := ctx.Value(transactionKey{}).(*Transaction)
+	return tx
+}
+
+// reconnects returns the number of connections established between two
+// counts of a transport, not counting its first connection.
+func reconnects(before, after int) int {
+	n := after - before
+	if before == 0 && n > 0 {
+		n--
+	}
+	return n
+}
+
+// withTransaction returns a context carrying tx.
+func withTransaction(ctx context.Context, tx *Transaction) context.Context {
+	return context.WithValue(ctx, transactionKey{}, tx)
+}
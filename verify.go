@@ -0,0 +1,143 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+)
+
+// VerifyingClient is a Client reading back the coils and holding
+// registers it writes. A write whose values read back differ from the
+// values written fails with a *VerifyError, a read back failing with its
+// error. The response of the write is returned in both cases.
+type VerifyingClient struct {
+	ContextClient
+
+	// Tolerances are the differences allowed between the value written
+	// to a holding register and the value read back, by address, for
+	// devices normalizing the values written. The difference is the
+	// smallest of the two ways around 0x10000, so that it holds for
+	// signed values as well.
+	Tolerances map[uint16]uint16
+}
+
+// NewVerifyingClient allocates a VerifyingClient of client without
+// tolerances.
+func NewVerifyingClient(client Client) *VerifyingClient {
+	return &VerifyingClient{ContextClient: NewContextClient(client)}
+}
+
+// verifyRegisters reads back quantity registers from address and
+// compares them to value.
+func (mb *VerifyingClient) verifyRegisters(ctx context.Context, address, quantity uint16, value []byte) error {
+	results, err := mb.ContextClient.ReadHoldingRegistersContext(ctx, address, quantity)
+	if err != nil {
+		return err
+	}
+	if len(results) < 2*int(quantity) || len(value) < 2*int(quantity) {
+		return newFrameError(ErrLengthMismatch, nil, nil, "modbus: read back size '%v' does not match expected '%v'", len(results), 2*quantity)
+	}
+	var mismatches []Mismatch
+	for i := 0; i < int(quantity); i++ {
+		written := binary.BigEndian.Uint16(value[2*i:])
+		read := binary.BigEndian.Uint16(results[2*i:])
+		difference := written - read
+		if read-written < difference {
+			difference = read - written
+		}
+		if difference > mb.Tolerances[address+uint16(i)] {
+			mismatches = append(mismatches, Mismatch{Address: address + uint16(i), Written: written, Read: read})
+		}
+	}
+	if mismatches != nil {
+		return &VerifyError{Space: SpaceHoldingRegisters, Mismatches: mismatches}
+	}
+	return nil
+}
+
+// verifyCoils reads back quantity coils from address and compares them
+// to the packed bits of value.
+func (mb *VerifyingClient) verifyCoils(ctx context.Context, address, quantity uint16, value []byte) error {
+	results, err := mb.ContextClient.ReadCoilsContext(ctx, address, quantity)
+	if err != nil {
+		return err
+	}
+	read, err := BitsFromBytes(results, int(quantity))
+	if err != nil {
+		return err
+	}
+	written, err := BitsFromBytes(value, int(quantity))
+	if err != nil {
+		return err
+	}
+	var mismatches []Mismatch
+	written.Range(func(i int, value bool) bool {
+		if read.Get(i) != value {
+			mismatch := Mismatch{Address: address + uint16(i), Written: 1}
+			if !value {
+				mismatch.Written, mismatch.Read = 0, 1
+			}
+			mismatches = append(mismatches, mismatch)
+		}
+		return true
+	})
+	if mismatches != nil {
+		return &VerifyError{Space: SpaceCoils, Mismatches: mismatches}
+	}
+	return nil
+}
+
+func (mb *VerifyingClient) WriteSingleCoil(address, value uint16) (results []byte, err error) {
+	return mb.WriteSingleCoilContext(context.Background(), address, value)
+}
+
+func (mb *VerifyingClient) WriteSingleCoilContext(ctx context.Context, address, value uint16) (results []byte, err error) {
+	if results, err = mb.ContextClient.WriteSingleCoilContext(ctx, address, value); err != nil {
+		return
+	}
+	var bit byte
+	if value == 0xFF00 {
+		bit = 1
+	}
+	err = mb.verifyCoils(ctx, address, 1, []byte{bit})
+	return
+}
+
+func (mb *VerifyingClient) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
+	return mb.WriteMultipleCoilsContext(context.Background(), address, quantity, value)
+}
+
+func (mb *VerifyingClient) WriteMultipleCoilsContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error) {
+	if results, err = mb.ContextClient.WriteMultipleCoilsContext(ctx, address, quantity, value); err != nil {
+		return
+	}
+	err = mb.verifyCoils(ctx, address, quantity, value)
+	return
+}
+
+func (mb *VerifyingClient) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	return mb.WriteSingleRegisterContext(context.Background(), address, value)
+}
+
+func (mb *VerifyingClient) WriteSingleRegisterContext(ctx context.Context, address, value uint16) (results []byte, err error) {
+	if results, err = mb.ContextClient.WriteSingleRegisterContext(ctx, address, value); err != nil {
+		return
+	}
+	err = mb.verifyRegisters(ctx, address, 1, dataBlock(value))
+	return
+}
+
+func (mb *VerifyingClient) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	return mb.WriteMultipleRegistersContext(context.Background(), address, quantity, value)
+}
+
+func (mb *VerifyingClient) WriteMultipleRegistersContext(ctx context.Context, address, quantity uint16, value []byte) (results []byte, err error) {
+	if results, err = mb.ContextClient.WriteMultipleRegistersContext(ctx, address, quantity, value); err != nil {
+		return
+	}
+	err = mb.verifyRegisters(ctx, address, quantity, value)
+	return
+}
@@ -0,0 +1,102 @@
+// Copyright 2014 Quoc-Viet Nguyen. All rights reserved.
+// This software may be modified and distributed under the terms
+// of the BSD license. See the LICENSE file for details.
+
+package modbus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// clampingDevice is a testDevice clamping the holding registers written
+// to Max and ignoring writes to the coils from ReadOnlyCoil on.
+type clampingDevice struct {
+	testDevice
+
+	Max          uint16
+	ReadOnlyCoil int
+}
+
+func (d *clampingDevice) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	d.mu.Lock()
+	coils := d.coils
+	d.mu.Unlock()
+	aduResponse, err = d.testDevice.Send(aduRequest)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.holding {
+		if d.holding[i] > d.Max {
+			d.holding[i] = d.Max
+		}
+	}
+	copy(d.coils[d.ReadOnlyCoil:], coils[d.ReadOnlyCoil:])
+	return
+}
+
+func TestVerifyingClient(t *testing.T) {
+	device := &clampingDevice{Max: 1000, ReadOnlyCoil: 8}
+	client := NewVerifyingClient(NewClient(device))
+	ctx := context.Background()
+
+	if _, err := client.WriteSingleRegister(1, 500); err != nil {
+		t.Fatal(err)
+	}
+	_, err := client.WriteMultipleRegisters(10, 3, []byte{0x03, 0xE8, 0x03, 0xE9, 0x07, 0xD0})
+	var verifyError *VerifyError
+	if !errors.As(err, &verifyError) {
+		t.Fatalf("expected verify error, actual %v", err)
+	}
+	expected := []Mismatch{{Address: 11, Written: 1001, Read: 1000}, {Address: 12, Written: 2000, Read: 1000}}
+	if verifyError.Space != SpaceHoldingRegisters || !reflect.DeepEqual(expected, verifyError.Mismatches) {
+		t.Fatalf("unexpected error %+v", verifyError)
+	}
+	if !reflect.DeepEqual([]uint16{11, 12}, verifyError.Addresses()) {
+		t.Fatalf("unexpected addresses %v", verifyError.Addresses())
+	}
+
+	client.Tolerances = map[uint16]uint16{11: 1}
+	if _, err = client.WriteSingleRegister(11, 1001); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.WriteSingleRegister(12, 1002); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if _, err = WriteMultipleCoilsBool(ctx, client, 4, []bool{true, false, true, true, true, false}); !errors.As(err, &verifyError) {
+		t.Fatalf("expected verify error, actual %v", err)
+	}
+	expected = []Mismatch{{Address: 8, Written: 1, Read: 0}}
+	if verifyError.Space != SpaceCoils || !reflect.DeepEqual(expected, verifyError.Mismatches) {
+		t.Fatalf("unexpected error %+v", verifyError)
+	}
+	if _, err = client.WriteSingleCoil(7, 0xFF00); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readBackClient is a Client reading back Holding whatever is written.
+type readBackClient struct {
+	Client
+
+	Holding []byte
+}
+
+func (c *readBackClient) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	return c.Holding, nil
+}
+
+func TestVerifyToleranceWraps(t *testing.T) {
+	client := NewVerifyingClient(&readBackClient{Client: NewClient(&testDevice{}), Holding: []byte{0x00, 0x01}})
+	client.Tolerances = map[uint16]uint16{0: 2}
+	// -1 read back as 1
+	if _, err := client.WriteSingleRegister(0, 0xFFFF); err != nil {
+		t.Fatal(err)
+	}
+	client.Tolerances[0] = 1
+	if _, err := client.WriteSingleRegister(0, 0xFFFF); err == nil {
+		t.Fatal("expected error")
+	}
+}